@@ -1,15 +1,34 @@
 package cmd
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
 
+	"github.com/KKKKjl/tinykit/config"
+	"github.com/KKKKjl/tinykit/internal/registry"
+	"github.com/KKKKjl/tinykit/internal/registry/etcd"
 	"github.com/KKKKjl/tinykit/internal/server"
 )
 
 var (
+	configFile   string
+	outputFormat string
+
+	// register flags
+	service   registry.Service
+	ttl       time.Duration
+	endpoints []string
+	prefix    string
+
 	rootCmd = &cobra.Command{
 		Use: "tinykit",
 		Long: `
@@ -22,20 +41,146 @@ var (
 						 __/ |              
 						|___/               	   
 	`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
 	}
 
 	startCmd = &cobra.Command{
 		Use:   "start",
 		Short: "start the gateway server",
 		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Print(args)
-			server.Start()
+			server.Start(configFile)
+		},
+	}
+
+	validateCmd = &cobra.Command{
+		Use:   "validate",
+		Short: "validate the config file and report every error with its path",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := config.Load(configFile)
+			if err != nil {
+				return err
+			}
+
+			if err := server.Validate(c); err != nil {
+				errs, ok := err.(config.ValidationErrors)
+				if !ok {
+					return err
+				}
+
+				for _, v := range errs {
+					fmt.Fprintln(os.Stderr, v.Error())
+				}
+				return fmt.Errorf("config %s is invalid: %d error(s)", c.File(), len(errs))
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "config %s is valid\n", c.File())
+			return nil
+		},
+	}
+
+	registerCmd = &cobra.Command{
+		Use:   "register",
+		Short: "register a backend in etcd until interrupted, then deregister it",
+		Long: `Register a backend in etcd until interrupted, then deregister it.
+The etcd endpoints, prefix, credentials and tls are read from the registry.etcd config,
+the defaults are used if no config file is found.`,
+		Example: "  tinykit register --name greeter --addr http://10.0.0.1:8080 --version v1 --weight 2 --meta zone=a",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := config.Load(configFile)
+			if err != nil {
+				if configFile != "" || !errors.As(err, &viper.ConfigFileNotFoundError{}) {
+					return err
+				}
+				c = &config.Config{}
+			}
+
+			conf, err := server.EtcdConfig(c.Registry.Etcd)
+			if err != nil {
+				return err
+			}
+
+			if len(endpoints) > 0 {
+				conf.Nodes = endpoints
+			}
+
+			if prefix != "" {
+				conf.Prefix = prefix
+			}
+
+			registrar, err := etcd.NewRegistrar(conf, ttl)
+			if err != nil {
+				return err
+			}
+			defer registrar.Close()
+
+			if err := registrar.Register(&service); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "registered %s(%s), interrupt to deregister\n", service.Name, service.Addr)
+
+			waiter := make(chan os.Signal, 1)
+			signal.Notify(waiter, syscall.SIGINT, syscall.SIGTERM)
+			<-waiter
+
+			fmt.Fprintf(cmd.OutOrStdout(), "deregistered %s(%s)\n", service.Name, service.Addr)
+			return nil
+		},
+	}
+
+	configCmd = &cobra.Command{
+		Use:   "config",
+		Short: "inspect the gateway config",
+	}
+
+	dumpCmd = &cobra.Command{
+		Use:   "dump",
+		Short: "print the effective config merged from file, env TINYKIT_* and defaults, secrets redacted",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := config.Load(configFile)
+			if err != nil {
+				return err
+			}
+
+			switch outputFormat {
+			case "yaml":
+				encoder := yaml.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent(2)
+				defer encoder.Close()
+
+				return encoder.Encode(c.ToMap())
+			case "json":
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+
+				return encoder.Encode(c.ToMap())
+			default:
+				return fmt.Errorf("unknown output format %q, expected yaml or json", outputFormat)
+			}
 		},
 	}
 )
 
 func init() {
-	rootCmd.AddCommand(startCmd)
+	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "config file (default is ./config/config.yaml or ./config.yaml)")
+	dumpCmd.Flags().StringVarP(&outputFormat, "output", "o", "yaml", "output format, yaml or json")
+
+	registerCmd.Flags().StringVar(&service.Name, "name", "", "service name")
+	registerCmd.Flags().StringVar(&service.Addr, "addr", "", "service addr, e.g. http://10.0.0.1:8080")
+	registerCmd.Flags().StringVar(&service.Version, "version", "", "service version")
+	registerCmd.Flags().IntVar(&service.Weight, "weight", 1, "service weight")
+	registerCmd.Flags().StringToStringVar(&service.Metadata, "meta", nil, "service metadata, e.g. zone=a,env=prod")
+	registerCmd.Flags().DurationVar(&ttl, "ttl", 10*time.Second, "lease ttl, the service expires ttl after the process dies")
+	registerCmd.Flags().StringSliceVar(&endpoints, "endpoints", nil, "etcd endpoints, overrides registry.etcd.endpoints")
+	registerCmd.Flags().StringVar(&prefix, "prefix", "", "key prefix, overrides registry.etcd.prefix")
+	registerCmd.MarkFlagRequired("name")
+	registerCmd.MarkFlagRequired("addr")
+
+	configCmd.AddCommand(dumpCmd)
+	rootCmd.AddCommand(startCmd, validateCmd, registerCmd, configCmd)
 }
 
 func Execute() {
@@ -2,7 +2,12 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"time"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
 )
 
@@ -10,40 +15,244 @@ import (
 const (
 	_httpPort  = "8000"
 	_grpcPort  = "8080"
+	_adminAddr = ":9090"
 	_envPrefix = "tinykit"
 )
 
-func InitConfig() {
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath("./config/")
-	viper.AddConfigPath(".")
+type (
+	// Config is the typed gateway config decoded from config.yaml, env TINYKIT_* and defaults.
+	Config struct {
+		Version   string          `mapstructure:"version"`
+		Server    ServerConfig    `mapstructure:"server"`
+		Admin     AdminConfig     `mapstructure:"admin"`
+		Proxy     ProxyConfig     `mapstructure:"proxy"`   // proxy of requests matching no route
+		Filters   []FilterConfig  `mapstructure:"filters"` // filter chain of requests matching no route
+		Routes    []RouteConfig   `mapstructure:"routes"`
+		Registry  RegistryConfig  `mapstructure:"registry"`
+		RateLimit RateLimitConfig `mapstructure:"ratelimit"`
+		Pubsub    PubsubConfig    `mapstructure:"pubsub"`
 
-	viper.Set("Verbose", true)
+		file string
+	}
+
+	ServerConfig struct {
+		Listeners []ListenerConfig `mapstructure:"listeners"`
+		Timeout   time.Duration    `mapstructure:"timeout"` // deadline of a request, 0 means none
+	}
+
+	ListenerConfig struct {
+		Addr string    `mapstructure:"addr"`
+		TLS  TLSConfig `mapstructure:"tls"`
+	}
+
+	TLSConfig struct {
+		Enabled  bool   `mapstructure:"enabled"`
+		CertFile string `mapstructure:"certFile"`
+		KeyFile  string `mapstructure:"keyFile"`
+	}
+
+	// AdminConfig is the admin server serving pprof and admin endpoints.
+	AdminConfig struct {
+		Enabled bool   `mapstructure:"enabled"`
+		Addr    string `mapstructure:"addr"`
+	}
+
+	// FilterConfig is a filter entry, a plain string is decoded as the filter name.
+	FilterConfig struct {
+		Name   string                 `mapstructure:"name"`
+		Params map[string]interface{} `mapstructure:"params"`
+	}
+
+	// RegistryConfig is the service discovery backend: etcd, static, memory, dns or consul, it is not hot reloaded.
+	RegistryConfig struct {
+		Backend string               `mapstructure:"backend"`
+		Etcd    EtcdConfig           `mapstructure:"etcd"`
+		Static  StaticRegistryConfig `mapstructure:"static"`
+		DNS     DNSConfig            `mapstructure:"dns"`
+		Consul  ConsulConfig         `mapstructure:"consul"`
+	}
+
+	EtcdConfig struct {
+		Endpoints   []string      `mapstructure:"endpoints"`
+		DialTimeout time.Duration `mapstructure:"dialTimeout"`
+		Prefix      string        `mapstructure:"prefix"`
+		Username    string        `mapstructure:"username"`
+		Password    string        `mapstructure:"password" secret:"true"`
+		TLS         EtcdTLSConfig `mapstructure:"tls"`
+	}
+
+	// EtcdTLSConfig is the client certificate and the ca of the etcd server.
+	EtcdTLSConfig struct {
+		Enabled  bool   `mapstructure:"enabled"`
+		CertFile string `mapstructure:"certFile"`
+		KeyFile  string `mapstructure:"keyFile"`
+		CAFile   string `mapstructure:"caFile"`
+	}
+
+	// StaticRegistryConfig lists the services in the config file, or in a separate yaml or json file
+	// under the key services. Both files are watched and the services reloaded on change.
+	StaticRegistryConfig struct {
+		File     string          `mapstructure:"file"` // relative to the config file
+		Services []ServiceConfig `mapstructure:"services"`
+	}
+
+	// DNSConfig resolves the upstream services by dns, a service is a host name with an optional port
+	// resolved by A/AAAA records, or a SRV name starting with an underscore. The relative names are
+	// completed by the search domains like the system resolver.
+	DNSConfig struct {
+		Server   string        `mapstructure:"server"` // defaults to the nameserver of /etc/resolv.conf
+		Search   []string      `mapstructure:"search"` // defaults to the search domains of /etc/resolv.conf with the default server
+		Ndots    int           `mapstructure:"ndots"`  // defaults to the ndots of /etc/resolv.conf with the default server, else 1
+		Scheme   string        `mapstructure:"scheme"`
+		Port     int           `mapstructure:"port"` // port of the host names without one
+		Interval time.Duration `mapstructure:"interval"`
+		Timeout  time.Duration `mapstructure:"timeout"`
+	}
+
+	// ConsulConfig watches the passing instances of the upstream services in the consul catalog.
+	ConsulConfig struct {
+		Address    string        `mapstructure:"address"` // http://host:port of the consul agent
+		Token      string        `mapstructure:"token" secret:"true"`
+		Datacenter string        `mapstructure:"datacenter"`
+		Scheme     string        `mapstructure:"scheme"` // scheme of the service addrs
+		Wait       time.Duration `mapstructure:"wait"`   // max duration of a blocking query
+	}
+
+	ServiceConfig struct {
+		Name     string            `mapstructure:"name"`
+		Version  string            `mapstructure:"version"`
+		Addr     string            `mapstructure:"addr"`
+		Weight   int               `mapstructure:"weight"`
+		Metadata map[string]string `mapstructure:"metadata"`
+	}
+
+	// RateLimitConfig is the store shared by the ratelimit filters with param shared, it is not hot reloaded.
+	RateLimitConfig struct {
+		Store LimiterStoreConfig `mapstructure:"store"`
+	}
+
+	LimiterStoreConfig struct {
+		Backend string      `mapstructure:"backend"` // memory or redis
+		Redis   RedisConfig `mapstructure:"redis"`
+	}
 
-	addDefault()
+	RedisConfig struct {
+		Addr        string        `mapstructure:"addr"`
+		Password    string        `mapstructure:"password" secret:"true"`
+		DB          int           `mapstructure:"db"`
+		PoolSize    int           `mapstructure:"poolSize"`
+		DialTimeout time.Duration `mapstructure:"dialTimeout"`
+		Prefix      string        `mapstructure:"prefix"`
+	}
+
+	PubsubConfig struct {
+		Enabled         bool          `mapstructure:"enabled"`
+		Shards          int           `mapstructure:"shards"`
+		KeepAlive       time.Duration `mapstructure:"keepAlive"`
+		ReadBufferSize  int           `mapstructure:"readBufferSize"`
+		WriteBufferSize int           `mapstructure:"writeBufferSize"`
+	}
+)
 
-	if err := viper.ReadInConfig(); err != nil {
+// Load reads the config file, env TINYKIT_* and defaults into a Config.
+// If file is empty, config.yaml is searched in ./config/ and the working directory.
+// The returned config is not validated.
+func Load(file string) (*Config, error) {
+	v := viper.New()
+
+	if file != "" {
+		v.SetConfigFile(file)
+	} else {
+		v.SetConfigName("config")
+		v.SetConfigType("yaml")
+		v.AddConfigPath("./config/")
+		v.AddConfigPath(".")
+	}
+
+	addDefault(v)
+
+	// env, e.g. TINYKIT_REGISTRY_BACKEND overrides registry.backend
+	v.SetEnvPrefix(_envPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+
+	if err := v.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			panic(fmt.Errorf("config not found: %v", err))
-		} else {
-			panic(fmt.Errorf("config error: %v", err))
+			return nil, fmt.Errorf("config not found: %w", err)
+		}
+		return nil, fmt.Errorf("config error: %w", err)
+	}
+
+	var c Config
+	if err := v.Unmarshal(&c, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+		stringToFilterConfigHook,
+	))); err != nil {
+		return nil, fmt.Errorf("config decode error: %w", err)
+	}
+
+	if len(c.Server.Listeners) == 0 {
+		c.Server.Listeners = []ListenerConfig{
+			{Addr: net.JoinHostPort("0.0.0.0", _httpPort)},
 		}
 	}
+
+	c.file = v.ConfigFileUsed()
+
+	return &c, nil
 }
 
-func addDefault() {
-	// app
-	defaultVar("HTTP_PORT", _httpPort)
-	defaultVar("GRPC_PORT", _grpcPort)
-	defaultVar("SIGNING_AlGORITHM", "HS256")
+// File returns the path of the loaded config file.
+func (c *Config) File() string {
+	return c.file
+}
 
-	// env
-	viper.SetEnvPrefix(_envPrefix)
-	viper.BindEnv("jwt_secret")
+func addDefault(v *viper.Viper) {
+	// server
+	v.SetDefault("server.timeout", 5*time.Second)
+	v.SetDefault("admin.enabled", true)
+	v.SetDefault("admin.addr", _adminAddr)
+
+	// proxy
+	v.SetDefault("proxy.urlRewriteEnabled", false)
+	v.SetDefault("proxy.loadBalancingEnabled", true)
+	v.SetDefault("proxy.forceTlsEnabled", false)
+	v.SetDefault("proxy.balancer.type", "round_robin")
+
+	// registry
+	v.SetDefault("registry.backend", "etcd")
+	v.SetDefault("registry.etcd.endpoints", []string{"localhost:2379"})
+	v.SetDefault("registry.etcd.dialTimeout", 3*time.Second)
+	v.SetDefault("registry.etcd.prefix", "/discovery/")
+	v.SetDefault("registry.etcd.username", "")
+	v.SetDefault("registry.etcd.password", "") // known keys are read from env
+	v.SetDefault("registry.dns.scheme", "http")
+	v.SetDefault("registry.dns.interval", 30*time.Second)
+	v.SetDefault("registry.dns.timeout", 2*time.Second)
+	v.SetDefault("registry.consul.address", "http://127.0.0.1:8500")
+	v.SetDefault("registry.consul.scheme", "http")
+	v.SetDefault("registry.consul.wait", time.Minute)
+
+	// ratelimit
+	v.SetDefault("ratelimit.store.backend", "memory")
+	v.SetDefault("ratelimit.store.redis.poolSize", 10)
+	v.SetDefault("ratelimit.store.redis.dialTimeout", time.Second)
+	v.SetDefault("ratelimit.store.redis.prefix", "tinykit:ratelimit:")
+
+	// pubsub
+	v.SetDefault("pubsub.enabled", true)
+	v.SetDefault("pubsub.shards", 32)
+	v.SetDefault("pubsub.keepAlive", 10*time.Second)
+	v.SetDefault("pubsub.readBufferSize", 1024)
+	v.SetDefault("pubsub.writeBufferSize", 1024)
 }
 
-func defaultVar(key string, value interface{}) string {
-	viper.SetDefault(key, value)
-	return key
+// stringToFilterConfigHook allows filters to be written as `filters: [cors, ratelimit]`.
+func stringToFilterConfigHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.String || to != reflect.TypeOf(FilterConfig{}) {
+		return data, nil
+	}
+
+	return FilterConfig{Name: data.(string)}, nil
 }
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	assert := assert.New(t)
+
+	os.Setenv("TINYKIT_REGISTRY_ETCD_PREFIX", "/env/")
+	defer os.Unsetenv("TINYKIT_REGISTRY_ETCD_PREFIX")
+	os.Setenv("TINYKIT_REGISTRY_ETCD_PASSWORD", "secret")
+	defer os.Unsetenv("TINYKIT_REGISTRY_ETCD_PASSWORD")
+
+	c, err := Load("config.yaml")
+	assert.Nil(err)
+	assert.Nil(c.Validate())
+
+	assert.Equal("/env/", c.Registry.Etcd.Prefix)
+	assert.Equal("secret", c.Registry.Etcd.Password)
+	assert.Equal(":8080", c.Server.Listeners[0].Addr)
+	assert.Equal(5*time.Second, c.Server.Timeout)
+	assert.Equal("round_robin", c.Proxy.Balancer.Type)
+	assert.Equal(30*time.Second, c.Proxy.GRPC.Keepalive.Time)
+	assert.Equal(5*time.Minute, c.Proxy.GRPC.Descriptors.TTL)
+	assert.Equal("ratelimit", c.Filters[0].Name)
+	assert.Equal(100, c.Filters[0].Params["limit"])
+	assert.Equal("cors", c.Routes[0].Filters[0].Name)
+	assert.Equal("etcd", c.Registry.Backend)
+	assert.Equal("greeter", c.Registry.Static.Services[0].Name)
+	assert.Equal(map[string]string{"X-Canary": "true"}, c.Routes[1].Upstream.Subsets[1].Headers)
+	assert.Equal(10*time.Second, c.Pubsub.KeepAlive)
+}
+
+func TestLoadDefault(t *testing.T) {
+	assert := assert.New(t)
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	assert.Nil(ioutil.WriteFile(file, []byte("version: 1.0"), 0644))
+
+	c, err := Load(file)
+	assert.Nil(err)
+	assert.Nil(c.Validate())
+
+	assert.Equal("0.0.0.0:8000", c.Server.Listeners[0].Addr)
+	assert.Equal(":9090", c.Admin.Addr)
+	assert.True(c.Proxy.LoadBalancingEnabled)
+	assert.Equal([]string{"localhost:2379"}, c.Registry.Etcd.Endpoints)
+	assert.Equal(32, c.Pubsub.Shards)
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &Config{
+		Server: ServerConfig{
+			Listeners: []ListenerConfig{
+				{Addr: "8080"},
+				{Addr: ":443", TLS: TLSConfig{Enabled: true}},
+			},
+		},
+		Proxy: ProxyConfig{
+			Rewrite:          []RewriteConfig{{Pattern: "(/old", To: "/new"}},
+			HealthCheck:      HealthCheckConfig{Enabled: true, Type: "udp"},
+			OutlierDetection: OutlierConfig{Enabled: true, MaxEjectionPercent: 120},
+			StickySession:    StickyConfig{Enabled: true, SameSite: "none"},
+			GRPC:             GRPCConfig{IdleTimeout: -1, Descriptors: DescriptorConfig{TTL: -1, Files: []string{""}}, TLS: ClientTLSConfig{Enabled: true, CertFile: "cert.pem"}},
+		},
+		Filters: []FilterConfig{{}},
+		Routes: []RouteConfig{
+			{Name: "a", Path: "/a", Prefix: "/a"},
+			{Name: "a", Upstream: UpstreamConfig{Targets: []TargetConfig{{Addr: "localhost:8080"}}}},
+			{Name: "b", Upstream: UpstreamConfig{Version: "v1"}},
+			{Name: "c", Upstream: UpstreamConfig{Service: "c", Targets: []TargetConfig{{Addr: "http://localhost:8080"}}}},
+			{Name: "d", Upstream: UpstreamConfig{Service: "d", Version: "v1", Subsets: []SubsetConfig{{Weight: -1}, {Name: "x"}, {Name: "x"}}}},
+		},
+		Registry:  RegistryConfig{Backend: "zookeeper"},
+		RateLimit: RateLimitConfig{Store: LimiterStoreConfig{Backend: "redis"}},
+	}
+
+	err := c.Validate()
+	assert.NotNil(err)
+
+	paths := make([]string, 0)
+	for _, v := range err.(ValidationErrors) {
+		paths = append(paths, v.Path)
+	}
+
+	assert.ElementsMatch([]string{
+		"server.listeners[0].addr",
+		"server.listeners[1].tls.certFile",
+		"server.listeners[1].tls.keyFile",
+		"proxy.rewrite[0].pattern",
+		"proxy.healthCheck.type",
+		"proxy.outlierDetection.maxEjectionPercent",
+		"proxy.stickySession.secure",
+		"proxy.grpc.idleTimeout",
+		"proxy.grpc.tls",
+		"proxy.grpc.descriptors.ttl",
+		"proxy.grpc.descriptors.files[0]",
+		"filters[0].name",
+		"routes[0]",
+		"routes[1].name",
+		"routes[1].upstream.targets[0].addr",
+		"routes[2].upstream.service",
+		"routes[3].upstream",
+		"routes[4].upstream",
+		"routes[4].upstream.subsets",
+		"routes[4].upstream.subsets[0].name",
+		"routes[4].upstream.subsets[0].weight",
+		"routes[4].upstream.subsets[2].name",
+		"registry.backend",
+		"ratelimit.store.redis.addr",
+	}, paths)
+}
+
+func TestValidateStaticRegistry(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &Config{
+		Server: ServerConfig{Listeners: []ListenerConfig{{Addr: ":8080"}}},
+		Registry: RegistryConfig{
+			Backend: "static",
+			Static: StaticRegistryConfig{
+				Services: []ServiceConfig{
+					{Name: "a", Addr: "http://localhost:8080"},
+					{Addr: "localhost:8080", Weight: -1},
+				},
+			},
+		},
+	}
+
+	err := c.Validate()
+	assert.NotNil(err)
+
+	paths := make([]string, 0)
+	for _, v := range err.(ValidationErrors) {
+		paths = append(paths, v.Path)
+	}
+
+	assert.ElementsMatch([]string{
+		"registry.static.services[1].name",
+		"registry.static.services[1].weight",
+		"registry.static.services[1].addr",
+	}, paths)
+
+	c.Registry.Static.File = "services.yaml"
+	assert.Contains(c.Validate().Error(), "registry.static: only one of file and services can be set")
+
+	c.Registry = RegistryConfig{Backend: "consul", Consul: ConsulConfig{Address: "127.0.0.1:8500"}}
+	assert.Equal("registry.consul.address: invalid url \"127.0.0.1:8500\", expected scheme://host:port", c.Validate().Error())
+
+	c.Registry = RegistryConfig{Backend: "dns", DNS: DNSConfig{Server: "127.0.0.1", Port: 70000}}
+	assert.Equal("registry.dns.server: invalid addr \"127.0.0.1\": address 127.0.0.1: missing port in address\nregistry.dns.port: must be between 0 and 65535", c.Validate().Error())
+}
+
+func TestToMap(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &Config{
+		Server: ServerConfig{Timeout: time.Second},
+		RateLimit: RateLimitConfig{
+			Store: LimiterStoreConfig{Backend: "redis", Redis: RedisConfig{Addr: "localhost:6379", Password: "38324"}},
+		},
+		Filters: []FilterConfig{
+			{Name: "jwt", Params: map[string]interface{}{"secret": "38324", "header": "Authorization"}},
+		},
+	}
+
+	m := c.ToMap()
+	assert.Equal("1s", m["server"].(map[string]interface{})["timeout"])
+
+	redis := m["ratelimit"].(map[string]interface{})["store"].(map[string]interface{})["redis"].(map[string]interface{})
+	assert.Equal("localhost:6379", redis["addr"])
+	assert.Equal(_redacted, redis["password"])
+
+	params := m["filters"].([]interface{})[0].(map[string]interface{})["params"]
+	assert.Equal(map[string]interface{}{"secret": _redacted, "header": "Authorization"}, params)
+}
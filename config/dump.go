@@ -0,0 +1,84 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const _redacted = "******"
+
+// param names holding secrets, matched case insensitively
+var secretKeys = []string{"secret", "password", "token"}
+
+// ToMap converts the config to a map keyed by the config file names.
+// Fields tagged with `secret:"true"` and secret-like params are redacted.
+func (c *Config) ToMap() map[string]interface{} {
+	return toValue(reflect.ValueOf(*c), false).(map[string]interface{})
+}
+
+func toValue(v reflect.Value, secret bool) interface{} {
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		m := make(map[string]interface{}, t.NumField())
+
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+
+			name := f.Tag.Get("mapstructure")
+			if f.PkgPath != "" || name == "" || name == "-" {
+				continue
+			}
+
+			m[name] = toValue(v.Field(i), f.Tag.Get("secret") == "true")
+		}
+
+		return m
+	case reflect.Map:
+		m := make(map[string]interface{}, v.Len())
+
+		iter := v.MapRange()
+		for iter.Next() {
+			key := fmt.Sprint(iter.Key().Interface())
+			m[key] = toValue(iter.Value(), secret || isSecretKey(key))
+		}
+
+		return m
+	case reflect.Slice, reflect.Array:
+		s := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			s = append(s, toValue(v.Index(i), secret))
+		}
+
+		return s
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+
+		return toValue(v.Elem(), secret)
+	}
+
+	if secret && !v.IsZero() {
+		return _redacted
+	}
+
+	return v.Interface()
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, v := range secretKeys {
+		if strings.Contains(key, v) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,138 @@
+package config
+
+import "time"
+
+type (
+	// RouteConfig describes a single entry of the `routes` section.
+	RouteConfig struct {
+		Name     string         `mapstructure:"name"`
+		Host     string         `mapstructure:"host"`    // exact host or wildcard like *.example.com
+		Methods  []string       `mapstructure:"methods"` // empty means any method
+		Path     string         `mapstructure:"path"`    // exact path, supports params like /users/{id}
+		Prefix   string         `mapstructure:"prefix"`  // path prefix, supports params like /users/{id}/
+		Regex    string         `mapstructure:"regex"`   // path regex, named groups become path params
+		Upstream UpstreamConfig `mapstructure:"upstream"`
+		Filters  []FilterConfig `mapstructure:"filters"`
+		Proxy    ProxyConfig    `mapstructure:"proxy"`
+	}
+
+	// UpstreamConfig describes the backends of a route.
+	// If no targets are given, the backends are the instances of the service taken from service discovery,
+	// or all the discovered services if no service is given either.
+	UpstreamConfig struct {
+		Targets []TargetConfig `mapstructure:"targets"`
+		Service string         `mapstructure:"service"`
+		Version string         `mapstructure:"version"` // empty means any version
+		Subsets []SubsetConfig `mapstructure:"subsets"`
+	}
+
+	TargetConfig struct {
+		Addr     string            `mapstructure:"addr"`
+		Weight   int               `mapstructure:"weight"`
+		Version  string            `mapstructure:"version"`
+		Metadata map[string]string `mapstructure:"metadata"`
+	}
+
+	// SubsetConfig selects the backends of the version carrying all the labels, e.g. for canary releases.
+	// Requests carrying all the headers are routed to the subset, the others are split between the subsets
+	// by weight, adjust the weights and reload to roll out gradually.
+	SubsetConfig struct {
+		Name    string            `mapstructure:"name"`
+		Version string            `mapstructure:"version"`
+		Labels  map[string]string `mapstructure:"labels"`  // metadata of the backends
+		Weight  int               `mapstructure:"weight"`  // share of the requests matching no headers
+		Headers map[string]string `mapstructure:"headers"` // empty value only requires the header
+	}
+
+	ProxyConfig struct {
+		URLRewriteEnabled    bool              `mapstructure:"urlRewriteEnabled"`
+		LoadBalancingEnabled bool              `mapstructure:"loadBalancingEnabled"`
+		ForceTlsEnabled      bool              `mapstructure:"forceTlsEnabled"`
+		Balancer             BalancerConfig    `mapstructure:"balancer"`
+		Rewrite              []RewriteConfig   `mapstructure:"rewrite"` // matched in order
+		HealthCheck          HealthCheckConfig `mapstructure:"healthCheck"`
+		OutlierDetection     OutlierConfig     `mapstructure:"outlierDetection"`
+		StickySession        StickyConfig      `mapstructure:"stickySession"`
+		GRPC                 GRPCConfig        `mapstructure:"grpc"`
+	}
+
+	// HealthCheckConfig is the active health check of the upstream services.
+	HealthCheckConfig struct {
+		Enabled            bool          `mapstructure:"enabled"`
+		Type               string        `mapstructure:"type"`    // http, tcp or grpc, default tcp
+		Path               string        `mapstructure:"path"`    // path of the http check
+		Service            string        `mapstructure:"service"` // service of the grpc check, empty checks the server
+		Interval           time.Duration `mapstructure:"interval"`
+		Timeout            time.Duration `mapstructure:"timeout"`
+		HealthyThreshold   int           `mapstructure:"healthyThreshold"`
+		UnhealthyThreshold int           `mapstructure:"unhealthyThreshold"`
+	}
+
+	// OutlierConfig ejects the upstream services failing consecutively(5xx, unavailable or unreachable).
+	OutlierConfig struct {
+		Enabled            bool          `mapstructure:"enabled"`
+		ConsecutiveErrors  int           `mapstructure:"consecutiveErrors"`
+		BaseEjectionTime   time.Duration `mapstructure:"baseEjectionTime"` // doubled on every ejection
+		MaxEjectionTime    time.Duration `mapstructure:"maxEjectionTime"`
+		MaxEjectionPercent int           `mapstructure:"maxEjectionPercent"`
+	}
+
+	// StickyConfig binds clients to the service picked for their first request by a cookie holding an opaque id of the service.
+	// The secret must be set when running more than one replica, otherwise each one signs with its own.
+	StickyConfig struct {
+		Enabled  bool          `mapstructure:"enabled"`
+		Cookie   string        `mapstructure:"cookie"` // default tinykit_affinity
+		Secret   string        `mapstructure:"secret" secret:"true"`
+		Path     string        `mapstructure:"path"`
+		MaxAge   time.Duration `mapstructure:"maxAge"` // zero means a session cookie
+		Secure   bool          `mapstructure:"secure"`
+		HttpOnly bool          `mapstructure:"httpOnly"`
+		SameSite string        `mapstructure:"sameSite"` // lax, strict or none
+	}
+
+	// GRPCConfig is the pooled connections to the upstream rpc servers of the transcoded requests.
+	GRPCConfig struct {
+		IdleTimeout time.Duration       `mapstructure:"idleTimeout"` // unused connections are closed after it
+		Keepalive   GRPCKeepaliveConfig `mapstructure:"keepalive"`
+		TLS         ClientTLSConfig     `mapstructure:"tls"`
+		Descriptors DescriptorConfig    `mapstructure:"descriptors"`
+		Transcoding bool                `mapstructure:"transcoding"` // bind requests by the google.api.http options
+	}
+
+	// DescriptorConfig caches the service descriptors resolved by reflection, they are refreshed after ttl.
+	// The services of the files are used first, the files are descriptor sets written by
+	// protoc --descriptor_set_out --include_imports or .proto files relative to the import paths.
+	DescriptorConfig struct {
+		TTL         time.Duration `mapstructure:"ttl"`
+		Warmup      bool          `mapstructure:"warmup"` // resolve them once the servers are discovered
+		Files       []string      `mapstructure:"files"`
+		ImportPaths []string      `mapstructure:"importPaths"`
+	}
+
+	// GRPCKeepaliveConfig pings the servers after Time without activity, zero time disables the pings.
+	GRPCKeepaliveConfig struct {
+		Time                time.Duration `mapstructure:"time"`
+		Timeout             time.Duration `mapstructure:"timeout"`
+		PermitWithoutStream bool          `mapstructure:"permitWithoutStream"`
+	}
+
+	// ClientTLSConfig is the client certificate and the ca of the upstream servers.
+	ClientTLSConfig struct {
+		Enabled            bool   `mapstructure:"enabled"`
+		CertFile           string `mapstructure:"certFile"`
+		KeyFile            string `mapstructure:"keyFile"`
+		CAFile             string `mapstructure:"caFile"`
+		ServerName         string `mapstructure:"serverName"`
+		InsecureSkipVerify bool   `mapstructure:"insecureSkipVerify"`
+	}
+
+	BalancerConfig struct {
+		Type    string `mapstructure:"type"`    // round_robin, weight_round_robin, consistent_hashing, bounded_consistent_hashing, maglev, least_request, p2c or peak_ewma
+		HashKey string `mapstructure:"hashKey"` // key of the hashing balancers, e.g. header:X-User-Id or claim:sub, default ip
+	}
+
+	RewriteConfig struct {
+		Pattern string `mapstructure:"pattern"`
+		To      string `mapstructure:"to"`
+	}
+)
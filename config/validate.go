@@ -0,0 +1,403 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+type (
+	// FieldError is a validation error of a single config field.
+	FieldError struct {
+		Path    string // e.g. routes[0].upstream.targets[1].addr
+		Message string
+	}
+
+	// ValidationErrors collects every invalid field of a config.
+	ValidationErrors []*FieldError
+)
+
+func (e *FieldError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(v))
+	for _, e := range v {
+		msgs = append(msgs, e.Error())
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+func (v *ValidationErrors) Add(path string, format string, args ...interface{}) {
+	*v = append(*v, &FieldError{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Err returns nil if there is no error, so that a nil error interface is returned.
+func (v ValidationErrors) Err() error {
+	if len(v) == 0 {
+		return nil
+	}
+
+	return v
+}
+
+// Validate checks the config structure and returns ValidationErrors.
+// Names resolved at runtime like filters and balancers are checked by the server.
+func (c *Config) Validate() error {
+	var errs ValidationErrors
+
+	if len(c.Server.Listeners) == 0 {
+		errs.Add("server.listeners", "at least one listener is required")
+	}
+
+	for i, v := range c.Server.Listeners {
+		path := fmt.Sprintf("server.listeners[%d]", i)
+
+		validateAddr(&errs, path+".addr", v.Addr)
+
+		if v.TLS.Enabled {
+			if v.TLS.CertFile == "" {
+				errs.Add(path+".tls.certFile", "required when tls is enabled")
+			}
+			if v.TLS.KeyFile == "" {
+				errs.Add(path+".tls.keyFile", "required when tls is enabled")
+			}
+		}
+	}
+
+	if c.Server.Timeout < 0 {
+		errs.Add("server.timeout", "must not be negative")
+	}
+
+	if c.Admin.Enabled {
+		validateAddr(&errs, "admin.addr", c.Admin.Addr)
+	}
+
+	c.Proxy.validate(&errs, "proxy")
+	validateFilters(&errs, "filters", c.Filters)
+
+	names := make(map[string]int)
+	for i, v := range c.Routes {
+		path := fmt.Sprintf("routes[%d]", i)
+
+		if v.Name != "" {
+			if j, ok := names[v.Name]; ok {
+				errs.Add(path+".name", "duplicate route name %q, already used by routes[%d]", v.Name, j)
+			}
+			names[v.Name] = i
+		}
+
+		v.validate(&errs, path)
+	}
+
+	switch c.Registry.Backend {
+	case "etcd":
+		if len(c.Registry.Etcd.Endpoints) == 0 {
+			errs.Add("registry.etcd.endpoints", "at least one endpoint is required")
+		}
+
+		if tls := c.Registry.Etcd.TLS; tls.Enabled && (tls.CertFile == "") != (tls.KeyFile == "") {
+			errs.Add("registry.etcd.tls", "certFile and keyFile must be set together")
+		}
+	case "static":
+		c.Registry.Static.validate(&errs, "registry.static")
+	case "dns":
+		c.Registry.DNS.validate(&errs, "registry.dns")
+	case "consul":
+		if u, err := url.Parse(c.Registry.Consul.Address); err != nil || u.Scheme == "" || u.Host == "" {
+			errs.Add("registry.consul.address", "invalid url %q, expected scheme://host:port", c.Registry.Consul.Address)
+		}
+
+		if c.Registry.Consul.Wait < 0 {
+			errs.Add("registry.consul.wait", "must not be negative")
+		}
+	case "memory":
+	default:
+		errs.Add("registry.backend", "unknown backend %q", c.Registry.Backend)
+	}
+
+	switch c.RateLimit.Store.Backend {
+	case "", "memory":
+	case "redis":
+		if c.RateLimit.Store.Redis.Addr == "" {
+			errs.Add("ratelimit.store.redis.addr", "is required")
+		}
+	default:
+		errs.Add("ratelimit.store.backend", "unknown backend %q", c.RateLimit.Store.Backend)
+	}
+
+	if c.Pubsub.Enabled {
+		if c.Pubsub.Shards <= 0 {
+			errs.Add("pubsub.shards", "must be positive")
+		}
+		if c.Pubsub.KeepAlive <= 0 {
+			errs.Add("pubsub.keepAlive", "must be positive")
+		}
+	}
+
+	return errs.Err()
+}
+
+func (r *RouteConfig) validate(errs *ValidationErrors, path string) {
+	var set []string
+	for k, v := range map[string]string{"path": r.Path, "prefix": r.Prefix, "regex": r.Regex} {
+		if v != "" {
+			set = append(set, k)
+		}
+	}
+
+	if len(set) > 1 {
+		errs.Add(path, "only one of path, prefix and regex can be set")
+	}
+
+	if r.Regex != "" {
+		if _, err := regexp.Compile(r.Regex); err != nil {
+			errs.Add(path+".regex", "invalid regex: %v", err)
+		}
+	}
+
+	if r.Upstream.Service != "" && len(r.Upstream.Targets) > 0 {
+		errs.Add(path+".upstream", "only one of targets and service can be set")
+	}
+
+	if r.Upstream.Version != "" && r.Upstream.Service == "" {
+		errs.Add(path+".upstream.service", "required by version")
+	}
+
+	for i, v := range r.Upstream.Targets {
+		target := fmt.Sprintf("%s.upstream.targets[%d]", path, i)
+
+		if v.Weight < 0 {
+			errs.Add(target+".weight", "must not be negative")
+		}
+
+		if v.Addr == "" {
+			errs.Add(target+".addr", "required")
+			continue
+		}
+
+		if u, err := url.Parse(v.Addr); err != nil || u.Scheme == "" || u.Host == "" {
+			errs.Add(target+".addr", "invalid url %q, expected scheme://host:port", v.Addr)
+		}
+	}
+
+	if len(r.Upstream.Subsets) > 0 {
+		if r.Upstream.Version != "" {
+			errs.Add(path+".upstream", "only one of version and subsets can be set")
+		}
+
+		// the static targets are always load balanced
+		if len(r.Upstream.Targets) == 0 && !r.Proxy.LoadBalancingEnabled {
+			errs.Add(path+".upstream.subsets", "requires proxy.loadBalancingEnabled")
+		}
+	}
+
+	names := make(map[string]int, len(r.Upstream.Subsets))
+	for i, v := range r.Upstream.Subsets {
+		subset := fmt.Sprintf("%s.upstream.subsets[%d]", path, i)
+
+		if v.Name == "" {
+			errs.Add(subset+".name", "required")
+		} else if j, ok := names[v.Name]; ok {
+			errs.Add(subset+".name", "duplicate subset name %q, already used by subsets[%d]", v.Name, j)
+		} else {
+			names[v.Name] = i
+		}
+
+		if v.Weight < 0 {
+			errs.Add(subset+".weight", "must not be negative")
+		}
+	}
+
+	r.Proxy.validate(errs, path+".proxy")
+	validateFilters(errs, path+".filters", r.Filters)
+}
+
+func (p *ProxyConfig) validate(errs *ValidationErrors, path string) {
+	for i, v := range p.Rewrite {
+		rule := fmt.Sprintf("%s.rewrite[%d]", path, i)
+
+		if _, err := regexp.Compile(v.Pattern); err != nil {
+			errs.Add(rule+".pattern", "invalid regex: %v", err)
+		}
+
+		if v.To == "" {
+			errs.Add(rule+".to", "required")
+		}
+	}
+
+	if p.HealthCheck.Enabled {
+		p.HealthCheck.validate(errs, path+".healthCheck")
+	}
+
+	if p.OutlierDetection.Enabled {
+		p.OutlierDetection.validate(errs, path+".outlierDetection")
+	}
+
+	if p.StickySession.Enabled {
+		p.StickySession.validate(errs, path+".stickySession")
+	}
+
+	p.GRPC.validate(errs, path+".grpc")
+}
+
+func (g *GRPCConfig) validate(errs *ValidationErrors, path string) {
+	if g.IdleTimeout < 0 {
+		errs.Add(path+".idleTimeout", "must not be negative")
+	}
+
+	if g.Keepalive.Time < 0 {
+		errs.Add(path+".keepalive.time", "must not be negative")
+	}
+
+	if g.Keepalive.Timeout < 0 {
+		errs.Add(path+".keepalive.timeout", "must not be negative")
+	}
+
+	if g.Descriptors.TTL < 0 {
+		errs.Add(path+".descriptors.ttl", "must not be negative")
+	}
+
+	for i, v := range g.Descriptors.Files {
+		if v == "" {
+			errs.Add(fmt.Sprintf("%s.descriptors.files[%d]", path, i), "is required")
+		}
+	}
+
+	if g.TLS.Enabled && (g.TLS.CertFile == "") != (g.TLS.KeyFile == "") {
+		errs.Add(path+".tls", "certFile and keyFile must be set together")
+	}
+}
+
+func (s *StickyConfig) validate(errs *ValidationErrors, path string) {
+	switch strings.ToLower(s.SameSite) {
+	case "", "lax", "strict":
+	case "none":
+		if !s.Secure {
+			errs.Add(path+".secure", "required by sameSite none")
+		}
+	default:
+		errs.Add(path+".sameSite", "unknown value %q, expected lax, strict or none", s.SameSite)
+	}
+
+	if s.MaxAge < 0 {
+		errs.Add(path+".maxAge", "must not be negative")
+	}
+}
+
+func (o *OutlierConfig) validate(errs *ValidationErrors, path string) {
+	if o.ConsecutiveErrors < 0 {
+		errs.Add(path+".consecutiveErrors", "must not be negative")
+	}
+
+	if o.BaseEjectionTime < 0 {
+		errs.Add(path+".baseEjectionTime", "must not be negative")
+	}
+
+	if o.MaxEjectionTime < 0 {
+		errs.Add(path+".maxEjectionTime", "must not be negative")
+	}
+
+	if o.MaxEjectionPercent < 0 || o.MaxEjectionPercent > 100 {
+		errs.Add(path+".maxEjectionPercent", "must be between 0 and 100")
+	}
+}
+
+func (h *HealthCheckConfig) validate(errs *ValidationErrors, path string) {
+	switch h.Type {
+	case "", "tcp", "http", "grpc":
+	default:
+		errs.Add(path+".type", "unknown type %q, expected http, tcp or grpc", h.Type)
+	}
+
+	if h.Interval < 0 {
+		errs.Add(path+".interval", "must not be negative")
+	}
+
+	if h.Timeout < 0 {
+		errs.Add(path+".timeout", "must not be negative")
+	}
+
+	if h.HealthyThreshold < 0 {
+		errs.Add(path+".healthyThreshold", "must not be negative")
+	}
+
+	if h.UnhealthyThreshold < 0 {
+		errs.Add(path+".unhealthyThreshold", "must not be negative")
+	}
+}
+
+func validateFilters(errs *ValidationErrors, path string, filters []FilterConfig) {
+	for i, v := range filters {
+		if v.Name == "" {
+			errs.Add(fmt.Sprintf("%s[%d].name", path, i), "required")
+		}
+	}
+}
+
+func validateAddr(errs *ValidationErrors, path string, addr string) {
+	if addr == "" {
+		errs.Add(path, "required")
+		return
+	}
+
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		errs.Add(path, "invalid addr %q: %v", addr, err)
+	}
+}
+
+func (c *DNSConfig) validate(errs *ValidationErrors, path string) {
+	if c.Server != "" {
+		validateAddr(errs, path+".server", c.Server)
+	}
+
+	if c.Port < 0 || c.Port > 65535 {
+		errs.Add(path+".port", "must be between 0 and 65535")
+	}
+
+	if c.Ndots < 0 {
+		errs.Add(path+".ndots", "must not be negative")
+	}
+
+	if c.Interval < 0 {
+		errs.Add(path+".interval", "must not be negative")
+	}
+
+	if c.Timeout < 0 {
+		errs.Add(path+".timeout", "must not be negative")
+	}
+}
+
+func (c *StaticRegistryConfig) validate(errs *ValidationErrors, path string) {
+	if c.File != "" && len(c.Services) > 0 {
+		errs.Add(path, "only one of file and services can be set")
+	}
+
+	for i, v := range c.Services {
+		service := fmt.Sprintf("%s.services[%d]", path, i)
+
+		if v.Name == "" {
+			errs.Add(service+".name", "required")
+		}
+
+		if v.Weight < 0 {
+			errs.Add(service+".weight", "must not be negative")
+		}
+
+		if v.Addr == "" {
+			errs.Add(service+".addr", "required")
+			continue
+		}
+
+		if u, err := url.Parse(v.Addr); err != nil || u.Scheme == "" || u.Host == "" {
+			errs.Add(service+".addr", "invalid url %q, expected scheme://host:port", v.Addr)
+		}
+	}
+}
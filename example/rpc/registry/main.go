@@ -4,35 +4,39 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"time"
 
 	"github.com/KKKKjl/tinykit/internal/registry"
 	"github.com/KKKKjl/tinykit/internal/registry/etcd"
 )
 
 func main() {
-	client, err := etcd.NewEtcdClient()
+	registrar, err := etcd.NewRegistrar(nil, 10*time.Second)
 	if err != nil {
 		panic(err)
 	}
-	defer client.Close()
+	// deregister on exit
+	defer registrar.Close()
 
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt)
 
-	client.Register(&registry.Service{
+	if err := registrar.Register(&registry.Service{
 		Name: "node1",
 		Addr: "http://localhost:8090",
-	}, 10)
+	}); err != nil {
+		log.Println(err)
+	}
 
-	// client.Register(&registry.Service{
+	// registrar.Register(&registry.Service{
 	// 	Name: "node2",
 	// 	Addr: "http://localhost:8086",
-	// }, 10)
+	// })
 
-	// client.Register(&registry.Service{
+	// registrar.Register(&registry.Service{
 	// 	Name: "node3",
 	// 	Addr: "http://localhost:8087",
-	// }, 10)
+	// })
 
 	select {
 	case <-interrupt:
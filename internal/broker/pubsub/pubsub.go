@@ -25,23 +25,42 @@ type Bucket struct {
 type Pubsub struct {
 	mu     sync.RWMutex
 	topics map[string][]*Bucket // Reduced lock granularity in 32 segments (SHARE_COUNT)
+	shards int
 	logger *logrus.Logger
 }
 
-func New() *Pubsub {
-	return &Pubsub{
+type Option func(*Pubsub)
+
+// WithShards sets the number of buckets per topic, default SHARE_COUNT.
+func WithShards(shards int) Option {
+	return func(ps *Pubsub) {
+		if shards > 0 {
+			ps.shards = shards
+		}
+	}
+}
+
+func New(opts ...Option) *Pubsub {
+	ps := &Pubsub{
 		topics: make(map[string][]*Bucket),
+		shards: SHARE_COUNT,
 		logger: logger.GetLogger(),
 		mu:     sync.RWMutex{},
 	}
+
+	for _, opt := range opts {
+		opt(ps)
+	}
+
+	return ps
 }
 
 func (ps *Pubsub) add(topic string, node *broker.Node) error {
 	ps.mu.Lock()
 	if _, ok := ps.topics[topic]; !ok {
-		ps.topics[topic] = make([]*Bucket, 0, SHARE_COUNT)
+		ps.topics[topic] = make([]*Bucket, 0, ps.shards)
 
-		for i := 0; i < SHARE_COUNT; i++ {
+		for i := 0; i < ps.shards; i++ {
 			ps.topics[topic] = append(ps.topics[topic], &Bucket{
 				idx:         i,
 				subscribers: make(map[uint64]*broker.Node),
@@ -153,7 +172,7 @@ func (ps *Pubsub) TopicSubscribersList(topic string) []uint64 {
 }
 
 func (ps *Pubsub) getShareBucket(key string, buckets []*Bucket) *Bucket {
-	return buckets[uint(fnv32(key))%uint(SHARE_COUNT)]
+	return buckets[uint(fnv32(key))%uint(len(buckets))]
 }
 
 // func (h *Pubsub) ClearWs() error {
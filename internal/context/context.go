@@ -1,10 +1,14 @@
 package context
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"strconv"
 
 	"github.com/KKKKjl/tinykit/internal/response"
 )
@@ -12,6 +16,8 @@ import (
 type IHttpContext interface {
 }
 
+type claimsKey struct{}
+
 type HttpContext struct {
 	ResponseWriter http.ResponseWriter
 	Request        *http.Request
@@ -19,6 +25,8 @@ type HttpContext struct {
 	Method         string
 	Err            error
 	MetaData       map[string]interface{}
+	Params         map[string]string // path params captured by the matched route
+	Response       *http.Response    // upstream response, only set in response filters
 }
 
 func New(w http.ResponseWriter, r *http.Request) HttpContext {
@@ -27,20 +35,33 @@ func New(w http.ResponseWriter, r *http.Request) HttpContext {
 		Request:        r,
 		Method:         r.Method,
 		MetaData:       make(map[string]interface{}),
+		Params:         make(map[string]string),
 		IsAbort:        false,
 		Err:            nil,
 	}
 }
 
+// SetValue stores the value in the request context, the context must be passed to next to be visible downstream.
 func (c *HttpContext) SetValue(key interface{}, value interface{}) {
 	ctx := context.WithValue(c.Request.Context(), key, value)
-	c.Request.WithContext(ctx)
+	c.Request = c.Request.WithContext(ctx)
 }
 
 func (c *HttpContext) GetValue(key interface{}) interface{} {
 	return c.Request.Context().Value(key)
 }
 
+// SetClaims stores the claims of the authenticated token, e.g. set by the jwt filter.
+func (c *HttpContext) SetClaims(claims map[string]interface{}) {
+	c.SetValue(claimsKey{}, claims)
+}
+
+// Claims returns the token claims stored in the request, or nil if not authenticated.
+func Claims(req *http.Request) map[string]interface{} {
+	claims, _ := req.Context().Value(claimsKey{}).(map[string]interface{})
+	return claims
+}
+
 func (c *HttpContext) SetMetaData(key string, value interface{}) {
 	if _, ok := c.MetaData[key]; !ok {
 		c.MetaData[key] = value
@@ -55,6 +76,11 @@ func (c *HttpContext) GetMetaData(key string) (interface{}, error) {
 	return nil, fmt.Errorf("%s not found in metadata", key)
 }
 
+// Param returns the value of the named path param, or empty string if not present.
+func (c *HttpContext) Param(key string) string {
+	return c.Params[key]
+}
+
 func (c *HttpContext) ToJSON(obj interface{}) {
 	c.SetResponseHeader("Content-Type", "application/json; charset=utf-8")
 
@@ -90,9 +116,16 @@ func (c *HttpContext) AbortWithStatus(code int) {
 }
 
 func (c *HttpContext) AbortWithMsg(msg string) {
-	c.AbortWithStatus(http.StatusInternalServerError)
+	c.AbortWithStatusMsg(http.StatusInternalServerError, msg)
+}
+
+// AbortWithStatusMsg aborts with the status code and a json body carrying the message.
+func (c *HttpContext) AbortWithStatusMsg(code int, msg string) {
+	// headers must be set before writing the status code
+	c.SetResponseHeader("Content-Type", "application/json; charset=utf-8")
+	c.AbortWithStatus(code)
 	c.ToJSON(&response.ResponseModel{
-		Code:    http.StatusInternalServerError,
+		Code:    code,
 		Message: msg,
 	})
 }
@@ -110,3 +143,38 @@ func (c *HttpContext) SetResponseHeaders(headers map[string]string) {
 		c.SetResponseHeader(key, value)
 	}
 }
+
+// ResponseBody reads the whole upstream response body, the body is buffered so it can be read again.
+func (c *HttpContext) ResponseBody() ([]byte, error) {
+	if c.Response == nil || c.Response.Body == nil {
+		return nil, nil
+	}
+
+	buf, err := ioutil.ReadAll(c.Response.Body)
+	c.Response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	c.Response.Body = ioutil.NopCloser(bytes.NewReader(buf))
+	return buf, nil
+}
+
+// SetResponseBody replaces the upstream response body and updates its length.
+func (c *HttpContext) SetResponseBody(body []byte) {
+	if c.Response.Body != nil {
+		c.Response.Body.Close()
+	}
+
+	c.Response.Body = ioutil.NopCloser(bytes.NewReader(body))
+	c.Response.ContentLength = int64(len(body))
+	c.Response.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+// SetResponseBodyReader replaces the upstream response body with a stream of unknown length,
+// e.g. a reader wrapping the original body.
+func (c *HttpContext) SetResponseBodyReader(body io.ReadCloser) {
+	c.Response.Body = body
+	c.Response.ContentLength = -1
+	c.Response.Header.Del("Content-Length")
+}
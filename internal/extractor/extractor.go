@@ -0,0 +1,110 @@
+package extractor
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/KKKKjl/tinykit/internal/context"
+	"github.com/KKKKjl/tinykit/utils"
+)
+
+const defaultApiKeyHeader = "X-API-Key"
+
+// Extractor extracts a key from the request, e.g. to limit or hash requests by.
+// It returns false if the key is not present in the request.
+type Extractor func(req *http.Request) (string, bool)
+
+// Parse creates an extractor from its spec, one of
+//
+//	ip               client ip
+//	path             request path
+//	header:<name>    request header
+//	query:<name>     query param
+//	cookie:<name>    cookie value
+//	claim:<name>     claim of the token verified by the jwt filter
+//	apiKey[:<name>]  api key header, default X-API-Key
+func Parse(spec string) (Extractor, error) {
+	kind, name := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, name = spec[:i], spec[i+1:]
+	}
+
+	switch kind {
+	case "ip":
+		return IP, nil
+	case "path":
+		return Path, nil
+	case "apiKey":
+		if name == "" {
+			name = defaultApiKeyHeader
+		}
+		return Header(name), nil
+	}
+
+	if name == "" {
+		return nil, fmt.Errorf("key %q requires a name, e.g. %s:<name>", spec, kind)
+	}
+
+	switch kind {
+	case "header":
+		return Header(name), nil
+	case "query":
+		return Query(name), nil
+	case "cookie":
+		return Cookie(name), nil
+	case "claim":
+		return Claim(name), nil
+	default:
+		return nil, fmt.Errorf("unknown key %q", spec)
+	}
+}
+
+func IP(req *http.Request) (string, bool) {
+	ip, err := utils.GetIPAddr(req)
+	if err != nil {
+		return "", false
+	}
+
+	return ip, true
+}
+
+func Path(req *http.Request) (string, bool) {
+	return req.URL.Path, true
+}
+
+func Header(name string) Extractor {
+	return func(req *http.Request) (string, bool) {
+		value := req.Header.Get(name)
+		return value, value != ""
+	}
+}
+
+func Query(name string) Extractor {
+	return func(req *http.Request) (string, bool) {
+		value := req.URL.Query().Get(name)
+		return value, value != ""
+	}
+}
+
+func Cookie(name string) Extractor {
+	return func(req *http.Request) (string, bool) {
+		cookie, err := req.Cookie(name)
+		if err != nil || cookie.Value == "" {
+			return "", false
+		}
+
+		return cookie.Value, true
+	}
+}
+
+func Claim(name string) Extractor {
+	return func(req *http.Request) (string, bool) {
+		value, ok := context.Claims(req)[name]
+		if !ok || value == nil {
+			return "", false
+		}
+
+		return fmt.Sprint(value), true
+	}
+}
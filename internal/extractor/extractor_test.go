@@ -0,0 +1,55 @@
+package extractor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/KKKKjl/tinykit/internal/context"
+)
+
+func TestParse(t *testing.T) {
+	assert := assert.New(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/users/1?tenant=a", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-User", "bob")
+	req.Header.Set("X-API-Key", "key")
+	req.AddCookie(&http.Cookie{Name: "session", Value: "s1"})
+
+	ctx := context.New(httptest.NewRecorder(), req)
+	ctx.SetClaims(map[string]interface{}{"sub": 42})
+	req = ctx.Request
+
+	tests := []struct {
+		spec string
+		key  string
+		ok   bool
+	}{
+		{"ip", "10.0.0.1", true},
+		{"path", "/users/1", true},
+		{"header:X-User", "bob", true},
+		{"header:X-Missing", "", false},
+		{"query:tenant", "a", true},
+		{"cookie:session", "s1", true},
+		{"claim:sub", "42", true},
+		{"claim:name", "", false},
+		{"apiKey", "key", true},
+	}
+
+	for _, v := range tests {
+		extract, err := Parse(v.spec)
+		assert.Nil(err, v.spec)
+
+		key, ok := extract(req)
+		assert.Equal(v.key, key, v.spec)
+		assert.Equal(v.ok, ok, v.spec)
+	}
+
+	for _, v := range []string{"header", "claim:", "unknown:a"} {
+		_, err := Parse(v)
+		assert.NotNil(err, v)
+	}
+}
@@ -21,6 +21,7 @@ type (
 		Priority int        // 优先级
 		Type     HandleMode // 过滤器类型
 		Handle   HandleFilter
+		Close    func() // releases the state of the filter once its chain is unused, nil if none
 	}
 
 	Chain []Handler // chain is a list of Handlers
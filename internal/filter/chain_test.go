@@ -1,16 +1,21 @@
 package filter
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"sort"
+	"strings"
 	"testing"
+
+	"github.com/KKKKjl/tinykit/internal/context"
 )
 
 func TestSort(t *testing.T) {
 	chain := Chain{
-		{"handle1", 1, ReqMode, nil},
-		{"handle2", 3, ReqMode, nil},
-		{"handle3", 2, ReqMode, nil},
-		{"handle4", 4, ReqMode, nil},
+		{"handle1", 1, ReqMode, nil, nil},
+		{"handle2", 3, ReqMode, nil, nil},
+		{"handle3", 2, ReqMode, nil, nil},
+		{"handle4", 4, ReqMode, nil, nil},
 	}
 
 	sort.Sort(chain)
@@ -22,3 +27,37 @@ func TestSort(t *testing.T) {
 	}
 
 }
+
+func TestComposeResponse(t *testing.T) {
+	var calls []string
+	handle := func(name string) HandleFilter {
+		return func(ctx context.HttpContext, next Next) {
+			calls = append(calls, name)
+			next(ctx)
+		}
+	}
+
+	chains := NewFilterChains()
+	chains.Use(
+		Handler{"req", 1, ReqMode, handle("req"), nil},
+		Handler{"resp1", 1, RespMode, handle("resp1"), nil},
+		Handler{"resp2", 2, RespMode, handle("resp2"), nil},
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	chains.Compose()(context.New(httptest.NewRecorder(), req), func(ctx context.HttpContext) {
+		calls = append(calls, "proxy")
+
+		respHandle := ResponseFilter(ctx)
+		if respHandle == nil {
+			t.Fatal("response filter not found in context")
+		}
+		respHandle(ctx, func(ctx context.HttpContext) {
+			calls = append(calls, "done")
+		})
+	})
+
+	if want := "req,proxy,resp2,resp1,done"; strings.Join(calls, ",") != want {
+		t.Errorf("compose error, want %s, got %s", want, strings.Join(calls, ","))
+	}
+}
@@ -10,31 +10,64 @@ import (
 )
 
 type Cors struct {
-	AllowOrigins       []string
-	AllowMethods       []string
-	AllowHeaders       []string
-	AllowExposeHeaders []string
-	AllowAllOrigin     bool
-	AllowAllMethods    bool
-	AllowAllHeaders    bool
+	AllowOrigins       []string `mapstructure:"allowOrigins"`
+	AllowMethods       []string `mapstructure:"allowMethods"`
+	AllowHeaders       []string `mapstructure:"allowHeaders"`
+	AllowExposeHeaders []string `mapstructure:"exposeHeaders"`
+	AllowAllOrigin     bool     `mapstructure:"allowAllOrigins"`
+	AllowAllMethods    bool     `mapstructure:"allowAllMethods"`
+	AllowAllHeaders    bool     `mapstructure:"allowAllHeaders"`
 }
 
-func CORS() filter.HandleFilter {
-	config := &Cors{
+func init() {
+	filter.Register("cors", NewCorsFilter)
+}
+
+func defaultCors() *Cors {
+	return &Cors{
 		AllowOrigins:       []string{},
 		AllowMethods:       []string{"GET", "POST"},
 		AllowHeaders:       []string{"Origin", "Content-Length", "Content-Type"},
-		AllowExposeHeaders: []string{"X-TinyKit-Trace-Id", "Authorization", "X-Ratelimit-Limit", "X-Ratelimit-Reset"},
+		AllowExposeHeaders: []string{"X-TinyKit-Trace-Id", "Authorization", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
 		AllowAllOrigin:     true,
 	}
-	return newCors(config)
 }
 
-func (cors *Cors) handlePreflight(ctx context.HttpContext) {
+func CORS() filter.HandleFilter {
+	return newCors(defaultCors())
+}
+
+// NewCorsFilter creates the cors filter, all origins are allowed unless allowOrigins is set.
+func NewCorsFilter(params map[string]interface{}) (filter.Handler, error) {
+	config := defaultCors()
+	config.AllowAllOrigin = false
+
+	if err := filter.DecodeParams(params, config); err != nil {
+		return filter.Handler{}, err
+	}
+
+	if len(config.AllowOrigins) == 0 {
+		config.AllowAllOrigin = true
+	}
+
+	return filter.Handler{
+		Name:     "cors",
+		Priority: 1,
+		Handle:   newCors(config),
+	}, nil
+}
+
+func (cors *Cors) handlePreflight(ctx context.HttpContext, origin string) {
 	allowHeadersStr := strings.Join(cors.AllowHeaders, ",")
+	if requested := ctx.Request.Header.Get("Access-Control-Request-Headers"); cors.AllowAllHeaders && requested != "" {
+		allowHeadersStr = requested
+	}
 	cors.SetHeader(ctx, "Access-Control-Allow-Headers", allowHeadersStr)
 
 	allowMethodsStr := strings.Join(cors.AllowMethods, ",")
+	if requested := ctx.Request.Header.Get("Access-Control-Request-Method"); cors.AllowAllMethods && requested != "" {
+		allowMethodsStr = requested
+	}
 	cors.SetHeader(ctx, "Access-Control-Allow-Methods", allowMethodsStr)
 
 	allowExposeHeadersStr := strings.Join(cors.AllowExposeHeaders, ",")
@@ -42,14 +75,15 @@ func (cors *Cors) handlePreflight(ctx context.HttpContext) {
 
 	if cors.AllowAllOrigin {
 		cors.SetHeader(ctx, "Access-Control-Allow-Origin", "*")
-	} else if len(cors.AllowOrigins) > 0 {
-		str := strings.Join(cors.AllowOrigins, ",")
-		cors.SetHeader(ctx, "Access-Control-Allow-Origin", str)
+	} else if origin != "" {
+		// only a single origin is allowed in the header, echo the validated one
+		cors.SetHeader(ctx, "Access-Control-Allow-Origin", origin)
+		ctx.ResponseWriter.Header().Add("Vary", "Origin")
 	}
 }
 
 func (cors *Cors) SetHeader(ctx context.HttpContext, key string, value string) {
-	ctx.Request.Header.Set(key, value)
+	ctx.SetResponseHeader(key, value)
 }
 
 // check if the origin is allowed
@@ -72,12 +106,12 @@ func newCors(cors *Cors) filter.HandleFilter {
 	return func(ctx context.HttpContext, next filter.Next) {
 		origin := ctx.Request.Header.Get("origin")
 
-		if origin != "" && cors.validateOrigin(origin) {
-			ctx.AbortWithMsg(fmt.Sprintf("The request origin header %s not allowed", origin))
+		if origin != "" && !cors.validateOrigin(origin) {
+			ctx.AbortWithStatusMsg(http.StatusForbidden, fmt.Sprintf("The request origin header %s not allowed", origin))
 			return
 		}
 
-		cors.handlePreflight(ctx)
+		cors.handlePreflight(ctx, origin)
 		if ctx.Method == "OPTIONS" {
 			ctx.AbortWithStatus(http.StatusOK)
 			return
@@ -86,11 +120,3 @@ func newCors(cors *Cors) filter.HandleFilter {
 		next(ctx)
 	}
 }
-
-func InitCorsLimit() filter.Handler {
-	return filter.Handler{
-		Name:     "cors",
-		Priority: 1,
-		Handle:   CORS(),
-	}
-}
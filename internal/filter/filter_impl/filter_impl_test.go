@@ -0,0 +1,207 @@
+package filter_impl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/KKKKjl/tinykit/internal/context"
+	"github.com/KKKKjl/tinykit/internal/filter"
+	"github.com/KKKKjl/tinykit/internal/ratelimit"
+)
+
+var user interface{}
+
+func serve(handler filter.Handler, req *http.Request) (*httptest.ResponseRecorder, bool) {
+	w := httptest.NewRecorder()
+
+	var called bool
+	handler.Handle(context.New(w, req), func(ctx context.HttpContext) {
+		called = true
+		user = ctx.GetValue(User{})
+	})
+
+	return w, called
+}
+
+func TestRateLimitFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	handler, err := filter.New("ratelimit", map[string]interface{}{"limit": 1, "window": "1m", "burst": 2, "key": "header:X-User"})
+	assert.Nil(err)
+	assert.Equal("ratelimit", handler.Name)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-User", "bob")
+	for i := 0; i < 2; i++ {
+		w, called := serve(handler, req)
+		assert.True(called)
+		assert.Equal(strconv.Itoa(1-i), w.Header().Get("RateLimit-Remaining"))
+	}
+
+	w, called := serve(handler, req)
+	assert.False(called)
+	assert.Equal(http.StatusTooManyRequests, w.Code)
+	assert.Equal("2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal("1;w=60", w.Header().Get("RateLimit-Policy"))
+	assert.Equal("60", w.Header().Get("Retry-After"))
+
+	// other users have their own quota
+	req.Header.Set("X-User", "alice")
+	_, called = serve(handler, req)
+	assert.True(called)
+
+	for _, v := range []map[string]interface{}{
+		{"limit": -1},
+		{"algorithm": "leaky_bucket"},
+		{"key": "unknown"},
+	} {
+		_, err = filter.New("ratelimit", v)
+		assert.NotNil(err)
+		assert.NotNil(filter.Validate("ratelimit", v))
+	}
+}
+
+func TestScopedRateLimitFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	params := map[string]interface{}{"limit": 1, "window": "1m"}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	a, err := filter.NewScoped("routes/users", "ratelimit", params)
+	assert.Nil(err)
+	_, called := serve(a, req)
+	assert.True(called)
+
+	// the filter of the reloaded route keeps the quota
+	reloaded, err := filter.NewScoped("routes/users", "ratelimit", params)
+	assert.Nil(err)
+	a.Close()
+	_, called = serve(reloaded, req)
+	assert.False(called)
+
+	// other routes have their own quota
+	other, err := filter.NewScoped("routes/orders", "ratelimit", params)
+	assert.Nil(err)
+	defer other.Close()
+	_, called = serve(other, req)
+	assert.True(called)
+
+	// the quota is dropped with the route
+	reloaded.Close()
+	added, err := filter.NewScoped("routes/users", "ratelimit", params)
+	assert.Nil(err)
+	defer added.Close()
+	_, called = serve(added, req)
+	assert.True(called)
+}
+
+func TestSharedRateLimitFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	params := map[string]interface{}{"limit": 2, "window": "1m", "shared": true, "scope": "test", "batchSize": 1, "syncInterval": "0s"}
+
+	// filters of two routes or instances with the same scope share the quota
+	a, err := filter.New("ratelimit", params)
+	assert.Nil(err)
+	b, err := filter.New("ratelimit", params)
+	assert.Nil(err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	_, called := serve(a, req)
+	assert.True(called)
+	_, called = serve(b, req)
+	assert.True(called)
+
+	w, called := serve(a, req)
+	assert.False(called)
+	assert.Equal(http.StatusTooManyRequests, w.Code)
+
+	// routes with the same policy do not share the quota without a scope
+	params = map[string]interface{}{"limit": 1, "window": "1m", "shared": true, "batchSize": 1, "syncInterval": "0s"}
+	for _, v := range []string{"routes/users", "routes/orders"} {
+		handler, err := filter.NewScoped(v, "ratelimit", params)
+		assert.Nil(err)
+		defer handler.Close()
+
+		_, called = serve(handler, req)
+		assert.True(called, v)
+	}
+
+	_, err = filter.New("ratelimit", map[string]interface{}{"algorithm": "token_bucket", "shared": true})
+	assert.NotNil(err)
+	assert.Equal(ratelimit.TokenBucketErr, filter.Validate("ratelimit", map[string]interface{}{"algorithm": "token_bucket", "shared": true}))
+	assert.Nil(filter.Validate("ratelimit", params))
+}
+
+func TestCorsFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	handler, err := filter.New("cors", map[string]interface{}{"allowOrigins": []string{"http://a.com"}})
+	assert.Nil(err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Origin", "http://a.com")
+	w, called := serve(handler, req)
+	assert.True(called)
+	assert.Equal("http://a.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	req.Header.Set("Origin", "http://b.com")
+	w, called = serve(handler, req)
+	assert.False(called)
+	assert.Equal(http.StatusForbidden, w.Code)
+
+	_, err = filter.New("cors", map[string]interface{}{"allowOrigin": "http://a.com"})
+	assert.NotNil(err)
+}
+
+func TestJwtFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := filter.New("jwt", map[string]interface{}{"secret": ""})
+	assert.NotNil(err)
+
+	handler, err := filter.New("jwt", map[string]interface{}{"secret": "38324"})
+	assert.Nil(err)
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"id": "1"}).SignedString([]byte("38324"))
+	assert.Nil(err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	_, called := serve(handler, req)
+	assert.True(called)
+	assert.Equal("1", user)
+
+	req.Header.Set("Authorization", "Bearer invalid")
+	w, called := serve(handler, req)
+	assert.False(called)
+	assert.Equal(http.StatusUnauthorized, w.Code)
+}
+
+func TestResponseHeadersFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	handler, err := filter.New("responseHeaders", map[string]interface{}{
+		"set":    map[string]interface{}{"X-Gateway": "tinykit"},
+		"remove": "Server",
+	})
+	assert.Nil(err)
+	assert.Equal(filter.RespMode, handler.Type)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	ctx := context.New(httptest.NewRecorder(), req)
+	ctx.Response = &http.Response{Header: http.Header{"Server": {"nginx"}}}
+
+	var called bool
+	handler.Handle(ctx, func(ctx context.HttpContext) {
+		called = true
+	})
+	assert.True(called)
+	assert.Equal("tinykit", ctx.Response.Header.Get("X-Gateway"))
+	assert.Equal("", ctx.Response.Header.Get("Server"))
+}
@@ -0,0 +1,58 @@
+package filter_impl
+
+import (
+	"net/http"
+
+	"github.com/KKKKjl/tinykit/internal/context"
+	"github.com/KKKKjl/tinykit/internal/filter"
+)
+
+// ResponseHeadersConfig is the params of the responseHeaders filter.
+type ResponseHeadersConfig struct {
+	Set    map[string]string `mapstructure:"set"`
+	Add    map[string]string `mapstructure:"add"`
+	Remove []string          `mapstructure:"remove"`
+}
+
+func init() {
+	filter.Register("responseHeaders", NewResponseHeadersFilter)
+}
+
+// NewResponseHeadersFilter creates a response filter modifying the upstream response headers.
+func NewResponseHeadersFilter(params map[string]interface{}) (filter.Handler, error) {
+	var conf ResponseHeadersConfig
+	if err := filter.DecodeParams(params, &conf); err != nil {
+		return filter.Handler{}, err
+	}
+
+	return filter.Handler{
+		Name:     "responseHeaders",
+		Priority: 1,
+		Type:     filter.RespMode,
+		Handle:   ResponseHeadersFilter(&conf),
+	}, nil
+}
+
+func ResponseHeadersFilter(conf *ResponseHeadersConfig) filter.HandleFilter {
+	return func(ctx context.HttpContext, next filter.Next) {
+		header := ctx.Response.Header
+		if header == nil {
+			header = make(http.Header)
+			ctx.Response.Header = header
+		}
+
+		for _, v := range conf.Remove {
+			header.Del(v)
+		}
+
+		for k, v := range conf.Set {
+			header.Set(k, v)
+		}
+
+		for k, v := range conf.Add {
+			header.Add(k, v)
+		}
+
+		next(ctx)
+	}
+}
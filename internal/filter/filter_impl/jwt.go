@@ -2,16 +2,17 @@ package filter_impl
 
 import (
 	"errors"
+	"net/http"
 	"os"
 	"strings"
 
 	"github.com/KKKKjl/tinykit/internal/context"
 	"github.com/KKKKjl/tinykit/internal/filter"
 	"github.com/golang-jwt/jwt/v4"
-	"github.com/spf13/viper"
 )
 
 var (
+	// default secret and signing algorithm of the jwt filter
 	Secret           []byte
 	SigningAlgorithm string
 
@@ -19,44 +20,89 @@ var (
 	TokenNotFoundErr    = errors.New("Required authorization token not found.")
 	TokenStructErr      = errors.New("Token struct error.")
 	InvalidSignatureErr = errors.New("Invalid signing algorithm.")
+	EmptySecretErr      = errors.New("Jwt secret is required, set it in params or env TINYKIT_JWT_SECRET.")
 )
 
-type User map[string]interface{}
+type (
+	// User is the context key of the user id parsed from the token.
+	User struct{}
+
+	// JwtConfig is the params of the jwt filter.
+	JwtConfig struct {
+		Secret           string `mapstructure:"secret"`
+		SigningAlgorithm string `mapstructure:"signingAlgorithm"`
+		Header           string `mapstructure:"header"`
+	}
+)
 
 func init() {
-	secret, ok := os.LookupEnv("TINYKIT_JWT_SECRET")
-	if !ok {
-		//panic("Jwt secret not found in env.")
+	Secret = []byte(os.Getenv("TINYKIT_JWT_SECRET"))
+	SigningAlgorithm = "HS256"
+
+	filter.Register("jwt", NewJwtFilter)
+}
+
+// NewJwtFilter creates the jwt filter, the secret defaults to env TINYKIT_JWT_SECRET.
+func NewJwtFilter(params map[string]interface{}) (filter.Handler, error) {
+	conf := JwtConfig{
+		Secret:           string(Secret),
+		SigningAlgorithm: SigningAlgorithm,
+		Header:           AuthKey,
+	}
+
+	if err := filter.DecodeParams(params, &conf); err != nil {
+		return filter.Handler{}, err
 	}
 
-	SigningAlgorithm = viper.GetString("SIGNING_AlGORITHM")
-	Secret = []byte(secret)
+	if conf.Secret == "" {
+		return filter.Handler{}, EmptySecretErr
+	}
+
+	if jwt.GetSigningMethod(conf.SigningAlgorithm) == nil {
+		return filter.Handler{}, InvalidSignatureErr
+	}
+
+	return filter.Handler{
+		Name:     "jwt",
+		Priority: 3,
+		Handle:   newJwt(&conf),
+	}, nil
 }
 
 func JwtFilter() filter.HandleFilter {
+	return newJwt(&JwtConfig{
+		Secret:           string(Secret),
+		SigningAlgorithm: SigningAlgorithm,
+		Header:           AuthKey,
+	})
+}
+
+func newJwt(conf *JwtConfig) filter.HandleFilter {
+	secret := []byte(conf.Secret)
+
 	return func(ctx context.HttpContext, next filter.Next) {
-		tokenStr, err := getTokenFromHeader(ctx)
+		tokenStr, err := getTokenFromHeader(ctx, conf.Header)
 		if err != nil {
-			ctx.Error(err)
+			ctx.AbortWithStatusMsg(http.StatusUnauthorized, err.Error())
 			return
 		}
 
 		token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-			if jwt.GetSigningMethod(SigningAlgorithm) != token.Method {
+			if jwt.GetSigningMethod(conf.SigningAlgorithm) != token.Method {
 				return nil, InvalidSignatureErr
 			}
 
-			return Secret, nil
+			return secret, nil
 		})
 		if err != nil {
 			if errors.Is(err, jwt.ErrTokenMalformed) {
-				ctx.AbortWithMsg("Invalid token.")
+				ctx.AbortWithStatusMsg(http.StatusUnauthorized, "Invalid token.")
 			} else if errors.Is(err, jwt.ErrTokenExpired) {
-				ctx.AbortWithMsg("Token expired.")
+				ctx.AbortWithStatusMsg(http.StatusUnauthorized, "Token expired.")
 			} else if errors.Is(err, jwt.ErrTokenNotValidYet) {
-				ctx.AbortWithMsg("Token not active yet.")
+				ctx.AbortWithStatusMsg(http.StatusUnauthorized, "Token not active yet.")
 			} else {
-				ctx.Error(err)
+				ctx.AbortWithStatusMsg(http.StatusUnauthorized, err.Error())
 			}
 
 			return
@@ -64,19 +110,20 @@ func JwtFilter() filter.HandleFilter {
 
 		claims, ok := token.Claims.(jwt.MapClaims)
 		if !(ok && token.Valid) {
-			ctx.Error(TokenStructErr)
+			ctx.AbortWithStatusMsg(http.StatusUnauthorized, TokenStructErr.Error())
 			return
 		}
 
-		id := claims["id"].(string)
+		id, _ := claims["id"].(string)
 		ctx.SetValue(User{}, id)
+		ctx.SetClaims(claims)
 
 		next(ctx)
 	}
 }
 
-func getTokenFromHeader(ctx context.HttpContext) (string, error) {
-	authorization := ctx.Request.Header.Get(AuthKey)
+func getTokenFromHeader(ctx context.HttpContext, header string) (string, error) {
+	authorization := ctx.Request.Header.Get(header)
 	if authorization == "" {
 		return "", TokenNotFoundErr
 	}
@@ -1,82 +1,227 @@
 package filter_impl
 
 import (
-	"errors"
 	"fmt"
 	"math"
+	"net/http"
 	"strconv"
 	"sync"
 	"time"
 
-	"golang.org/x/time/rate"
-
 	"github.com/KKKKjl/tinykit/internal/context"
+	"github.com/KKKKjl/tinykit/internal/extractor"
 	"github.com/KKKKjl/tinykit/internal/filter"
-	"github.com/KKKKjl/tinykit/utils"
+	"github.com/KKKKjl/tinykit/internal/ratelimit"
+	"github.com/KKKKjl/tinykit/logger"
 )
 
 var (
-	limit = NewRateLimit(1)
+	log     = logger.GetLogger()
+	mainLog = log.WithField("prefix", "filter")
+
+	// limiters shared by scope and config, e.g. by the filters of a route before and after a reload
+	limitersMu sync.Mutex
+	limiters   = make(map[string]*sharedLimiter)
 )
 
-type RateLimit struct {
-	rate    float64 // requests per second
-	brust   int     // burst size
-	buckets sync.Map
+type sharedLimiter struct {
+	limiter ratelimit.Limiter
+	refs    int
+}
+
+// RateLimitConfig is the params of the ratelimit filter, the quotas of a route are kept across reloads
+// while its params are unchanged.
+type RateLimitConfig struct {
+	Algorithm   string        `mapstructure:"algorithm"`   // token_bucket, sliding_window or fixed_window, default token_bucket or sliding_window if shared
+	Limit       int           `mapstructure:"limit"`       // requests per window
+	Window      time.Duration `mapstructure:"window"`      // default 1s
+	Burst       int           `mapstructure:"burst"`       // bucket size of token_bucket, default limit
+	Key         string        `mapstructure:"key"`         // key extractor, see extractor.Parse, default ip
+	IdleTimeout time.Duration `mapstructure:"idleTimeout"` // evict the quota of idle keys
+
+	// the quota is shared by the gateway instances through the ratelimit store
+	Shared       bool          `mapstructure:"shared"`
+	Scope        string        `mapstructure:"scope"`        // filters with the same scope share the quota, default the route and the policy
+	BatchSize    int           `mapstructure:"batchSize"`    // flush the local requests every batchSize requests
+	SyncInterval time.Duration `mapstructure:"syncInterval"` // or every syncInterval
+	FailOpen     bool          `mapstructure:"failOpen"`     // limit locally if the store is unreachable, otherwise reject
 }
 
-func NewRateLimit(rate float64) *RateLimit {
-	if rate < 0 {
-		panic(fmt.Sprintf("cap %v < 0", rate))
+func init() {
+	filter.RegisterScoped("ratelimit", NewScopedRateLimitFilter)
+	filter.RegisterValidator("ratelimit", ValidateRateLimitFilter)
+}
+
+// ValidateRateLimitFilter checks the params of the ratelimit filter without creating the limiter.
+func ValidateRateLimitFilter(params map[string]interface{}) error {
+	_, _, _, err := parseRateLimit(params)
+	return err
+}
+
+// NewRateLimitFilter creates the ratelimit filter, default 1 request per second per client ip.
+// Requests without the key, e.g. a missing header, are limited by client ip.
+func NewRateLimitFilter(params map[string]interface{}) (filter.Handler, error) {
+	return NewScopedRateLimitFilter("", params)
+}
+
+// NewScopedRateLimitFilter creates the ratelimit filter of the scope, the filters of the same scope and
+// params share their limiter, so that a reload does not reset the quotas.
+func NewScopedRateLimitFilter(scope string, params map[string]interface{}) (filter.Handler, error) {
+	conf, policy, extract, err := parseRateLimit(params)
+	if err != nil {
+		return filter.Handler{}, err
 	}
 
-	return &RateLimit{
-		rate:  rate,
-		brust: int(math.Max(1, rate)),
+	limiter, release, err := acquireLimiter(scope, conf, policy)
+	if err != nil {
+		return filter.Handler{}, err
 	}
+
+	return filter.Handler{
+		Name:     "ratelimit",
+		Priority: 2,
+		Handle:   RateLimitFilter(limiter, conf.Key, extract, fmt.Sprintf("%d;w=%d", conf.Limit, int(math.Ceil(conf.Window.Seconds())))),
+		Close:    release,
+	}, nil
 }
 
-func (r *RateLimit) GetMax() float64 {
-	return r.rate
+// acquireLimiter returns the limiter of the scope and config, it is created if there is none.
+// release must be called once the limiter is unused, empty scope means a limiter of its own.
+func acquireLimiter(scope string, conf RateLimitConfig, policy ratelimit.Policy) (ratelimit.Limiter, func(), error) {
+	if scope == "" {
+		limiter, err := newLimiter(scope, conf, policy)
+		return limiter, nil, err
+	}
+
+	key := fmt.Sprintf("%s|%+v", scope, conf)
+
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+
+	shared, ok := limiters[key]
+	if !ok {
+		limiter, err := newLimiter(scope, conf, policy)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		shared = &sharedLimiter{limiter: limiter}
+		limiters[key] = shared
+	}
+	shared.refs++
+
+	var once sync.Once
+	return shared.limiter, func() {
+		once.Do(func() {
+			limitersMu.Lock()
+			defer limitersMu.Unlock()
+
+			if shared.refs--; shared.refs == 0 && limiters[key] == shared {
+				delete(limiters, key)
+			}
+		})
+	}, nil
 }
 
-// func (r *RateLimit) set(key string) *rate.Limiter {
-// 	limiter := rate.NewLimiter(rate.Limit(r.rate), r.brust)
-// 	r.buckets[key] = limiter
-// 	return limiter
-// }
+// newLimiter creates the limiter of the config, in memory or shared through the ratelimit store.
+// The quota in the store is shared by the filters of the same scope param, by default of the same
+// filter scope, e.g. the route, and policy.
+func newLimiter(scope string, conf RateLimitConfig, policy ratelimit.Policy) (ratelimit.Limiter, error) {
+	opts := []ratelimit.Option{ratelimit.WithIdleTimeout(conf.IdleTimeout)}
+	if conf.Shared {
+		storeScope := conf.Scope
+		if storeScope == "" {
+			storeScope = fmt.Sprintf("%s:%d:%s:%s", policy.Algorithm, conf.Limit, conf.Window, conf.Key)
+			if scope != "" {
+				storeScope = scope + ":" + storeScope
+			}
+		}
+
+		opts = append(opts,
+			ratelimit.WithStore(ratelimit.SharedStore(), storeScope),
+			ratelimit.WithBatch(conf.BatchSize, conf.SyncInterval),
+			ratelimit.WithFailOpen(conf.FailOpen),
+		)
+	}
 
-func (r *RateLimit) Take(key string) *rate.Limiter {
-	limit, _ := r.buckets.LoadOrStore(key, rate.NewLimiter(rate.Every(time.Second), r.brust))
-	return limit.(*rate.Limiter)
+	return ratelimit.New(policy, opts...)
 }
 
-func (r *RateLimit) Avabile(key string) (bool, *rate.Reservation) {
-	limiter := limit.Take(key).Reserve()
-	return limiter.OK(), limiter
+// parseRateLimit decodes the params with the defaults and checks the policy and the key.
+func parseRateLimit(params map[string]interface{}) (RateLimitConfig, ratelimit.Policy, extractor.Extractor, error) {
+	conf := RateLimitConfig{
+		Limit:        1,
+		Window:       time.Second,
+		Key:          "ip",
+		BatchSize:    10,
+		SyncInterval: 100 * time.Millisecond,
+		FailOpen:     true,
+	}
+
+	if err := filter.DecodeParams(params, &conf); err != nil {
+		return conf, ratelimit.Policy{}, nil, err
+	}
+
+	// token bucket cannot be shared
+	if conf.Shared && conf.Algorithm == "" {
+		conf.Algorithm = string(ratelimit.SlidingWindow)
+	}
+
+	policy := ratelimit.Policy{
+		Algorithm: ratelimit.Algorithm(conf.Algorithm),
+		Limit:     conf.Limit,
+		Window:    conf.Window,
+		Burst:     conf.Burst,
+	}
+
+	// validated on a copy, the limiter fills the defaults itself
+	check := policy
+	if err := check.Validate(); err != nil {
+		return conf, policy, nil, err
+	}
+
+	if conf.Shared && check.Algorithm == ratelimit.TokenBucket {
+		return conf, policy, nil, ratelimit.TokenBucketErr
+	}
+
+	extract, err := extractor.Parse(conf.Key)
+	if err != nil {
+		return conf, policy, nil, err
+	}
+
+	return conf, policy, extract, nil
 }
 
-func RateLimitFilter() filter.HandleFilter {
+// RateLimitFilter limits the requests by the key extracted from them and sets the RateLimit-* headers.
+func RateLimitFilter(limiter ratelimit.Limiter, name string, extract extractor.Extractor, policy string) filter.HandleFilter {
 	return func(ctx context.HttpContext, next filter.Next) {
-		ip, err := utils.GetIPAddr(ctx.Request)
-		if err != nil {
-			ctx.Error(err)
-			return
+		kind := name
+		key, ok := extract(ctx.Request)
+		if !ok {
+			if key, ok = extractor.IP(ctx.Request); !ok {
+				ctx.AbortWithStatusMsg(http.StatusBadRequest, "Cannot get the rate limit key of the request.")
+				return
+			}
+			kind = "ip"
 		}
 
-		ok, limiter := limit.Avabile(ip)
-		if !ok {
-			ctx.Error(errors.New("Cannot provide the requested token."))
+		res, err := limiter.Allow(ctx.Request.Context(), kind+":"+key)
+		if err != nil {
+			mainLog.Errorf("Rate limit error: %v", err)
+			ctx.AbortWithStatusMsg(http.StatusServiceUnavailable, "Rate limit is unavailable, please try again later.")
 			return
 		}
-		defer limiter.Cancel()
 
-		if limiter.Delay().Seconds() > 0 {
-			resetUnixTime := time.Now().Unix() + int64(math.Ceil(limiter.Delay().Seconds()))
+		ctx.SetResponseHeader("RateLimit-Limit", strconv.Itoa(res.Limit))
+		ctx.SetResponseHeader("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		ctx.SetResponseHeader("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+		ctx.SetResponseHeader("RateLimit-Policy", policy)
+
+		if !res.Allowed {
+			retryAfter := ceilSeconds(res.RetryAfter)
 
-			ctx.SetResponseHeader("X-Ratelimit-Limit", fmt.Sprintf("%.2f", limit.GetMax()))
-			ctx.SetResponseHeader("X-Ratelimit-Reset", strconv.FormatInt(resetUnixTime, 10))
-			ctx.AbortWithMsg("Too many requests, please try again in serveral seconds.")
+			ctx.SetResponseHeader("Retry-After", strconv.Itoa(retryAfter))
+			ctx.AbortWithStatusMsg(http.StatusTooManyRequests, fmt.Sprintf("Too many requests, please try again in %d seconds.", retryAfter))
 			return
 		}
 
@@ -84,10 +229,7 @@ func RateLimitFilter() filter.HandleFilter {
 	}
 }
 
-func InitRateLimit() filter.Handler {
-	return filter.Handler{
-		Name:     "ratelimit",
-		Priority: 2,
-		Handle:   RateLimitFilter(),
-	}
+// ceilSeconds rounds up to whole seconds, at least 1 second if d is positive.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
 }
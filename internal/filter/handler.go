@@ -6,6 +6,8 @@ import (
 	"github.com/KKKKjl/tinykit/internal/context"
 )
 
+type responseFilterKey struct{}
+
 type FilterChains struct {
 	chain Chain
 }
@@ -16,17 +18,75 @@ func NewFilterChains() *FilterChains {
 	}
 }
 
+// Compose composes the request filters of the chain.
+// The response filters are stored in the request context, the proxy runs them on the upstream response, see ResponseFilter.
 func (f *FilterChains) Compose() HandleFilter {
+	reqChain, respChain := f.split()
+
+	handle := compose(reqChain)
+	if len(respChain) == 0 {
+		return handle
+	}
+
+	respHandle := compose(respChain)
+	return func(ctx context.HttpContext, next Next) {
+		ctx.SetValue(responseFilterKey{}, respHandle)
+		handle(ctx, next)
+	}
+}
+
+// ComposeResponse composes the response filters of the chain.
+// A response filter reads or modifies ctx.Response, a filter not calling next must write the response itself.
+func (f *FilterChains) ComposeResponse() HandleFilter {
+	_, respChain := f.split()
+	return compose(respChain)
+}
+
+// ResponseFilter returns the response filters stored by Compose, or nil if there are none.
+func ResponseFilter(ctx context.HttpContext) HandleFilter {
+	handle, _ := ctx.GetValue(responseFilterKey{}).(HandleFilter)
+	return handle
+}
+
+func (f *FilterChains) Use(handler ...Handler) {
+	f.chain = append(f.chain, handler...)
+}
+
+// Close releases the state of the filters, the chain must not be used afterwards.
+func (f *FilterChains) Close() {
+	for _, v := range f.chain {
+		if v.Close != nil {
+			v.Close()
+		}
+	}
+}
+
+// split returns the request and response handlers sorted by priority, handlers without type are request handlers.
+func (f *FilterChains) split() (Chain, Chain) {
+	var reqChain, respChain Chain
+	for _, v := range f.chain {
+		if v.Type == RespMode {
+			respChain = append(respChain, v)
+		} else {
+			reqChain = append(reqChain, v)
+		}
+	}
+
+	// sort chain by priority
+	sort.Sort(reqChain)
+	sort.Sort(respChain)
+
+	return reqChain, respChain
+}
+
+func compose(chain Chain) HandleFilter {
 	return func(ctx context.HttpContext, next Next) {
 		var (
 			dispatch Next
 			index    int
 		)
 
-		// sort chain by priority
-		sort.Sort(f.chain)
-
-		last := f.chain.Len()
+		last := chain.Len()
 
 		// It executes the pending handlers in the chain inside the calling handler.
 		dispatch = func(ctx context.HttpContext) {
@@ -48,13 +108,9 @@ func (f *FilterChains) Compose() HandleFilter {
 
 			index++
 
-			f.chain[index-1].Handle(ctx, dispatch)
+			chain[index-1].Handle(ctx, dispatch)
 		}
 
 		dispatch(ctx)
 	}
 }
-
-func (f *FilterChains) Use(handler ...Handler) {
-	f.chain = append(f.chain, handler...)
-}
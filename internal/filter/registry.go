@@ -0,0 +1,176 @@
+package filter
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+type (
+	// Factory creates a filter handler from the params configured for it.
+	Factory func(params map[string]interface{}) (Handler, error)
+
+	// ScopedFactory creates a filter handler of a scope, e.g. a route, so that the filters of the same scope
+	// and params can keep their state across reloads. Empty scope means a filter of its own.
+	ScopedFactory func(scope string, params map[string]interface{}) (Handler, error)
+
+	// Validator checks the params of a filter without creating it.
+	Validator func(params map[string]interface{}) error
+)
+
+var (
+	mu         sync.RWMutex
+	factories  = make(map[string]Factory)
+	scoped     = make(map[string]ScopedFactory)
+	validators = make(map[string]Validator)
+)
+
+// Register makes a filter available by name to the config, usually called in init.
+// It panics if the factory is nil or the name is registered twice.
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if factory == nil {
+		panic("filter: register factory is nil")
+	}
+
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("filter: register called twice for %s", name))
+	}
+
+	factories[name] = factory
+}
+
+// RegisterScoped makes a filter knowing its scope available by name to the config, see Register.
+func RegisterScoped(name string, factory ScopedFactory) {
+	if factory == nil {
+		panic("filter: register factory is nil")
+	}
+
+	Register(name, func(params map[string]interface{}) (Handler, error) {
+		return factory("", params)
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	scoped[name] = factory
+}
+
+// RegisterValidator sets the validator of the params of a filter, usually called in init. The filters
+// holding resources, e.g. goroutines or store connections, should register one, the others are validated
+// by creating them. It panics if the validator is nil or the name is registered twice.
+func RegisterValidator(name string, validator Validator) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if validator == nil {
+		panic("filter: register validator is nil")
+	}
+
+	if _, ok := validators[name]; ok {
+		panic(fmt.Sprintf("filter: register validator called twice for %s", name))
+	}
+
+	validators[name] = validator
+}
+
+func Lookup(name string) (Factory, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	factory, ok := factories[name]
+	return factory, ok
+}
+
+// Names returns the sorted names of the registered filters.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// New creates the named filter handler with params.
+func New(name string, params map[string]interface{}) (Handler, error) {
+	return NewScoped("", name, params)
+}
+
+// NewScoped creates the named filter handler of the scope with params, the scope is ignored by the
+// filters not registered by RegisterScoped.
+func NewScoped(scope, name string, params map[string]interface{}) (Handler, error) {
+	mu.RLock()
+	factory, ok := factories[name]
+	scopedFactory := scoped[name]
+	mu.RUnlock()
+
+	if !ok {
+		return Handler{}, fmt.Errorf("%s not registered", name)
+	}
+
+	var (
+		handler Handler
+		err     error
+	)
+	if scopedFactory != nil {
+		handler, err = scopedFactory(scope, params)
+	} else {
+		handler, err = factory(params)
+	}
+	if err != nil {
+		return Handler{}, fmt.Errorf("%s: %w", name, err)
+	}
+
+	if handler.Name == "" {
+		handler.Name = name
+	}
+
+	return handler, nil
+}
+
+// Validate checks the params of the named filter by its validator, or by creating the filter without one.
+func Validate(name string, params map[string]interface{}) error {
+	mu.RLock()
+	factory, ok := factories[name]
+	validator := validators[name]
+	mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("%s not registered", name)
+	}
+
+	if validator != nil {
+		return validator(params)
+	}
+
+	_, err := factory(params)
+	return err
+}
+
+// DecodeParams decodes params into the struct pointed by out.
+// Strings are converted to durations and numbers, unknown params are rejected.
+func DecodeParams(params map[string]interface{}, out interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           out,
+		WeaklyTypedInput: true,
+		ErrorUnused:      true,
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+	})
+	if err != nil {
+		return err
+	}
+
+	return decoder.Decode(params)
+}
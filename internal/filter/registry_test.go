@@ -0,0 +1,128 @@
+package filter
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegister(t *testing.T) {
+	assert := assert.New(t)
+
+	Register("test", func(params map[string]interface{}) (Handler, error) {
+		if params["fail"] == true {
+			return Handler{}, errors.New("failed")
+		}
+		return Handler{Priority: 1}, nil
+	})
+	defer func() {
+		mu.Lock()
+		delete(factories, "test")
+		mu.Unlock()
+	}()
+
+	assert.Contains(Names(), "test")
+	assert.Panics(func() { Register("test", func(map[string]interface{}) (Handler, error) { return Handler{}, nil }) })
+	assert.Panics(func() { Register("nil", nil) })
+
+	handler, err := New("test", nil)
+	assert.Nil(err)
+	assert.Equal("test", handler.Name)
+
+	_, err = New("test", map[string]interface{}{"fail": true})
+	assert.EqualError(err, "test: failed")
+
+	_, err = New("unknown", nil)
+	assert.EqualError(err, "unknown not registered")
+}
+
+func TestRegisterScoped(t *testing.T) {
+	assert := assert.New(t)
+
+	RegisterScoped("scoped", func(scope string, params map[string]interface{}) (Handler, error) {
+		return Handler{Name: "scoped:" + scope}, nil
+	})
+	defer func() {
+		mu.Lock()
+		delete(factories, "scoped")
+		delete(scoped, "scoped")
+		mu.Unlock()
+	}()
+
+	assert.Panics(func() { RegisterScoped("nil", nil) })
+
+	handler, err := NewScoped("routes/users", "scoped", nil)
+	assert.Nil(err)
+	assert.Equal("scoped:routes/users", handler.Name)
+
+	// without scope
+	handler, err = New("scoped", nil)
+	assert.Nil(err)
+	assert.Equal("scoped:", handler.Name)
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	var created int
+	factory := func(params map[string]interface{}) (Handler, error) {
+		created++
+		if params["fail"] == true {
+			return Handler{}, errors.New("failed")
+		}
+		return Handler{}, nil
+	}
+
+	Register("created", factory)
+	Register("validated", factory)
+	RegisterValidator("validated", func(params map[string]interface{}) error {
+		if params["fail"] == true {
+			return errors.New("invalid")
+		}
+		return nil
+	})
+	defer func() {
+		mu.Lock()
+		delete(factories, "created")
+		delete(factories, "validated")
+		delete(validators, "validated")
+		mu.Unlock()
+	}()
+
+	assert.Panics(func() { RegisterValidator("validated", func(map[string]interface{}) error { return nil }) })
+	assert.Panics(func() { RegisterValidator("nil", nil) })
+
+	// the filters without validator are created
+	assert.EqualError(Validate("created", map[string]interface{}{"fail": true}), "failed")
+	assert.Equal(1, created)
+
+	assert.Nil(Validate("validated", nil))
+	assert.EqualError(Validate("validated", map[string]interface{}{"fail": true}), "invalid")
+	assert.Equal(1, created)
+
+	assert.EqualError(Validate("unknown", nil), "unknown not registered")
+}
+
+func TestDecodeParams(t *testing.T) {
+	assert := assert.New(t)
+
+	var conf struct {
+		Rate    float64       `mapstructure:"rate"`
+		Timeout time.Duration `mapstructure:"timeout"`
+		Origins []string      `mapstructure:"origins"`
+	}
+
+	err := DecodeParams(map[string]interface{}{
+		"rate":    "1.5",
+		"timeout": "2s",
+		"origins": "a,b",
+	}, &conf)
+	assert.Nil(err)
+	assert.Equal(1.5, conf.Rate)
+	assert.Equal(2*time.Second, conf.Timeout)
+	assert.Equal([]string{"a", "b"}, conf.Origins)
+
+	assert.NotNil(DecodeParams(map[string]interface{}{"unknown": 1}, &conf))
+}
@@ -1,6 +1,11 @@
 package proxy
 
 import (
+	"github.com/KKKKjl/tinykit/internal/extractor"
+	"github.com/KKKKjl/tinykit/internal/registry"
+	"github.com/KKKKjl/tinykit/internal/registry/health"
+	"github.com/KKKKjl/tinykit/internal/registry/outlier"
+	"github.com/KKKKjl/tinykit/internal/request"
 	"github.com/KKKKjl/tinykit/internal/rewrite"
 )
 
@@ -12,6 +17,7 @@ func WithRules(rules map[string]string) ProxyOption {
 			rule, err := rewrite.NewRule(k, v)
 			if err != nil {
 				mainLog.Errorf("create rule error %v", err)
+				continue
 			}
 
 			// proxy.ReWrite.AddRule(rewrite.Rule{
@@ -25,3 +31,84 @@ func WithRules(rules map[string]string) ProxyOption {
 		}
 	}
 }
+
+// WithBuilder sets the service discovery used to pick backend targets.
+func WithBuilder(builder registry.Builder) ProxyOption {
+	return func(proxy *Proxy) {
+		proxy.builder = builder
+	}
+}
+
+// WithService restricts the discovered services to the instances of the named service,
+// empty version means any version. The builder must implement registry.Resolver.
+func WithService(name, version string) ProxyOption {
+	return func(proxy *Proxy) {
+		proxy.service = name
+		proxy.version = version
+	}
+}
+
+// WithRewriteRules adds compiled rewrite rules, matched in the given order.
+func WithRewriteRules(rules ...*rewrite.Rule) ProxyOption {
+	return func(proxy *Proxy) {
+		proxy.ReWrite.AddRule(rules...)
+	}
+}
+
+// WithHealthCheck checks the upstream services actively, only the healthy ones are picked.
+func WithHealthCheck(c health.Config) ProxyOption {
+	return func(proxy *Proxy) {
+		proxy.healthCheck = &c
+	}
+}
+
+// WithOutlierDetection ejects the upstream services failing consecutively from load balancing.
+func WithOutlierDetection(c outlier.Config) ProxyOption {
+	return func(proxy *Proxy) {
+		proxy.outlier = &c
+	}
+}
+
+// WithHashKey sets the key the consistent hashing balancers hash requests by, default the client ip.
+func WithHashKey(key extractor.Extractor) ProxyOption {
+	return func(proxy *Proxy) {
+		proxy.hashKey = key
+	}
+}
+
+// WithStickySession binds clients to the service picked for their first request while it is available.
+func WithStickySession(c StickyConfig) ProxyOption {
+	return func(proxy *Proxy) {
+		proxy.sticky = newSticky(c)
+	}
+}
+
+// WithSubsets routes the requests to subsets of the upstream services before the balancer picks one,
+// a request routed to an empty subset is balanced between all the services.
+func WithSubsets(subsets ...Subset) ProxyOption {
+	return func(proxy *Proxy) {
+		proxy.subsetList = subsets
+	}
+}
+
+// WithGRPC sets the credentials, keepalive and idle timeout of the pooled rpc connections.
+func WithGRPC(c request.PoolConfig) ProxyOption {
+	return func(proxy *Proxy) {
+		proxy.grpc = c
+	}
+}
+
+// WithDescriptors sets the ttl and the warm up of the service descriptors resolved by reflection.
+func WithDescriptors(c request.DescriptorConfig) ProxyOption {
+	return func(proxy *Proxy) {
+		proxy.descriptor = c
+	}
+}
+
+// WithTranscoding binds the requests without the rpc headers to the rpc methods by the google.api.http
+// options of the upstream services.
+func WithTranscoding() ProxyOption {
+	return func(proxy *Proxy) {
+		proxy.transcoding = true
+	}
+}
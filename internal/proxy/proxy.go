@@ -4,18 +4,27 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/KKKKjl/tinykit/internal/extractor"
+	"github.com/KKKKjl/tinykit/internal/filter"
 	"github.com/KKKKjl/tinykit/internal/registry"
 	"github.com/KKKKjl/tinykit/internal/registry/balance"
 	"github.com/KKKKjl/tinykit/internal/registry/etcd"
+	"github.com/KKKKjl/tinykit/internal/registry/health"
+	"github.com/KKKKjl/tinykit/internal/registry/outlier"
 	"github.com/KKKKjl/tinykit/internal/request"
 	"github.com/KKKKjl/tinykit/internal/response"
 	"github.com/KKKKjl/tinykit/internal/rewrite"
@@ -24,8 +33,8 @@ import (
 	"github.com/KKKKjl/tinykit/logger"
 	"github.com/KKKKjl/tinykit/utils"
 	"github.com/gorilla/websocket"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	tx "github.com/KKKKjl/tinykit/internal/context"
 )
@@ -35,8 +44,34 @@ var (
 
 	log     = logger.GetLogger()
 	mainLog = log.WithField("prefix", "proxy")
+
+	// ResponseAbortedErr means a response filter has written the response itself.
+	ResponseAbortedErr = errors.New("Response aborted by filter.")
+)
+
+type (
+	httpContextKey struct{}
+	upstreamKey    struct{}
 )
 
+// upstream is the service picked for a request, its result is reported once.
+type upstream struct {
+	service *registry.Service
+	picker  balance.Picker // picker of the service, the subset picker if routed to a subset
+	pinned  bool           // set by the end point header, not discovered
+	bound   bool           // bound by the affinity cookie, not picked by the balancer
+	start   time.Time
+	latency time.Duration // until the first response, zero until responded
+	once    sync.Once
+}
+
+// responded records the latency of the first response.
+func (u *upstream) responded() {
+	if u.latency == 0 {
+		u.latency = time.Since(u.start)
+	}
+}
+
 type ProxyConfig struct {
 	URLRewriteEnabled    bool
 	LoadBalancingEnabled bool
@@ -51,7 +86,25 @@ type Proxy struct {
 	balancer     balance.Picker
 	parser       *transform.ApiDefinitionParser
 	builder      registry.Builder
+	release      func() // releases the builder resolved for the service
 	ws           *ws.WsHanlder
+	healthCheck  *health.Config
+	checker      *health.Checker
+	outlier      *outlier.Config
+	detector     *outlier.Detector
+	hashKey      extractor.Extractor
+	sticky       *sticky
+	service      string // name of the discovered service
+	version      string
+	subsetList   []Subset
+	subsets      *subsets
+	grpc         request.PoolConfig
+	descriptor   request.DescriptorConfig
+	transcoding  bool
+	rpcMu        sync.Mutex
+	conns        *request.ConnPool        // created by the first rpc request, set once
+	descriptors  *request.DescriptorCache // created with conns
+	closed       bool
 }
 
 func New(proxyConfig ProxyConfig, opts ...ProxyOption) *Proxy {
@@ -61,7 +114,6 @@ func New(proxyConfig ProxyConfig, opts ...ProxyOption) *Proxy {
 		balancer:     balance.NewBalancer(proxyConfig.BalancingType),
 		ReWrite:      rewrite.NewReWrite(),
 		parser:       new(transform.ApiDefinitionParser),
-		builder:      etcd.Builder(),
 		ws:           ws.NewWsHanlder(),
 	}
 
@@ -69,9 +121,51 @@ func New(proxyConfig ProxyConfig, opts ...ProxyOption) *Proxy {
 		opt(proxy)
 	}
 
+	if len(proxy.subsetList) > 0 {
+		proxy.subsets = newSubsets(proxy.subsetList, proxyConfig.BalancingType)
+	}
+
+	// fallback to etcd discovery if no builder specified
+	if proxy.builder == nil {
+		proxy.builder = etcd.Builder()
+	}
+
+	if proxy.service != "" {
+		if resolver, ok := proxy.builder.(registry.Resolver); ok {
+			proxy.builder = resolver.Resolve(proxy.service, proxy.version)
+			if releaser, ok := proxy.builder.(registry.Releaser); ok {
+				proxy.release = releaser.Release
+			}
+		} else {
+			mainLog.Errorf("Discovery(%s) can not resolve service %s, all its services are used.", proxy.builder.Scheme(), proxy.service)
+		}
+	}
+
+	// all the requests of a transcoding proxy are rpc requests, the descriptors are warmed up at once
+	if proxy.transcoding {
+		proxy.rpc()
+	}
+
+	if proxy.healthCheck != nil {
+		// the grpc check connects like the rpc requests
+		hc := *proxy.healthCheck
+		if hc.TLS == nil {
+			hc.TLS = proxy.grpc.TLS
+		}
+
+		proxy.checker = health.New(proxy.builder, hc)
+		proxy.checker.Start()
+	}
+
+	if proxy.outlier != nil {
+		// the states of a named detector are kept across reloads
+		proxy.detector = outlier.Acquire(*proxy.outlier)
+	}
+
 	proxy.reverseProxy = &httputil.ReverseProxy{
 		Director:       proxy.createDirector(),
 		ModifyResponse: proxy.createModifyResponse(),
+		ErrorHandler:   proxy.createErrorHandler(),
 	}
 
 	return proxy
@@ -79,19 +173,57 @@ func New(proxyConfig ProxyConfig, opts ...ProxyOption) *Proxy {
 
 // ServeHttp is an HTTP Handler that takes an incoming request and sends it to another server, proxying the response back to the client.
 func (p *Proxy) ServeHTTP(ctx tx.HttpContext) {
-	if matched := p.parser.IsMatchTransformRule(ctx); !matched {
+	matched := p.parser.IsMatchTransformRule(ctx)
+	if !matched && !p.transcoding {
+		if p.balanced(ctx.Request) {
+			u, err := p.pick(ctx.Request)
+			if err != nil {
+				ctx.AbortWithMsg(err.Error())
+				return
+			}
+			ctx.SetValue(upstreamKey{}, u)
+			p.bind(ctx.ResponseWriter, u)
+		}
+
+		// response filters need the context to write an aborted response
+		if filter.ResponseFilter(ctx) != nil {
+			ctx.SetValue(httpContextKey{}, ctx)
+		}
+
 		p.reverseProxy.ServeHTTP(ctx.ResponseWriter, ctx.Request)
 		return
 	}
 
-	message, err := p.parser.TransformToRPC(ctx)
+	var (
+		message request.RPCRequest
+		body    []byte
+		err     error
+	)
+
+	// the requests without the rpc headers are bound by the google.api.http options once the server is picked
+	if matched {
+		message, err = p.parser.TransformToRPC(ctx)
+		if err != nil {
+			mainLog.Errorf("Transform to RPC error: %v", err)
+			p.abortRPC(ctx, "", status.Error(codes.InvalidArgument, err.Error()))
+			return
+		}
+	} else if body, err = ioutil.ReadAll(ctx.Request.Body); err != nil {
+		ctx.AbortWithStatusMsg(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	u, err := p.pick(ctx.Request)
 	if err != nil {
-		mainLog.Errorf("Transform to RPC error: %v", err)
-		ctx.AbortWithMsg(err.Error())
+		p.abortRPC(ctx, "", status.Error(codes.Unavailable, err.Error()))
 		return
 	}
 
-	target, err := p.nextTarget(ctx.Request)
+	// canceled unless completed before returning
+	defer p.done(u, context.Canceled)
+	p.bind(ctx.ResponseWriter, u)
+
+	target, err := url.Parse(u.service.Addr)
 	if err != nil {
 		ctx.AbortWithMsg(err.Error())
 		return
@@ -100,19 +232,36 @@ func (p *Proxy) ServeHTTP(ctx tx.HttpContext) {
 	newCtx, cancel := context.WithCancel(ctx.Request.Context())
 	defer cancel()
 
-	conn, err := grpc.Dial(target.Host, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conns, descriptors, err := p.rpc()
+	if err != nil {
+		p.done(u, err)
+		p.abortRPC(ctx, target.Host, err)
+		return
+	}
+
+	conn, release, err := conns.Get(target.Host)
 	if err != nil {
 		mainLog.Errorf("Cannot connect to %s err: %v", target.Host, err)
-		ctx.AbortWithMsg(err.Error())
+		p.done(u, err)
+		p.abortRPC(ctx, target.Host, err)
 		return
 	}
-	defer conn.Close()
+	defer release()
+
+	if !matched {
+		if message, err = p.transcode(newCtx, descriptors, ctx.Request, target.Host, body); err != nil {
+			p.done(u, rpcFailure(err))
+			p.abortRPC(ctx, target.Host, err)
+			return
+		}
+	}
 
 	// call grpc request
-	client := request.NewRPCClient(newCtx, conn)
+	client := request.NewRPCClient(conn, target.Host, descriptors)
 	resp, err := client.Call(newCtx, message)
 	if err != nil {
-		ctx.AbortWithMsg(err.Error())
+		p.done(u, rpcFailure(err))
+		p.abortRPC(ctx, target.Host, err)
 		return
 	}
 
@@ -147,13 +296,21 @@ func (p *Proxy) ServeHTTP(ctx tx.HttpContext) {
 			{
 				if err != nil {
 					log.Println("[PROXY] RPC server error: ", err)
+					p.done(u, rpcFailure(err))
 					ctx.Error(err)
-					break
+
+					// the status of an upgraded connection is already written
+					if !resp.IsStream {
+						p.abortRPC(ctx, target.Host, err)
+					}
+					return
 				}
 			}
 		case data, ok := <-resp.DataChan:
 			{
 				if !ok {
+					p.done(u, nil)
+
 					if !resp.IsStream {
 						return
 					}
@@ -166,10 +323,18 @@ func (p *Proxy) ServeHTTP(ctx tx.HttpContext) {
 				}
 
 				mainLog.Infof("received data: %s", string(data))
+				u.responded()
 
 				if !resp.IsStream {
+					p.done(u, nil)
+
 					headers := p.parser.ToHeaders(resp.RespHeader)
 
+					if filter.ResponseFilter(ctx) != nil {
+						p.writeRPCResponse(ctx, headers, data)
+						return
+					}
+
 					ctx.SetResponseHeaders(headers)
 					ctx.ToJSON(data)
 					return
@@ -189,7 +354,11 @@ func (p *Proxy) ServeHTTP(ctx tx.HttpContext) {
 		case <-newCtx.Done():
 			{
 				mainLog.Debug("[PROXY] rpc request Timeout")
-				ctx.AbortWithMsg("Rpc request timeout.")
+
+				// deadline exceeded or canceled by the client
+				if !resp.IsStream {
+					p.abortRPC(ctx, target.Host, newCtx.Err())
+				}
 				return
 			}
 		default:
@@ -201,31 +370,29 @@ func (p *Proxy) createDirector() func(req *http.Request) {
 	return func(req *http.Request) {
 		var targetToUse *url.URL
 		var err error
+		var rewritten bool
 
 		config := p.proxyConfig
 		target := req.URL
 
-		switch {
-		case config.URLRewriteEnabled:
-			{
-				if rule := p.ReWrite.Match(req.URL.Path); rule != nil {
-					targetToUse, err = rule.ReWrite(*req)
-					if err != nil {
-						mainLog.Errorf("[PROXY] Url rewrite error: %v", err)
-					}
+		if config.URLRewriteEnabled {
+			if rule := p.ReWrite.Match(req.URL.Path); rule != nil {
+				rewritten = true
+				targetToUse, err = rule.ReWrite(*req)
+				if err != nil {
+					mainLog.Errorf("[PROXY] Url rewrite error: %v", err)
 				}
 			}
+		}
 
-		case config.LoadBalancingEnabled:
-			{
-				targetToUse, err = p.nextTarget(req)
+		if !rewritten {
+			// the upstream is picked before proxying so that its result can be reported
+			if u, ok := req.Context().Value(upstreamKey{}).(*upstream); ok {
+				targetToUse, err = url.Parse(u.service.Addr)
 				if err != nil {
 					mainLog.Errorf("[PROXY] Get backend target error: %s", err)
 				}
-			}
-
-		default:
-			{
+			} else {
 				targetToUse = req.URL
 			}
 		}
@@ -239,7 +406,7 @@ func (p *Proxy) createDirector() func(req *http.Request) {
 			req.URL.Scheme = targetToUse.Scheme
 			req.URL.Host = targetToUse.Host
 
-			if config.URLRewriteEnabled {
+			if rewritten {
 				req.URL.Path = targetToUse.Path
 			} else {
 				req.URL.Path = singleJoiningSlash(targetToUse.Path, req.URL.Path)
@@ -281,9 +448,32 @@ func (p *Proxy) createDirector() func(req *http.Request) {
 	}
 }
 
-// TODO Response modify
 func (p *Proxy) createModifyResponse() func(*http.Response) error {
 	return func(resp *http.Response) error {
+		if u, ok := resp.Request.Context().Value(upstreamKey{}).(*upstream); ok {
+			u.responded()
+
+			var err error
+			if resp.StatusCode >= http.StatusInternalServerError {
+				err = fmt.Errorf("upstream status %d", resp.StatusCode)
+			}
+
+			// the body of an upgraded connection must stay a io.ReadWriteCloser, it is in flight until closed
+			if conn, ok := resp.Body.(io.ReadWriteCloser); ok && resp.StatusCode == http.StatusSwitchingProtocols {
+				resp.Body = &doneConn{ReadWriteCloser: conn, done: func() { p.done(u, err) }}
+			} else if resp.Body == nil {
+				p.done(u, err)
+			} else {
+				resp.Body = &doneBody{ReadCloser: resp.Body, done: func() { p.done(u, err) }}
+			}
+		}
+
+		if ctx, ok := resp.Request.Context().Value(httpContextKey{}).(tx.HttpContext); ok {
+			if err := filterResponse(ctx, resp); err != nil {
+				return err
+			}
+		}
+
 		if isOkResponse(resp) {
 			return nil
 		}
@@ -314,34 +504,390 @@ func (p *Proxy) createModifyResponse() func(*http.Response) error {
 	}
 }
 
-// nextTarget returns the next available target from the load balance.
-func (p *Proxy) nextTarget(req *http.Request) (*url.URL, error) {
+func (p *Proxy) createErrorHandler() func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, req *http.Request, err error) {
+		// the response has been written by the filter
+		if errors.Is(err, ResponseAbortedErr) {
+			return
+		}
+
+		mainLog.Errorf("[PROXY] Proxy %s error: %v", req.URL.String(), err)
+
+		// connection failures, the requests with a response are reported by ModifyResponse already
+		if u, ok := req.Context().Value(upstreamKey{}).(*upstream); ok {
+			p.done(u, err)
+		}
+
+		defaultErrorHandler(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+	}
+}
+
+// filterResponse runs the response filters on resp, the response replaced by the filters is copied into resp.
+// It returns ResponseAbortedErr if a filter did not pass the response on.
+func filterResponse(ctx tx.HttpContext, resp *http.Response) error {
+	handle := filter.ResponseFilter(ctx)
+	if handle == nil {
+		return nil
+	}
+
+	ctx.Response = resp
+
+	var passed bool
+	handle(ctx, func(ctx tx.HttpContext) {
+		passed = true
+
+		if ctx.Response != resp && ctx.Response != nil {
+			if resp.Body != nil {
+				resp.Body.Close()
+			}
+			*resp = *ctx.Response
+		}
+	})
+
+	if !passed {
+		return ResponseAbortedErr
+	}
+
+	return nil
+}
+
+// writeRPCResponse runs the response filters on the rpc response and writes it to the client.
+func (p *Proxy) writeRPCResponse(ctx tx.HttpContext, headers map[string]string, data []byte) {
+	resp := &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        make(http.Header, len(headers)+1),
+		Body:          ioutil.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       ctx.Request,
+	}
+
+	for k, v := range headers {
+		resp.Header.Set(k, v)
+	}
+	resp.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	if err := filterResponse(ctx, resp); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	for k, v := range resp.Header {
+		ctx.ResponseWriter.Header()[k] = v
+	}
+	ctx.WriteStatusCode(resp.StatusCode)
+
+	if _, err := io.Copy(ctx.ResponseWriter, resp.Body); err != nil {
+		mainLog.Errorf("[PROXY] Write response error: %v", err)
+	}
+}
+
+// balanced reports whether the request is proxied to a load balanced upstream.
+func (p *Proxy) balanced(req *http.Request) bool {
+	if !p.proxyConfig.LoadBalancingEnabled {
+		return false
+	}
+
+	return !p.proxyConfig.URLRewriteEnabled || p.ReWrite.Match(req.URL.Path) == nil
+}
+
+// pick returns the upstream of the request, the end point header bypasses the discovery.
+func (p *Proxy) pick(req *http.Request) (*upstream, error) {
 	endPoint := req.Header.Get("X-TinyKit-EndPoint")
 	if endPoint != "" {
 		mainLog.Infof("Get remote end point from header %s", endPoint)
-		return url.Parse(endPoint)
+		return &upstream{service: &registry.Service{Addr: endPoint}, pinned: true, start: time.Now()}, nil
 	}
 
-	services, err := p.builder.GetService()
+	services, err := p.services()
+	if err != nil {
+		return nil, err
+	}
+
+	// the subset is routed before the binding, so that the bound service is in the subset
+	picker := p.balancer
+	if p.subsets != nil {
+		i, ok := p.subsets.matchHeaders(req)
+		if !ok && p.sticky != nil {
+			// a bound client stays in the subset of its service instead of being drawn again
+			if service := p.sticky.lookup(req, services); service != nil {
+				i, ok = p.subsets.owner(service)
+			}
+		}
+
+		if !ok {
+			i = p.subsets.draw()
+		}
+
+		if matched := p.subsets.list[i].filter(services); len(matched) > 0 {
+			services, picker = matched, p.subsets.pickers[i]
+		} else {
+			mainLog.Warnf("No service in subset %s, fallback to all the services.", p.subsets.list[i].Name)
+		}
+	}
+
+	if p.sticky != nil {
+		if service := p.sticky.lookup(req, services); service != nil {
+			return &upstream{service: service, picker: picker, bound: true, start: time.Now()}, nil
+		}
+	}
+
+	service, err := p.nextService(req, picker, services)
 	if err != nil {
-		mainLog.Errorf("Fail to get service from discovery(%s): %v", p.builder.Scheme(), err)
 		return nil, err
 	}
 
-	ip, err := utils.GetIPAddr(req)
+	return &upstream{service: service, picker: picker, start: time.Now()}, nil
+}
+
+// bind sets the affinity cookie of the picked upstream, the headers set before proxying are kept
+// in the upstream response and in the websocket upgrade response.
+func (p *Proxy) bind(w http.ResponseWriter, u *upstream) {
+	if p.sticky == nil || u.pinned || u.bound {
+		return
+	}
+
+	http.SetCookie(w, p.sticky.cookie(u.service.Addr))
+}
+
+// done reports the completion of the request to the upstream, nil err means success.
+func (p *Proxy) done(u *upstream, err error) {
+	u.once.Do(func() {
+		if u.pinned {
+			return
+		}
+
+		if tracker, ok := u.picker.(balance.Tracker); ok && !u.bound {
+			u.responded()
+			tracker.Done(u.service, balance.DoneInfo{Err: err, Latency: u.latency})
+		}
+
+		// canceled by the client, not a failure of the upstream
+		if p.detector != nil && !errors.Is(err, context.Canceled) {
+			p.detector.Report(u.service.Addr, err)
+		}
+	})
+}
+
+// doneBody reports the completion of the request once the response body is closed.
+type doneBody struct {
+	io.ReadCloser
+	done func()
+}
+
+func (b *doneBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.done()
+	return err
+}
+
+// doneConn reports the completion of the request once the upgraded connection is closed.
+type doneConn struct {
+	io.ReadWriteCloser
+	done func()
+}
+
+func (c *doneConn) Close() error {
+	err := c.ReadWriteCloser.Close()
+	c.done()
+	return err
+}
+
+// abortRPC writes the grpc status of the failed rpc to addr as the http response, the http status is
+// mapped from the grpc code and Retry-After is set by the google.rpc.RetryInfo detail. Empty addr means
+// no server was picked.
+func (p *Proxy) abortRPC(ctx tx.HttpContext, addr string, err error) {
+	st := request.Status(err)
+
+	p.rpcMu.Lock()
+	descriptors := p.descriptors
+	p.rpcMu.Unlock()
+
+	body, merr := request.MarshalStatus(st, descriptors.AnyResolver(addr))
+	if merr != nil {
+		mainLog.Errorf("Marshal rpc status error: %v", merr)
+		ctx.AbortWithStatusMsg(request.HTTPStatusFromCode(st.Code()), st.Message())
+		return
+	}
+
+	if delay, ok := request.RetryAfter(st); ok {
+		ctx.SetResponseHeader("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+	}
+
+	// headers must be set before writing the status code
+	ctx.SetResponseHeader("Content-Type", "application/json; charset=utf-8")
+	ctx.AbortWithStatus(request.HTTPStatusFromCode(st.Code()))
+	ctx.ResponseWriter.Write(body)
+}
+
+// rpcFailure returns err if it means the rpc server is unavailable, other errors are answers of the server.
+func rpcFailure(err error) error {
+	if errors.Is(err, request.UnavailableErr) || status.Code(err) == codes.Unavailable {
+		return err
+	}
+
+	return nil
+}
+
+// balanceKey returns the key to hash the request by, the client ip if the hash key is absent.
+func (p *Proxy) balanceKey(req *http.Request) (string, error) {
+	if p.hashKey != nil {
+		if key, ok := p.hashKey(req); ok {
+			return key, nil
+		}
+	}
+
+	return utils.GetIPAddr(req)
+}
+
+// nextService returns the next available service picked by the picker.
+func (p *Proxy) nextService(req *http.Request, picker balance.Picker, services []*registry.Service) (*registry.Service, error) {
+	key, err := p.balanceKey(req)
 	if err != nil {
 		mainLog.Errorf("Fail to get ip addr from req: %v", err)
 		return nil, err
 	}
 
-	service, err := p.balancer.Pick(ip, services)
+	service, err := picker.Pick(key, services)
 	if err != nil {
-		mainLog.Errorf("Fail to get service from balance(%s): %v", p.balancer.Scheme(), err)
+		mainLog.Errorf("Fail to get service from balance(%s): %v", picker.Scheme(), err)
 		return nil, err
 	}
 
 	mainLog.Infof("Got Service: Name(%s) Addr(%s) Weight(%d)", service.Name, service.Addr, service.Weight)
-	return url.Parse(service.Addr)
+	return service, nil
+}
+
+// services returns the discovered services available to pick.
+func (p *Proxy) services() ([]*registry.Service, error) {
+	services, err := p.builder.GetService()
+	if err != nil {
+		mainLog.Errorf("Fail to get service from discovery(%s): %v", p.builder.Scheme(), err)
+		return nil, err
+	}
+
+	// only pick the services passing the active health check
+	if p.checker != nil {
+		services = p.checker.Filter(services)
+	}
+
+	// and not ejected by the outlier detection
+	if p.detector != nil {
+		services = p.detector.Filter(services)
+	}
+
+	return services, nil
+}
+
+// transcode creates the rpc request bound to the http request by the google.api.http options of the services at addr.
+func (p *Proxy) transcode(ctx context.Context, descriptors *request.DescriptorCache, req *http.Request, addr string, body []byte) (request.RPCRequest, error) {
+	b, vars, err := descriptors.Match(ctx, addr, req.Method, req.URL.EscapedPath())
+	if err != nil {
+		return request.RPCRequest{}, err
+	}
+
+	msg, err := b.NewRequest(vars, req.URL.Query(), body)
+	if err != nil {
+		return request.RPCRequest{}, err
+	}
+
+	return request.RPCRequest{
+		ServicePath:   b.Method.GetService().GetFullyQualifiedName(),
+		ServiceMethod: b.Method.GetName(),
+		Metadata:      p.parser.GetMetaDataFromHeaders(req.Header.Clone()),
+		Method:        b.Method,
+		Message:       msg,
+		ResponseBody:  b.ResponseBody,
+	}, nil
+}
+
+// members returns the hosts of the discovered services, the keys of the rpc connections.
+func (p *Proxy) members() []string {
+	services := p.builder.ListServer()
+
+	hosts := make([]string, 0, len(services))
+	for _, v := range services {
+		if target, err := url.Parse(v.Addr); err == nil {
+			hosts = append(hosts, target.Host)
+		}
+	}
+
+	return hosts
+}
+
+// rpc returns the connection pool and the descriptor cache of the upstream services, they are created
+// by the first rpc request so that the plain http proxies do not connect and resolve in the background.
+func (p *Proxy) rpc() (*request.ConnPool, *request.DescriptorCache, error) {
+	p.rpcMu.Lock()
+	defer p.rpcMu.Unlock()
+
+	if p.closed {
+		return nil, nil, request.PoolClosedErr
+	}
+
+	if p.conns == nil {
+		// the connections of a named pool are kept across reloads
+		p.conns = request.AcquirePool(p.grpc, p.members)
+		p.descriptors = request.NewDescriptorCache(p.descriptor, p.conns, p.members)
+	}
+
+	return p.conns, p.descriptors, nil
+}
+
+// PoolStats returns the stats of the rpc connections to the upstream services, nil if no rpc request
+// was proxied.
+func (p *Proxy) PoolStats() *request.PoolStats {
+	p.rpcMu.Lock()
+	defer p.rpcMu.Unlock()
+
+	if p.conns == nil {
+		return nil
+	}
+
+	stats := p.conns.Stats()
+	return &stats
+}
+
+// HealthStatus returns the health states of the upstream services, nil if health check is disabled.
+func (p *Proxy) HealthStatus() []health.Status {
+	if p.checker == nil {
+		return nil
+	}
+
+	return p.checker.Status()
+}
+
+// OutlierStats returns the outlier states of the upstream services and the ejection counters,
+// nil if outlier detection is disabled.
+func (p *Proxy) OutlierStats() *outlier.Stats {
+	if p.detector == nil {
+		return nil
+	}
+
+	stats := p.detector.Stats()
+	return &stats
+}
+
+// Close stops the background tasks of the proxy, e.g. the health check, and closes the rpc connections once released.
+func (p *Proxy) Close() {
+	if p.checker != nil {
+		p.checker.Stop()
+	}
+
+	if p.detector != nil {
+		p.detector.Release()
+	}
+
+	p.rpcMu.Lock()
+	p.closed = true
+	if p.conns != nil {
+		p.descriptors.Close()
+		p.conns.Release()
+	}
+	p.rpcMu.Unlock()
+
+	if p.release != nil {
+		p.release()
+	}
 }
 
 // isOkResponse check either the response status code is ok or not.
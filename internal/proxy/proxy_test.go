@@ -1,10 +1,496 @@
 package proxy
 
 import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+
+	tx "github.com/KKKKjl/tinykit/internal/context"
+	"github.com/KKKKjl/tinykit/internal/filter"
+	"github.com/KKKKjl/tinykit/internal/registry"
+	"github.com/KKKKjl/tinykit/internal/registry/balance"
+	"github.com/KKKKjl/tinykit/internal/registry/outlier"
+	"github.com/KKKKjl/tinykit/internal/registry/static"
 )
 
+func newTestProxy(addr string) *Proxy {
+	return New(ProxyConfig{LoadBalancingEnabled: true}, WithBuilder(static.New(&registry.Service{Addr: addr, Weight: 1})))
+}
+
+func serve(p *Proxy, chains *filter.FilterChains) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+
+	chains.Compose()(tx.New(w, req), p.ServeHTTP)
+	return w
+}
+
 func TestModifyResponse(t *testing.T) {
-	// assert := assert.New(t)
+	assert := assert.New(t)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream", "1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	}))
+	defer upstream.Close()
+
+	chains := filter.NewFilterChains()
+	chains.Use(filter.Handler{
+		Name: "modify",
+		Type: filter.RespMode,
+		Handle: func(ctx tx.HttpContext, next filter.Next) {
+			body, err := ctx.ResponseBody()
+			assert.Nil(err)
+
+			ctx.Response.StatusCode = http.StatusOK
+			ctx.Response.Header.Del("X-Upstream")
+			ctx.SetResponseBody(append(body, " world"...))
+
+			next(ctx)
+		},
+	})
+
+	w := serve(newTestProxy(upstream.URL), chains)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("", w.Header().Get("X-Upstream"))
+	assert.Equal("hello world", w.Body.String())
+}
+
+func TestAbortResponse(t *testing.T) {
+	assert := assert.New(t)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("internal details"))
+	}))
+	defer upstream.Close()
+
+	chains := filter.NewFilterChains()
+	chains.Use(filter.Handler{
+		Name: "abort",
+		Type: filter.RespMode,
+		Handle: func(ctx tx.HttpContext, next filter.Next) {
+			if ctx.Response.StatusCode >= http.StatusInternalServerError {
+				ctx.AbortWithStatusMsg(http.StatusBadGateway, "upstream error")
+				return
+			}
+
+			next(ctx)
+		},
+	})
+
+	w := serve(newTestProxy(upstream.URL), chains)
+	assert.Equal(http.StatusBadGateway, w.Code)
+	assert.JSONEq(`{"code":502,"message":"upstream error","data":null}`, w.Body.String())
+}
+
+func TestReplaceResponse(t *testing.T) {
+	assert := assert.New(t)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer upstream.Close()
+
+	chains := filter.NewFilterChains()
+	chains.Use(filter.Handler{
+		Name: "replace",
+		Type: filter.RespMode,
+		Handle: func(ctx tx.HttpContext, next filter.Next) {
+			body, err := ctx.ResponseBody()
+			assert.Nil(err)
+			assert.Equal("hello", string(body))
+
+			resp := *ctx.Response
+			resp.StatusCode = http.StatusAccepted
+			resp.Header = http.Header{}
+			resp.Body = ioutil.NopCloser(nil)
+			ctx.Response = &resp
+			ctx.SetResponseBody([]byte("replaced"))
+
+			next(ctx)
+		},
+	})
+
+	w := serve(newTestProxy(upstream.URL), chains)
+	assert.Equal(http.StatusAccepted, w.Code)
+	assert.Equal("replaced", w.Body.String())
+}
+
+func TestOutlierDetection(t *testing.T) {
+	assert := assert.New(t)
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer healthy.Close()
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	// unreachable
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	p := New(ProxyConfig{LoadBalancingEnabled: true},
+		WithBuilder(static.New(
+			&registry.Service{Addr: healthy.URL, Weight: 1},
+			&registry.Service{Addr: failing.URL, Weight: 1},
+			&registry.Service{Addr: closed.URL, Weight: 1},
+		)),
+		WithOutlierDetection(outlier.Config{ConsecutiveErrors: 2, MaxEjectionPercent: 100}),
+	)
+
+	for i := 0; i < 6; i++ {
+		serve(p, filter.NewFilterChains())
+	}
+
+	for i := 0; i < 3; i++ {
+		w := serve(p, filter.NewFilterChains())
+		assert.Equal(http.StatusOK, w.Code)
+	}
+
+	// only failed services have a state
+	stats := p.OutlierStats()
+	assert.Equal(2, stats.Ejected)
+	assert.Len(stats.Services, 2)
+	for _, v := range stats.Services {
+		assert.True(v.Ejected, v.Addr)
+	}
+}
+
+// countingPicker picks the first service and counts the outstanding calls.
+type countingPicker struct {
+	mu       sync.Mutex
+	inflight int
+	errs     []error
+	latency  time.Duration
+}
+
+func (c *countingPicker) Pick(key string, services []*registry.Service) (*registry.Service, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inflight++
+	return services[0], nil
+}
+
+func (c *countingPicker) outstanding() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.inflight
+}
+
+func (c *countingPicker) Scheme() string {
+	return "counting"
+}
+
+func (c *countingPicker) Done(service *registry.Service, info balance.DoneInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inflight--
+	c.errs = append(c.errs, info.Err)
+	c.latency += info.Latency
+}
+
+func TestPickerDone(t *testing.T) {
+	assert := assert.New(t)
+
+	var status int32 = http.StatusOK
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+		w.Write([]byte("hello"))
+	}))
+	defer upstream.Close()
+
+	picker := &countingPicker{}
+	p := newTestProxy(upstream.URL)
+	p.balancer = picker
+
+	w := serve(p, filter.NewFilterChains())
+	assert.Equal("hello", w.Body.String())
+
+	atomic.StoreInt32(&status, http.StatusInternalServerError)
+	serve(p, filter.NewFilterChains())
+
+	// pinned by the end point header
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.Header.Set("X-TinyKit-EndPoint", upstream.URL)
+	filter.NewFilterChains().Compose()(tx.New(httptest.NewRecorder(), req), p.ServeHTTP)
+
+	assert.Equal(0, picker.inflight)
+	assert.Len(picker.errs, 2)
+	assert.Nil(picker.errs[0])
+	assert.EqualError(picker.errs[1], "upstream status 500")
+	assert.Greater(picker.latency, time.Duration(0))
+}
+
+func TestStickySession(t *testing.T) {
+	assert := assert.New(t)
+
+	builder := static.New()
+	for i := 0; i < 3; i++ {
+		name := strconv.Itoa(i)
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+		defer upstream.Close()
+
+		builder.PutServer(&registry.Service{Addr: upstream.URL, Weight: 1})
+	}
+
+	p := New(ProxyConfig{LoadBalancingEnabled: true}, WithBuilder(builder), WithStickySession(StickyConfig{Secret: "secret"}))
+
+	send := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+
+		filter.NewFilterChains().Compose()(tx.New(w, req), p.ServeHTTP)
+		return w
+	}
+
+	w := send(nil)
+	cookies := w.Result().Cookies()
+	if !assert.Len(cookies, 1) {
+		return
+	}
+	assert.Equal("tinykit_affinity", cookies[0].Name)
+
+	// bound to the first service without setting the cookie again
+	for i := 0; i < 5; i++ {
+		bound := send(cookies[0])
+		assert.Equal(w.Body.String(), bound.Body.String())
+		assert.Empty(bound.Result().Cookies())
+	}
+
+	// a forged cookie is rebalanced
+	forged := *cookies[0]
+	forged.Value = forged.Value[:len(forged.Value)-2] + "AA"
+	assert.Len(send(&forged).Result().Cookies(), 1)
+
+	// the cookie does not expose the addr
+	var addr string
+	for _, v := range builder.ListServer() {
+		assert.NotContains(cookies[0].Value, strings.TrimPrefix(v.Addr, "http://"))
+		if p.sticky.id(v.Addr) == cookies[0].Value {
+			addr = v.Addr
+		}
+	}
+
+	// falls back to the balancer once the bound service is gone
+	builder.DelServer(&registry.Service{Addr: addr})
+
+	rebound := send(cookies[0])
+	assert.NotEqual(w.Body.String(), rebound.Body.String())
+	assert.Len(rebound.Result().Cookies(), 1)
+}
+
+func TestStickyRandomSecret(t *testing.T) {
+	assert := assert.New(t)
+
+	// the proxies rebuilt by a reload accept the cookies of the previous ones
+	service := &registry.Service{Addr: "http://127.0.0.1:8080"}
+	cookie := newSticky(StickyConfig{}).cookie(service.Addr)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	assert.Equal(service, newSticky(StickyConfig{}).lookup(req, []*registry.Service{service}))
+	assert.Nil(newSticky(StickyConfig{Secret: "secret"}).lookup(req, []*registry.Service{service}))
+}
+
+func TestStickyWebsocket(t *testing.T) {
+	assert := assert.New(t)
+
+	upgrader := websocket.Upgrader{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+	}))
+	defer upstream.Close()
+
+	p := New(ProxyConfig{LoadBalancingEnabled: true},
+		WithBuilder(static.New(&registry.Service{Addr: upstream.URL, Weight: 1})),
+		WithStickySession(StickyConfig{Secret: "secret"}),
+	)
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter.NewFilterChains().Compose()(tx.New(w, r), p.ServeHTTP)
+	}))
+	defer gateway.Close()
+
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(gateway.URL, "http"), nil)
+	if !assert.Nil(err) {
+		return
+	}
+	defer conn.Close()
+
+	// the affinity cookie is set on the upgrade response
+	assert.Equal(http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Len(resp.Cookies(), 1)
+
+	_, data, err := conn.ReadMessage()
+	assert.Nil(err)
+	assert.Equal("hello", string(data))
+}
+
+func TestWebsocketDone(t *testing.T) {
+	assert := assert.New(t)
+
+	upgrader := websocket.Upgrader{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+		conn.ReadMessage()
+	}))
+	defer upstream.Close()
+
+	picker := &countingPicker{}
+	p := newTestProxy(upstream.URL)
+	p.balancer = picker
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter.NewFilterChains().Compose()(tx.New(w, r), p.ServeHTTP)
+	}))
+	defer gateway.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(gateway.URL, "http"), nil)
+	if !assert.Nil(err) {
+		return
+	}
+
+	_, data, err := conn.ReadMessage()
+	assert.Nil(err)
+	assert.Equal("hello", string(data))
+
+	// the upgraded connection stays in flight until it is closed
+	assert.Equal(1, picker.outstanding())
+
+	conn.Close()
+	assert.Eventually(func() bool { return picker.outstanding() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestSubsets(t *testing.T) {
+	assert := assert.New(t)
+
+	builder := static.New()
+	for i, v := range []*registry.Service{
+		{Version: "v1", Metadata: map[string]string{"zone": "a"}},
+		{Version: "v1", Metadata: map[string]string{"Zone": "b"}},
+		{Version: "v2", Metadata: map[string]string{"zone": "a"}},
+	} {
+		name := v.Version + "-" + strconv.Itoa(i)
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+		defer upstream.Close()
+
+		v.Addr = upstream.URL
+		builder.PutServer(v)
+	}
+
+	p := New(ProxyConfig{LoadBalancingEnabled: true, BalancingType: balance.ROUND_ROBIN}, WithBuilder(builder), WithSubsets(
+		Subset{Name: "stable", Version: "v1", Weight: 95},
+		Subset{Name: "canary", Version: "v2", Weight: 5, Headers: map[string]string{"x-canary": "true"}},
+		Subset{Name: "zone-b", Labels: map[string]string{"zone": "b"}, Headers: map[string]string{"X-Zone-B": ""}},
+		Subset{Name: "empty", Version: "v3", Headers: map[string]string{"X-Empty": ""}},
+	))
+
+	send := func(header ...string) string {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+		if len(header) == 2 {
+			req.Header.Set(header[0], header[1])
+		}
+
+		filter.NewFilterChains().Compose()(tx.New(w, req), p.ServeHTTP)
+		return w.Body.String()
+	}
+
+	for i := 0; i < 5; i++ {
+		assert.Equal("v2-2", send("X-Canary", "true"))
+		assert.Equal("v1-1", send("X-Zone-B", "1"))
+	}
+
+	// split by weight
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		counts[send("X-Canary", "false")]++
+	}
+	assert.InDelta(475, counts["v1-0"], 60)
+	assert.InDelta(475, counts["v1-1"], 60)
+	assert.InDelta(50, counts["v2-2"], 30)
+
+	// an empty subset falls back to all the services
+	assert.NotEmpty(send("X-Empty", "1"))
+}
+
+func TestStickySubsets(t *testing.T) {
+	assert := assert.New(t)
+
+	builder := static.New()
+	for _, v := range []string{"v1", "v2"} {
+		version := v
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(version))
+		}))
+		defer upstream.Close()
+
+		builder.PutServer(&registry.Service{Version: version, Addr: upstream.URL, Weight: 1})
+	}
+
+	p := New(ProxyConfig{LoadBalancingEnabled: true}, WithBuilder(builder), WithStickySession(StickyConfig{Secret: "secret"}), WithSubsets(
+		Subset{Name: "stable", Version: "v1", Weight: 50},
+		Subset{Name: "canary", Version: "v2", Weight: 50},
+	))
+
+	send := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+
+		filter.NewFilterChains().Compose()(tx.New(w, req), p.ServeHTTP)
+		return w
+	}
+
+	// the bound client is not moved between the subsets by the weighted split
+	for _, version := range []string{"v1", "v2"} {
+		var cookie *http.Cookie
+		for cookie == nil {
+			if w := send(nil); w.Body.String() == version {
+				cookie = w.Result().Cookies()[0]
+			}
+		}
 
+		for i := 0; i < 20; i++ {
+			w := send(cookie)
+			assert.Equal(version, w.Body.String())
+			assert.Empty(w.Result().Cookies())
+		}
+	}
 }
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/KKKKjl/tinykit/internal/registry"
+)
+
+const _defaultStickyCookie = "tinykit_affinity"
+
+var (
+	// fallback secret of the proxies without one, shared across reloads
+	randomSecret     []byte
+	randomSecretOnce sync.Once
+)
+
+// StickyConfig binds a client to the service picked for its first request by a cookie, the cookie holds
+// an opaque id of the service keyed by the secret, not its addr.
+// Empty secret means a random one generated once per process, the cookies then survive reloads but not
+// restarts and are not accepted by other replicas, so running more than one replica requires a secret.
+type StickyConfig struct {
+	Cookie   string
+	Secret   string
+	Path     string
+	MaxAge   time.Duration // zero means a session cookie
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+}
+
+type sticky struct {
+	conf   StickyConfig
+	secret []byte
+}
+
+func newSticky(c StickyConfig) *sticky {
+	if c.Cookie == "" {
+		c.Cookie = _defaultStickyCookie
+	}
+
+	if c.Path == "" {
+		c.Path = "/"
+	}
+
+	secret := []byte(c.Secret)
+	if len(secret) == 0 {
+		secret = fallbackSecret()
+	}
+
+	return &sticky{conf: c, secret: secret}
+}
+
+// fallbackSecret returns the random secret of the process, it is generated and warned about once.
+func fallbackSecret() []byte {
+	randomSecretOnce.Do(func() {
+		mainLog.Warn("No sticky session secret configured, using a random one until the gateway restarts, set one when running more than one replica.")
+
+		randomSecret = make([]byte, 32)
+		if _, err := rand.Read(randomSecret); err != nil {
+			mainLog.Fatalf("Fail to generate sticky session secret: %v", err)
+		}
+	})
+
+	return randomSecret
+}
+
+// lookup returns the service of the affinity cookie if it is one of the services.
+func (s *sticky) lookup(req *http.Request, services []*registry.Service) *registry.Service {
+	cookie, err := req.Cookie(s.conf.Cookie)
+	if err != nil {
+		return nil
+	}
+
+	for _, v := range services {
+		if v != nil && hmac.Equal([]byte(cookie.Value), []byte(s.id(v.Addr))) {
+			return v
+		}
+	}
+
+	return nil
+}
+
+// cookie returns the affinity cookie of the service at addr.
+func (s *sticky) cookie(addr string) *http.Cookie {
+	return &http.Cookie{
+		Name:     s.conf.Cookie,
+		Value:    s.id(addr),
+		Path:     s.conf.Path,
+		MaxAge:   int(s.conf.MaxAge / time.Second),
+		Secure:   s.conf.Secure,
+		HttpOnly: s.conf.HttpOnly,
+		SameSite: s.conf.SameSite,
+	}
+}
+
+// id returns the opaque id of the service at addr, it cannot be forged or reversed without the secret.
+func (s *sticky) id(addr string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(addr))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
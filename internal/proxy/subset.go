@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KKKKjl/tinykit/internal/registry"
+	"github.com/KKKKjl/tinykit/internal/registry/balance"
+)
+
+// Subset is a part of the upstream services, the services of the version carrying all the labels.
+// Empty version means any version. Requests carrying all the headers are routed to the subset,
+// an empty header value only requires the header to be present. The other requests are split
+// between the subsets in proportion to their weights, to the first subset if no weight is set.
+// A client bound by a sticky session stays in the weighted subset of its service.
+type Subset struct {
+	Name    string
+	Version string
+	Labels  map[string]string
+	Weight  int
+	Headers map[string]string
+}
+
+// subsets routes the requests to the subsets, every subset has its own picker so that the
+// picker states, e.g. the consistent hash ring, are kept per subset.
+type subsets struct {
+	list    []Subset
+	pickers []balance.Picker
+	total   int // total weight
+	mu      sync.Mutex
+	rand    *rand.Rand
+}
+
+func newSubsets(list []Subset, balanceType balance.BalanceType) *subsets {
+	s := &subsets{
+		list:    list,
+		pickers: make([]balance.Picker, len(list)),
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	for i, v := range list {
+		s.pickers[i] = balance.NewBalancer(balanceType)
+		if v.Weight > 0 {
+			s.total += v.Weight
+		}
+	}
+
+	return s
+}
+
+// matchHeaders returns the index of the first subset whose headers the request carries.
+func (s *subsets) matchHeaders(req *http.Request) (int, bool) {
+	for i, v := range s.list {
+		if len(v.Headers) > 0 && v.matchHeaders(req) {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// owner returns the index of the first subset of the weighted split containing the service.
+func (s *subsets) owner(service *registry.Service) (int, bool) {
+	for i, v := range s.list {
+		if (v.Weight > 0 || s.total == 0 && i == 0) && v.match(service) {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// draw returns the index of a subset drawn by weight, the first subset if no weight is set.
+func (s *subsets) draw() int {
+	if s.total == 0 {
+		return 0
+	}
+
+	s.mu.Lock()
+	n := s.rand.Intn(s.total)
+	s.mu.Unlock()
+
+	for i, v := range s.list {
+		if v.Weight <= 0 {
+			continue
+		}
+
+		if n -= v.Weight; n < 0 {
+			return i
+		}
+	}
+
+	return 0
+}
+
+func (s *Subset) matchHeaders(req *http.Request) bool {
+	for k, v := range s.Headers {
+		values, ok := req.Header[http.CanonicalHeaderKey(k)]
+		if !ok || v != "" && values[0] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// filter returns the services of the subset.
+func (s *Subset) filter(services []*registry.Service) []*registry.Service {
+	matched := make([]*registry.Service, 0, len(services))
+	for _, v := range services {
+		if v != nil && s.match(v) {
+			matched = append(matched, v)
+		}
+	}
+
+	return matched
+}
+
+func (s *Subset) match(service *registry.Service) bool {
+	if s.Version != "" && service.Version != s.Version {
+		return false
+	}
+
+	for k, v := range s.Labels {
+		if value, ok := label(service.Metadata, k); !ok || value != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// label returns the metadata value of the key, the key is case insensitive as config keys are lowercased.
+func label(metadata map[string]string, key string) (string, bool) {
+	if v, ok := metadata[key]; ok {
+		return v, true
+	}
+
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+
+	return "", false
+}
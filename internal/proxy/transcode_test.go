@@ -0,0 +1,212 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	tx "github.com/KKKKjl/tinykit/internal/context"
+	"github.com/KKKKjl/tinykit/internal/filter"
+	"github.com/KKKKjl/tinykit/internal/registry"
+	"github.com/KKKKjl/tinykit/internal/registry/static"
+	"github.com/KKKKjl/tinykit/internal/request"
+)
+
+var greeterProtos = map[string]string{
+	"google/api/annotations.proto": `syntax = "proto3";
+package google.api;
+
+import "google/protobuf/descriptor.proto";
+
+extend google.protobuf.MethodOptions {
+  HttpRule http = 72295728;
+}
+
+message HttpRule {
+  oneof pattern {
+    string get = 2;
+    string post = 4;
+  }
+  string body = 7;
+  string response_body = 12;
+}
+`,
+	"greeter.proto": `syntax = "proto3";
+package helloworld;
+
+import "google/api/annotations.proto";
+
+service Greeter {
+  rpc SayHello (HelloRequest) returns (HelloReply) {
+    option (google.api.http) = { get: "/v1/greeter/{name}" };
+  }
+  rpc Greet (HelloRequest) returns (HelloReply) {
+    option (google.api.http) = { post: "/v1/greet" body: "*" response_body: "message" };
+  }
+}
+
+message HelloRequest {
+  string name = 1;
+  int32 times = 2;
+}
+
+message HelloReply {
+  string message = 1;
+}
+
+message GreeterError {
+  string reason = 1;
+}
+`,
+}
+
+// newGreeter starts a greeter server without reflection.
+func newGreeter(t *testing.T, service *desc.ServiceDescriptor) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := grpc.NewServer(grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+		name, _ := grpc.MethodFromServerStream(stream)
+		method := service.FindMethodByName(name[strings.LastIndex(name, "/")+1:])
+		if !strings.HasPrefix(name, "/helloworld.Greeter/") || method == nil {
+			return status.Errorf(codes.Unimplemented, "unknown method %s", name)
+		}
+
+		req := dynamic.NewMessage(method.GetInputType())
+		if err := stream.RecvMsg(req); err != nil {
+			return err
+		}
+
+		if req.GetFieldByName("name") == "slow" {
+			select {
+			case <-time.After(time.Second):
+			case <-stream.Context().Done():
+				return stream.Context().Err()
+			}
+		}
+
+		// the busy server asks to retry with the reason in a detail of the greeter types
+		if req.GetFieldByName("name") == "busy" {
+			detail := dynamic.NewMessage(service.GetFile().FindMessage("helloworld.GreeterError"))
+			detail.SetFieldByName("reason", "busy")
+
+			value, err := detail.Marshal()
+			if err != nil {
+				return err
+			}
+
+			st, err := status.New(codes.ResourceExhausted, "too many greetings").WithDetails(
+				&errdetails.RetryInfo{RetryDelay: durationpb.New(1500 * time.Millisecond)},
+			)
+			if err != nil {
+				return err
+			}
+
+			pb := st.Proto()
+			pb.Details = append(pb.Details, &anypb.Any{TypeUrl: "type.googleapis.com/helloworld.GreeterError", Value: value})
+			return status.FromProto(pb).Err()
+		}
+
+		resp := dynamic.NewMessage(method.GetOutputType())
+		resp.SetFieldByName("message", strings.Repeat("hello "+req.GetFieldByName("name").(string), int(req.GetFieldByName("times").(int32))))
+		return stream.SendMsg(resp)
+	}))
+
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	return lis.Addr().String()
+}
+
+func TestTranscoding(t *testing.T) {
+	assert := assert.New(t)
+
+	parser := protoparse.Parser{Accessor: protoparse.FileContentsFromMap(greeterProtos)}
+	fds, err := parser.ParseFiles("greeter.proto")
+	assert.Nil(err)
+
+	service := fds[0].FindService("helloworld.Greeter")
+	addr := newGreeter(t, service)
+
+	p := New(ProxyConfig{LoadBalancingEnabled: true},
+		WithBuilder(static.New(&registry.Service{Addr: "grpc://" + addr, Weight: 1})),
+		WithDescriptors(request.DescriptorConfig{Services: map[string]*desc.ServiceDescriptor{"helloworld.Greeter": service}}),
+		WithTranscoding(),
+	)
+	defer p.Close()
+
+	send := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+
+		filter.NewFilterChains().Compose()(tx.New(w, req), p.ServeHTTP)
+		return w
+	}
+
+	sendTimeout := func(target string, timeout time.Duration) *httptest.ResponseRecorder {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx)
+
+		filter.NewFilterChains().Compose()(tx.New(w, req), p.ServeHTTP)
+		return w
+	}
+
+	w := send(http.MethodGet, "/v1/greeter/world?times=2", "")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.JSONEq(`{"message":"hello worldhello world"}`, w.Body.String())
+
+	w = send(http.MethodPost, "/v1/greet", `{"name":"tinykit","times":1}`)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.JSONEq(`"hello tinykit"`, w.Body.String())
+
+	w = send(http.MethodGet, "/v1/greeter/world?times=x", "")
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Contains(w.Body.String(), `"code":3`)
+
+	w = send(http.MethodGet, "/v1/greeter/busy", "")
+	assert.Equal(http.StatusTooManyRequests, w.Code)
+	assert.Equal("2", w.Header().Get("Retry-After"))
+	assert.JSONEq(`{
+		"code": 8,
+		"message": "too many greetings",
+		"details": [
+			{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "1.500s"},
+			{"@type": "type.googleapis.com/helloworld.GreeterError", "reason": "busy"}
+		]
+	}`, w.Body.String())
+
+	w = send(http.MethodDelete, "/v1/greeter/world", "")
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	w = sendTimeout("/v1/greeter/slow", 100*time.Millisecond)
+	assert.Equal(http.StatusGatewayTimeout, w.Code)
+	assert.Contains(w.Body.String(), `"code":4`)
+
+	// no server to pick
+	empty := New(ProxyConfig{LoadBalancingEnabled: true}, WithBuilder(static.New()), WithTranscoding())
+	defer empty.Close()
+
+	w = httptest.NewRecorder()
+	filter.NewFilterChains().Compose()(tx.New(w, httptest.NewRequest(http.MethodGet, "/v1/greeter/world", nil)), empty.ServeHTTP)
+	assert.Equal(http.StatusServiceUnavailable, w.Code)
+}
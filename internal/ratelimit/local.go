@@ -0,0 +1,208 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// state is the quota state of a key, fields are used depending on the algorithm.
+type state struct {
+	tokens float64   // token bucket
+	last   time.Time // last refill of token bucket
+	start  time.Time // start of the current window
+	count  int       // requests in the current window
+	prev   int       // requests in the previous window
+	seen   time.Time // last request, used to evict idle keys
+}
+
+// localLimiter keeps the quota of each key in memory, idle keys are evicted while serving requests.
+type localLimiter struct {
+	policy      Policy
+	mu          sync.Mutex
+	states      map[string]*state
+	idleTimeout time.Duration
+	lastSweep   time.Time
+	now         func() time.Time
+}
+
+func newLocalLimiter(policy Policy, o options) *localLimiter {
+	return &localLimiter{
+		policy:      policy,
+		states:      make(map[string]*state),
+		idleTimeout: o.idleTimeout,
+		lastSweep:   o.now(),
+		now:         o.now,
+	}
+}
+
+func (l *localLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	s, ok := l.states[key]
+	if !ok {
+		s = &state{tokens: float64(l.policy.Burst), last: now}
+		l.states[key] = s
+	}
+	s.seen = now
+
+	return l.policy.take(s, now), nil
+}
+
+// sweep evicts the idle keys at most once per idle timeout.
+func (l *localLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.idleTimeout {
+		return
+	}
+	l.lastSweep = now
+
+	for k, v := range l.states {
+		if now.Sub(v.seen) >= l.idleTimeout {
+			delete(l.states, k)
+		}
+	}
+}
+
+// restoreTime returns how long an idle key takes to get its full quota back.
+func (p Policy) restoreTime() time.Duration {
+	switch p.Algorithm {
+	case TokenBucket:
+		return time.Duration(float64(p.Window) * float64(p.Burst) / float64(p.Limit))
+	case SlidingWindow:
+		return 2 * p.Window
+	default:
+		return p.Window
+	}
+}
+
+// take updates the state with a request at now and reports whether it is allowed.
+func (p Policy) take(s *state, now time.Time) Result {
+	switch p.Algorithm {
+	case FixedWindow:
+		return p.fixedWindow(s, now)
+	case SlidingWindow:
+		return p.slidingWindow(s, now)
+	default:
+		return p.tokenBucket(s, now)
+	}
+}
+
+func (p Policy) tokenBucket(s *state, now time.Time) Result {
+	// tokens per second
+	rate := float64(p.Limit) / p.Window.Seconds()
+	burst := float64(p.Burst)
+
+	if elapsed := now.Sub(s.last).Seconds(); elapsed > 0 {
+		s.tokens = math.Min(burst, s.tokens+elapsed*rate)
+		s.last = now
+	}
+
+	res := Result{Limit: p.Burst}
+	if s.tokens >= 1 {
+		s.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - s.tokens) / rate)
+	}
+
+	res.Remaining = int(s.tokens)
+	res.Reset = seconds((burst - s.tokens) / rate)
+
+	return res
+}
+
+func (p Policy) fixedWindow(s *state, now time.Time) Result {
+	start := now.Truncate(p.Window)
+	if !start.Equal(s.start) {
+		s.start = start
+		s.count = 0
+	}
+
+	res := Result{
+		Limit: p.Limit,
+		Reset: start.Add(p.Window).Sub(now),
+	}
+
+	if s.count < p.Limit {
+		s.count++
+		res.Allowed = true
+	} else {
+		res.RetryAfter = res.Reset
+	}
+	res.Remaining = p.Limit - s.count
+
+	return res
+}
+
+// slidingWindow estimates the requests of the last window by weighting the previous window count
+// with its overlap, i.e. prev * (1 - elapsed/window) + count.
+func (p Policy) slidingWindow(s *state, now time.Time) Result {
+	start := now.Truncate(p.Window)
+	if !start.Equal(s.start) {
+		if start.Sub(s.start) == p.Window {
+			s.prev = s.count
+		} else {
+			s.prev = 0
+		}
+		s.start = start
+		s.count = 0
+	}
+
+	elapsed := now.Sub(start)
+	estimated := float64(s.prev)*(1-float64(elapsed)/float64(p.Window)) + float64(s.count)
+
+	res := Result{Limit: p.Limit}
+	if estimated+1 <= float64(p.Limit) {
+		s.count++
+		estimated++
+		res.Allowed = true
+	} else {
+		res.RetryAfter = p.slidingRetryAfter(s, elapsed)
+	}
+
+	res.Remaining = p.Limit - int(math.Ceil(estimated))
+	if res.Remaining < 0 {
+		res.Remaining = 0
+	}
+
+	// requests of the current window are counted until the end of the next window
+	res.Reset = p.Window - elapsed
+	if s.count > 0 {
+		res.Reset += p.Window
+	}
+
+	return res
+}
+
+// slidingRetryAfter returns how long until the estimated count leaves room for one more request.
+func (p Policy) slidingRetryAfter(s *state, elapsed time.Duration) time.Duration {
+	window := float64(p.Window)
+	free := float64(p.Limit - 1)
+	rest := p.Window - elapsed
+
+	// the previous window weight decreases within the current window
+	if s.count <= p.Limit-1 && s.prev > 0 {
+		t := window*(1-(free-float64(s.count))/float64(s.prev)) - float64(elapsed)
+		if t < float64(rest) {
+			return time.Duration(math.Max(t, 0))
+		}
+	}
+
+	// otherwise wait for the current count to become the previous window
+	var wait float64
+	if s.count > 0 {
+		wait = math.Max(0, window*(1-free/float64(s.count)))
+	}
+
+	return rest + time.Duration(wait)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,131 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func (c *clock) Add(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newLimiter(t *testing.T, policy Policy, c *clock) *localLimiter {
+	limiter, err := New(policy, WithClock(c.Now), WithIdleTimeout(time.Minute))
+	assert.Nil(t, err)
+	return limiter.(*localLimiter)
+}
+
+func allow(l Limiter, key string, n int) (allowed int, last Result) {
+	for i := 0; i < n; i++ {
+		last, _ = l.Allow(context.Background(), key)
+		if last.Allowed {
+			allowed++
+		}
+	}
+	return
+}
+
+func TestTokenBucket(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &clock{now: time.Unix(1000, 0)}
+	l := newLimiter(t, Policy{Limit: 2, Window: time.Second, Burst: 4}, c)
+
+	allowed, res := allow(l, "a", 5)
+	assert.Equal(4, allowed)
+	assert.False(res.Allowed)
+	assert.Equal(4, res.Limit)
+	assert.Equal(0, res.Remaining)
+	assert.Equal(500*time.Millisecond, res.RetryAfter)
+
+	// other keys have their own bucket
+	allowed, _ = allow(l, "b", 1)
+	assert.Equal(1, allowed)
+
+	c.Add(time.Second)
+	allowed, res = allow(l, "a", 3)
+	assert.Equal(2, allowed)
+	assert.Equal(2*time.Second, res.Reset)
+}
+
+func TestFixedWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &clock{now: time.Unix(1000, 0).Add(400 * time.Millisecond)}
+	l := newLimiter(t, Policy{Algorithm: FixedWindow, Limit: 3, Window: time.Second}, c)
+
+	allowed, res := allow(l, "a", 4)
+	assert.Equal(3, allowed)
+	assert.Equal(0, res.Remaining)
+	assert.Equal(600*time.Millisecond, res.RetryAfter)
+
+	c.Add(600 * time.Millisecond)
+	allowed, res = allow(l, "a", 1)
+	assert.Equal(1, allowed)
+	assert.Equal(2, res.Remaining)
+	assert.Equal(time.Second, res.Reset)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &clock{now: time.Unix(1000, 0)}
+	l := newLimiter(t, Policy{Algorithm: SlidingWindow, Limit: 4, Window: time.Second}, c)
+
+	allowed, _ := allow(l, "a", 4)
+	assert.Equal(4, allowed)
+
+	// a quarter of the next window, the previous window still weights 3 requests
+	c.Add(1250 * time.Millisecond)
+	allowed, res := allow(l, "a", 2)
+	assert.Equal(1, allowed)
+	assert.False(res.Allowed)
+	assert.Equal(250*time.Millisecond, res.RetryAfter)
+
+	c.Add(res.RetryAfter)
+	allowed, _ = allow(l, "a", 1)
+	assert.Equal(1, allowed)
+}
+
+func TestEvictIdle(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &clock{now: time.Unix(1000, 0)}
+	l := newLimiter(t, Policy{Limit: 1, Window: time.Second}, c)
+
+	allow(l, "a", 1)
+	allow(l, "b", 1)
+	assert.Len(l.states, 2)
+
+	c.Add(30 * time.Second)
+	allow(l, "b", 1)
+
+	c.Add(40 * time.Second)
+	allow(l, "c", 1)
+	assert.Len(l.states, 2)
+	assert.NotContains(l.states, "a")
+}
+
+func TestPolicyValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	p := Policy{Limit: 10, Window: time.Minute}
+	assert.Nil(p.Validate())
+	assert.Equal(TokenBucket, p.Algorithm)
+	assert.Equal(10, p.Burst)
+
+	assert.Equal(InvalidLimitErr, (&Policy{Window: time.Second}).Validate())
+	assert.Equal(InvalidWindowErr, (&Policy{Limit: 1}).Validate())
+	assert.NotNil((&Policy{Algorithm: "leaky", Limit: 1, Window: time.Second}).Validate())
+}
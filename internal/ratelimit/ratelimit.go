@@ -0,0 +1,164 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type Algorithm string
+
+const (
+	TokenBucket   Algorithm = "token_bucket"
+	SlidingWindow Algorithm = "sliding_window"
+	FixedWindow   Algorithm = "fixed_window"
+
+	defaultIdleTimeout  = 10 * time.Minute
+	defaultBatchSize    = 10
+	defaultSyncInterval = 100 * time.Millisecond
+)
+
+var (
+	InvalidLimitErr  = errors.New("Rate limit must be positive.")
+	InvalidWindowErr = errors.New("Rate limit window must be positive.")
+	TokenBucketErr   = errors.New("Token bucket is not supported by a shared store, use sliding_window or fixed_window.")
+)
+
+type (
+	// Policy allows Limit requests per Window for each key.
+	Policy struct {
+		Algorithm Algorithm
+		Limit     int
+		Window    time.Duration
+		Burst     int // bucket size of token bucket, default Limit
+	}
+
+	// Result is the decision of a request and the quota state of its key.
+	Result struct {
+		Allowed    bool
+		Limit      int
+		Remaining  int
+		Reset      time.Duration // until the quota is fully restored
+		RetryAfter time.Duration // until the next request is allowed, only set if not allowed
+	}
+
+	Limiter interface {
+		Allow(ctx context.Context, key string) (Result, error)
+	}
+
+	options struct {
+		idleTimeout  time.Duration
+		now          func() time.Time
+		store        Store
+		scope        string
+		batchSize    int
+		syncInterval time.Duration
+		failOpen     bool
+	}
+
+	Option func(*options)
+)
+
+// Validate checks the policy and fills the defaults.
+func (p *Policy) Validate() error {
+	if p.Algorithm == "" {
+		p.Algorithm = TokenBucket
+	}
+
+	switch p.Algorithm {
+	case TokenBucket, SlidingWindow, FixedWindow:
+	default:
+		return fmt.Errorf("unknown rate limit algorithm %q", p.Algorithm)
+	}
+
+	if p.Limit <= 0 {
+		return InvalidLimitErr
+	}
+
+	if p.Window <= 0 {
+		return InvalidWindowErr
+	}
+
+	if p.Burst <= 0 {
+		p.Burst = p.Limit
+	}
+
+	return nil
+}
+
+// New creates a limiter applying the policy to each key.
+// The quota is kept in memory unless a shared store is set by WithStore.
+func New(policy Policy, opts ...Option) (Limiter, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	o := options{
+		idleTimeout:  defaultIdleTimeout,
+		now:          time.Now,
+		batchSize:    defaultBatchSize,
+		syncInterval: defaultSyncInterval,
+		failOpen:     true,
+	}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	// a key is only evicted once its quota is fully restored, so eviction never resets a limit
+	if restore := policy.restoreTime(); o.idleTimeout < restore {
+		o.idleTimeout = restore
+	}
+
+	if o.store != nil {
+		return newStoreLimiter(policy, o)
+	}
+
+	return newLocalLimiter(policy, o), nil
+}
+
+// WithIdleTimeout sets how long the state of a key is kept after its last request.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		if timeout > 0 {
+			o.idleTimeout = timeout
+		}
+	}
+}
+
+// WithClock sets the time source, used by tests.
+func WithClock(now func() time.Time) Option {
+	return func(o *options) {
+		o.now = now
+	}
+}
+
+// WithStore shares the quota through the store, limiters with the same scope share the counters of a key.
+func WithStore(store Store, scope string) Option {
+	return func(o *options) {
+		o.store = store
+		o.scope = scope
+	}
+}
+
+// WithBatch sets how often the local requests are flushed to the shared store,
+// i.e. after size requests of a key or every interval. Size 1 hits the store on every request.
+func WithBatch(size int, interval time.Duration) Option {
+	return func(o *options) {
+		if size > 0 {
+			o.batchSize = size
+		}
+		if interval >= 0 {
+			o.syncInterval = interval
+		}
+	}
+}
+
+// WithFailOpen sets whether requests are limited by the local counters only when the shared store is unreachable,
+// otherwise they are rejected with the store error. Default true.
+func WithFailOpen(failOpen bool) Option {
+	return func(o *options) {
+		o.failOpen = failOpen
+	}
+}
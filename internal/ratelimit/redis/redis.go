@@ -0,0 +1,262 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	_defaultPoolSize = 10
+	_defaultTimeout  = time.Second
+	_defaultPrefix   = "tinykit:ratelimit:"
+)
+
+var NilReplyErr = errors.New("redis: nil reply")
+
+// incrScript adds to the counter and sets the ttl of a counter without one in one atomic step,
+// so no counter is left without a ttl, e.g. one created before a crash.
+const incrScript = `local value = redis.call('INCRBY', KEYS[1], ARGV[1])
+if redis.call('PTTL', KEYS[1]) == -1 then
+  redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return value`
+
+type Config struct {
+	Addr        string
+	Password    string
+	DB          int
+	PoolSize    int           // max idle connections
+	DialTimeout time.Duration // also the read and write timeout if the context has no deadline
+	Prefix      string        // prefix of the counter keys
+}
+
+// Store is a ratelimit.Store on a server speaking the redis protocol, e.g. redis, valkey or keydb.
+type Store struct {
+	conf Config
+	pool chan *conn
+}
+
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+// ErrorReply is an error returned by the server.
+type ErrorReply string
+
+func (e ErrorReply) Error() string {
+	return string(e)
+}
+
+func New(c Config) *Store {
+	if c.PoolSize <= 0 {
+		c.PoolSize = _defaultPoolSize
+	}
+
+	if c.DialTimeout <= 0 {
+		c.DialTimeout = _defaultTimeout
+	}
+
+	if c.Prefix == "" {
+		c.Prefix = _defaultPrefix
+	}
+
+	return &Store{
+		conf: c,
+		pool: make(chan *conn, c.PoolSize),
+	}
+}
+
+// Incr adds n to the counter of key by a script, a counter without ttl is set to expire after ttl.
+func (s *Store) Incr(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	reply, err := s.do(ctx, "EVAL", incrScript, "1", s.conf.Prefix+key, strconv.FormatInt(n, 10), strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return 0, err
+	}
+
+	value, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected EVAL reply %v", reply)
+	}
+
+	return value, nil
+}
+
+func (s *Store) Get(ctx context.Context, key string) (int64, error) {
+	reply, err := s.do(ctx, "GET", s.conf.Prefix+key)
+	if errors.Is(err, NilReplyErr) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	value, ok := reply.(string)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected GET reply %v", reply)
+	}
+
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// Close closes the idle connections.
+func (s *Store) Close() error {
+	for {
+		select {
+		case c := <-s.pool:
+			c.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// do sends the command and returns the reply, an int64, a string or an []interface{}.
+func (s *Store) do(ctx context.Context, args ...string) (interface{}, error) {
+	c, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := c.do(ctx, s.conf.DialTimeout, args...)
+	s.put(c, err)
+
+	return reply, err
+}
+
+func (s *Store) get(ctx context.Context) (*conn, error) {
+	select {
+	case c := <-s.pool:
+		return c, nil
+	default:
+	}
+
+	dialer := net.Dialer{Timeout: s.conf.DialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", s.conf.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &conn{
+		Conn:   netConn,
+		reader: bufio.NewReader(netConn),
+		writer: bufio.NewWriter(netConn),
+	}
+
+	if s.conf.Password != "" {
+		if _, err := c.do(ctx, s.conf.DialTimeout, "AUTH", s.conf.Password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	if s.conf.DB != 0 {
+		if _, err := c.do(ctx, s.conf.DialTimeout, "SELECT", strconv.Itoa(s.conf.DB)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// put returns the connection to the pool unless it is broken or the pool is full.
+func (s *Store) put(c *conn, err error) {
+	var reply ErrorReply
+	if err != nil && !errors.Is(err, NilReplyErr) && !errors.As(err, &reply) {
+		c.Close()
+		return
+	}
+
+	select {
+	case s.pool <- c:
+	default:
+		c.Close()
+	}
+}
+
+func (c *conn) do(ctx context.Context, timeout time.Duration, args ...string) (interface{}, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(timeout)
+	}
+	c.SetDeadline(deadline)
+
+	// commands are sent as an array of bulk strings
+	fmt.Fprintf(c.writer, "*%d\r\n", len(args))
+	for _, v := range args {
+		fmt.Fprintf(c.writer, "$%d\r\n%s\r\n", len(v), v)
+	}
+
+	if err := c.writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	return c.read()
+}
+
+func (c *conn) read() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: invalid reply %q", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, ErrorReply(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, NilReplyErr
+		}
+
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, NilReplyErr
+		}
+
+		values := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			value, err := c.read()
+
+			var reply ErrorReply
+			if errors.As(err, &reply) {
+				value = reply
+			} else if err != nil && !errors.Is(err, NilReplyErr) {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("redis: invalid reply %q", line)
+	}
+}
@@ -0,0 +1,240 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeServer speaks the redis protocol for the commands used by the store.
+type fakeServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	values   map[string]int64
+	ttls     map[string]int64
+	password string
+	conns    int
+}
+
+func newFakeServer(t *testing.T, password string) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeServer{
+		listener: listener,
+		values:   make(map[string]int64),
+		ttls:     make(map[string]int64),
+		password: password,
+	}
+	go s.serve()
+	t.Cleanup(func() { listener.Close() })
+
+	return s
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns++
+		s.mu.Unlock()
+
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	authed := s.password == ""
+
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		reply := s.exec(args, &authed)
+		s.mu.Unlock()
+
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeServer) exec(args []string, authed *bool) string {
+	cmd := strings.ToUpper(args[0])
+	if cmd == "AUTH" {
+		if args[1] != s.password {
+			return "-WRONGPASS invalid password\r\n"
+		}
+		*authed = true
+		return "+OK\r\n"
+	}
+
+	if !*authed {
+		return "-NOAUTH Authentication required.\r\n"
+	}
+
+	switch cmd {
+	case "SELECT":
+		return "+OK\r\n"
+	case "EVAL":
+		// only the incr script is run: EVAL script 1 key n ttl
+		if args[1] != incrScript || args[2] != "1" {
+			return "-ERR unknown script\r\n"
+		}
+
+		key := args[3]
+		n, err := strconv.ParseInt(args[4], 10, 64)
+		if err != nil {
+			return "-ERR value is not an integer\r\n"
+		}
+		s.values[key] += n
+
+		if _, ok := s.ttls[key]; !ok {
+			ttl, _ := strconv.ParseInt(args[5], 10, 64)
+			s.ttls[key] = ttl
+		}
+		return fmt.Sprintf(":%d\r\n", s.values[key])
+	case "GET":
+		value, ok := s.values[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		v := strconv.FormatInt(value, 10)
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	default:
+		return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+	}
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		// the bulk strings may span lines, e.g. a script
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, arg); err != nil {
+			return nil, err
+		}
+		args = append(args, string(arg[:size]))
+	}
+
+	return args, nil
+}
+
+func TestIncr(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newFakeServer(t, "secret")
+	store := New(Config{Addr: server.listener.Addr().String(), Password: "secret", DB: 1})
+	defer store.Close()
+
+	ctx := context.Background()
+
+	value, err := store.Incr(ctx, "ip:1", 3, 2*time.Second)
+	assert.Nil(err)
+	assert.Equal(int64(3), value)
+
+	value, err = store.Incr(ctx, "ip:1", 2, 2*time.Second)
+	assert.Nil(err)
+	assert.Equal(int64(5), value)
+
+	value, err = store.Get(ctx, "ip:1")
+	assert.Nil(err)
+	assert.Equal(int64(5), value)
+
+	value, err = store.Get(ctx, "ip:2")
+	assert.Nil(err)
+	assert.Equal(int64(0), value)
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	// the ttl is only set on the new counter, the connection is reused
+	assert.Equal(map[string]int64{"tinykit:ratelimit:ip:1": 2000}, server.ttls)
+	assert.Equal(1, server.conns)
+}
+
+func TestIncrWithoutTTL(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newFakeServer(t, "")
+	store := New(Config{Addr: server.listener.Addr().String()})
+	defer store.Close()
+
+	// a counter left without ttl gets one by the next increment
+	server.mu.Lock()
+	server.values["tinykit:ratelimit:ip:1"] = 7
+	server.mu.Unlock()
+
+	value, err := store.Incr(context.Background(), "ip:1", 1, time.Second)
+	assert.Nil(err)
+	assert.Equal(int64(8), value)
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	assert.Equal(int64(1000), server.ttls["tinykit:ratelimit:ip:1"])
+}
+
+func TestAuthError(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newFakeServer(t, "secret")
+	store := New(Config{Addr: server.listener.Addr().String(), Password: "wrong"})
+
+	_, err := store.Incr(context.Background(), "ip:1", 1, time.Second)
+	assert.Equal(ErrorReply("WRONGPASS invalid password"), err)
+}
+
+func TestUnreachable(t *testing.T) {
+	assert := assert.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	store := New(Config{Addr: addr, DialTimeout: 100 * time.Millisecond})
+	_, err = store.Incr(context.Background(), "ip:1", 1, time.Second)
+	assert.NotNil(err)
+}
@@ -0,0 +1,292 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/KKKKjl/tinykit/logger"
+)
+
+var (
+	log     = logger.GetLogger()
+	mainLog = log.WithField("prefix", "ratelimit")
+)
+
+// entry is the local view of the shared counters of a key.
+type entry struct {
+	mu       sync.Mutex
+	start    time.Time // start of the current window
+	global   int64     // count of the current window in the store at the last sync
+	flushing int64     // local requests being flushed to the store
+	pending  int64     // local requests not flushed to the store yet
+	stale    []flush   // requests of the finished windows not flushed yet
+	prev     int64     // count of the previous window, -1 if not fetched yet
+	syncing  bool      // a request is syncing with the store
+	synced   time.Time // last successful sync
+	retry    time.Time // no sync before retry after the store failed
+	err      error     // last store error
+	seen     time.Time // last request, used to evict idle keys
+}
+
+// flush is the requests of a window to add to the store.
+type flush struct {
+	start time.Time
+	n     int64
+}
+
+// syncJob is the work of a sync, taken from the entry so that the store is called without holding its lock.
+type syncJob struct {
+	start     time.Time
+	n         int64
+	stale     []flush
+	fetchPrev bool
+}
+
+// storeLimiter counts the requests of a window in a shared store. The requests are counted locally
+// and flushed in batches, so the limit may be exceeded by the unflushed requests of each instance.
+type storeLimiter struct {
+	policy       Policy
+	store        Store
+	scope        string
+	batchSize    int64
+	syncInterval time.Duration
+	failOpen     bool
+
+	mu          sync.Mutex
+	entries     map[string]*entry
+	idleTimeout time.Duration
+	lastSweep   time.Time
+	now         func() time.Time
+}
+
+func newStoreLimiter(policy Policy, o options) (*storeLimiter, error) {
+	if policy.Algorithm == TokenBucket {
+		return nil, TokenBucketErr
+	}
+
+	return &storeLimiter{
+		policy:       policy,
+		store:        o.store,
+		scope:        o.scope,
+		batchSize:    int64(o.batchSize),
+		syncInterval: o.syncInterval,
+		failOpen:     o.failOpen,
+		entries:      make(map[string]*entry),
+		idleTimeout:  o.idleTimeout,
+		lastSweep:    o.now(),
+		now:          o.now,
+	}, nil
+}
+
+// Allow decides by the local view of the key, the store is only called by the request syncing the view,
+// the concurrent requests do not wait for it.
+func (l *storeLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	now := l.now()
+	e := l.entry(key, now)
+
+	e.mu.Lock()
+	if start := now.Truncate(l.policy.Window); !start.Equal(e.start) {
+		l.roll(e, start)
+	}
+	job := l.beginSync(e, now)
+	e.mu.Unlock()
+
+	if job != nil {
+		l.sync(ctx, key, e, job)
+	}
+
+	e.mu.Lock()
+
+	if e.err != nil && !l.failOpen {
+		e.mu.Unlock()
+		return Result{}, fmt.Errorf("rate limit store unavailable: %w", e.err)
+	}
+
+	prev := e.prev
+	if prev < 0 {
+		prev = 0
+	}
+
+	s := &state{
+		start: e.start,
+		count: int(e.global + e.flushing + e.pending),
+		prev:  int(prev),
+	}
+
+	res := l.policy.take(s, now)
+
+	job = nil
+	if res.Allowed {
+		e.pending++
+
+		if e.pending >= l.batchSize {
+			job = l.beginSync(e, now)
+		}
+	}
+	e.mu.Unlock()
+
+	if job != nil {
+		l.sync(ctx, key, e, job)
+	}
+
+	return res, nil
+}
+
+// beginSync takes the requests to flush if the entry is due to sync, no other request is syncing it and
+// the store did not fail within the last sync interval, mu must be held.
+func (l *storeLimiter) beginSync(e *entry, now time.Time) *syncJob {
+	if e.syncing || now.Before(e.retry) {
+		return nil
+	}
+
+	if e.pending < l.batchSize && now.Sub(e.synced) < l.syncInterval && len(e.stale) == 0 {
+		return nil
+	}
+
+	job := &syncJob{
+		start:     e.start,
+		n:         e.pending,
+		stale:     e.stale,
+		fetchPrev: l.policy.Algorithm == SlidingWindow && e.prev < 0,
+	}
+
+	e.syncing = true
+	e.flushing = e.pending
+	e.pending = 0
+	e.stale = nil
+
+	return job
+}
+
+// sync flushes the requests of the job and fetches the count of all instances without holding the lock
+// of the entry. The requests not flushed are kept to be flushed by the next sync.
+func (l *storeLimiter) sync(ctx context.Context, key string, e *entry, job *syncJob) {
+	var (
+		total   int64
+		prev    int64 = -1
+		flushed bool
+		err     error
+	)
+
+	stale := job.stale
+	for len(stale) > 0 {
+		if _, err = l.store.Incr(ctx, l.storeKey(key, stale[0].start), stale[0].n, l.ttl()); err != nil {
+			break
+		}
+		stale = stale[1:]
+	}
+
+	if err == nil {
+		total, err = l.store.Incr(ctx, l.storeKey(key, job.start), job.n, l.ttl())
+		flushed = err == nil
+	}
+
+	if flushed && job.fetchPrev {
+		prev, err = l.store.Get(ctx, l.storeKey(key, job.start.Add(-l.policy.Window)))
+	}
+
+	now := l.now()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.syncing = false
+	e.stale = append(stale, e.stale...)
+
+	if job.start.Equal(e.start) {
+		if flushed {
+			e.global = total
+		} else {
+			e.pending += e.flushing
+		}
+		e.flushing = 0
+
+		if prev >= 0 {
+			e.prev = prev
+		}
+	} else if !flushed && job.n > 0 {
+		// the window rolled while syncing
+		e.stale = append(e.stale, flush{start: job.start, n: job.n})
+	}
+
+	if err != nil {
+		e.err = err
+		e.retry = now.Add(l.syncInterval)
+		mainLog.Errorf("Sync rate limit of %s error: %v", key, err)
+		return
+	}
+
+	e.err = nil
+	if job.start.Equal(e.start) {
+		e.synced = now
+	}
+}
+
+func (l *storeLimiter) entry(key string, now time.Time) *entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	e, ok := l.entries[key]
+	if !ok {
+		e = &entry{prev: -1}
+		l.entries[key] = e
+	}
+	e.seen = now
+
+	return e
+}
+
+// roll starts the new window, the pending requests of the finished window are flushed by the next sync
+// unless their counter has expired, mu must be held.
+func (l *storeLimiter) roll(e *entry, start time.Time) {
+	if e.pending > 0 {
+		e.stale = append(e.stale, flush{start: e.start, n: e.pending})
+	}
+
+	stale := e.stale[:0]
+	for _, v := range e.stale {
+		if start.Sub(v.start) < l.ttl() {
+			stale = append(stale, v)
+		}
+	}
+
+	e.stale = stale
+	e.start = start
+	e.global = 0
+	e.flushing = 0
+	e.pending = 0
+	e.prev = -1
+	e.synced = time.Time{}
+}
+
+// storeKey returns the counter key of the window starting at start.
+func (l *storeLimiter) storeKey(key string, start time.Time) string {
+	return fmt.Sprintf("%s:%s:%d", l.scope, key, start.UnixMilli())
+}
+
+// ttl keeps the counter of a window while it is used, sliding window reads it in the next window.
+func (l *storeLimiter) ttl() time.Duration {
+	if l.policy.Algorithm == SlidingWindow {
+		return 2*l.policy.Window + time.Second
+	}
+
+	return l.policy.Window + time.Second
+}
+
+// sweep evicts the idle keys at most once per idle timeout.
+func (l *storeLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.idleTimeout {
+		return
+	}
+	l.lastSweep = now
+
+	for k, v := range l.entries {
+		if now.Sub(v.seen) >= l.idleTimeout {
+			delete(l.entries, k)
+		}
+	}
+}
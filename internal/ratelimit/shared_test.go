@@ -0,0 +1,205 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingStore counts the calls to the store and fails them if down is set.
+type countingStore struct {
+	*MemoryStore
+	calls int
+	down  bool
+}
+
+func (s *countingStore) Incr(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	s.calls++
+	if s.down {
+		return 0, errors.New("connection refused")
+	}
+	return s.MemoryStore.Incr(ctx, key, n, ttl)
+}
+
+func (s *countingStore) Get(ctx context.Context, key string) (int64, error) {
+	s.calls++
+	if s.down {
+		return 0, errors.New("connection refused")
+	}
+	return s.MemoryStore.Get(ctx, key)
+}
+
+func newStore(c *clock) *countingStore {
+	store := NewMemoryStore()
+	store.now = c.Now
+	return &countingStore{MemoryStore: store}
+}
+
+func TestSharedLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &clock{now: time.Unix(1000, 0)}
+	store := newStore(c)
+
+	policy := Policy{Algorithm: FixedWindow, Limit: 4, Window: time.Second}
+	opts := []Option{WithClock(c.Now), WithStore(store, "route"), WithBatch(1, 0)}
+
+	// two gateway instances share the limit
+	a, err := New(policy, opts...)
+	assert.Nil(err)
+	b, err := New(policy, opts...)
+	assert.Nil(err)
+
+	allowedA, _ := allow(a, "ip:1", 3)
+	allowedB, res := allow(b, "ip:1", 3)
+	assert.Equal(3, allowedA)
+	assert.Equal(1, allowedB)
+	assert.Equal(time.Second, res.RetryAfter)
+
+	c.Add(time.Second)
+	allowedB, _ = allow(b, "ip:1", 1)
+	assert.Equal(1, allowedB)
+
+	_, err = New(Policy{Limit: 1, Window: time.Second}, opts...)
+	assert.Equal(TokenBucketErr, err)
+}
+
+func TestSharedSlidingWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &clock{now: time.Unix(1000, 0)}
+	store := newStore(c)
+
+	policy := Policy{Algorithm: SlidingWindow, Limit: 4, Window: time.Second}
+	opts := []Option{WithClock(c.Now), WithStore(store, "route"), WithBatch(1, 0)}
+
+	a, _ := New(policy, opts...)
+	b, _ := New(policy, opts...)
+
+	allow(a, "ip:1", 4)
+
+	// the previous window of the other instance still weights 3 requests
+	c.Add(1250 * time.Millisecond)
+	allowed, _ := allow(b, "ip:1", 2)
+	assert.Equal(1, allowed)
+}
+
+func TestBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &clock{now: time.Unix(1000, 0)}
+	store := newStore(c)
+
+	l, _ := New(Policy{Algorithm: FixedWindow, Limit: 100, Window: time.Minute}, WithClock(c.Now), WithStore(store, "route"), WithBatch(10, time.Second))
+
+	// the first request fetches the count, then every 10 requests are flushed
+	allow(l, "ip:1", 21)
+	assert.Equal(3, store.calls)
+
+	value, _ := store.MemoryStore.Get(context.Background(), "route:ip:1:960000")
+	assert.Equal(int64(20), value)
+
+	// flushed every interval
+	c.Add(time.Second)
+	allow(l, "ip:1", 1)
+	assert.Equal(4, store.calls)
+
+	value, _ = store.MemoryStore.Get(context.Background(), "route:ip:1:960000")
+	assert.Equal(int64(21), value)
+}
+
+func TestFailOpen(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &clock{now: time.Unix(1000, 0)}
+	store := newStore(c)
+	store.down = true
+
+	policy := Policy{Algorithm: FixedWindow, Limit: 2, Window: time.Second}
+
+	// limited by the local counters
+	l, _ := New(policy, WithClock(c.Now), WithStore(store, "route"), WithBatch(1, 0))
+	allowed, _ := allow(l, "ip:1", 3)
+	assert.Equal(2, allowed)
+
+	l, _ = New(policy, WithClock(c.Now), WithStore(store, "route"), WithBatch(1, 0), WithFailOpen(false))
+	_, err := l.Allow(context.Background(), "ip:1")
+	assert.NotNil(err)
+
+	// recovered after the store is back
+	store.down = false
+	c.Add(time.Millisecond)
+	res, err := l.Allow(context.Background(), "ip:1")
+	assert.Nil(err)
+	assert.True(res.Allowed)
+}
+
+func TestFlushRetry(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &clock{now: time.Unix(1000, 0)}
+	store := newStore(c)
+
+	l, _ := New(Policy{Algorithm: FixedWindow, Limit: 100, Window: time.Second}, WithClock(c.Now), WithStore(store, "route"), WithBatch(10, 100*time.Millisecond))
+	allow(l, "ip:1", 3)
+
+	// the requests of the finished window are kept while the store is down
+	c.Add(time.Second)
+	store.down = true
+	allow(l, "ip:1", 1)
+
+	store.down = false
+	c.Add(200 * time.Millisecond)
+	allow(l, "ip:1", 1)
+
+	value, _ := store.MemoryStore.Get(context.Background(), "route:ip:1:1000000")
+	assert.Equal(int64(3), value)
+
+	value, _ = store.MemoryStore.Get(context.Background(), "route:ip:1:1001000")
+	assert.Equal(int64(1), value)
+}
+
+// blockingStore blocks the calls until released.
+type blockingStore struct {
+	*MemoryStore
+	release chan struct{}
+}
+
+func (s *blockingStore) Incr(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	<-s.release
+	return s.MemoryStore.Incr(ctx, key, n, ttl)
+}
+
+func TestSlowStore(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &clock{now: time.Unix(1000, 0)}
+	store := &blockingStore{MemoryStore: NewMemoryStore(), release: make(chan struct{})}
+
+	l, _ := New(Policy{Algorithm: FixedWindow, Limit: 4, Window: time.Second}, WithClock(c.Now), WithStore(store, "route"), WithBatch(1, 0))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.Allow(context.Background(), "ip:1")
+	}()
+
+	// the other requests of the key are decided locally while the store is synced
+	assert.Eventually(func() bool {
+		res, err := l.Allow(context.Background(), "ip:1")
+		return err == nil && !res.Allowed
+	}, time.Second, time.Millisecond)
+
+	close(store.release)
+	<-done
+
+	// flushed by the next sync
+	res, _ := l.Allow(context.Background(), "ip:1")
+	assert.False(res.Allowed)
+
+	value, _ := store.MemoryStore.Get(context.Background(), "route:ip:1:1000000")
+	assert.Equal(int64(4), value)
+}
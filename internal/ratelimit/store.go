@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const _sweepInterval = time.Minute
+
+// Store keeps the window counters shared by the gateway instances.
+type Store interface {
+	// Incr adds n to the counter of key and returns the new value, a new counter expires after ttl.
+	Incr(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error)
+	// Get returns the value of the counter of key, 0 if it does not exist.
+	Get(ctx context.Context, key string) (int64, error)
+}
+
+var (
+	storeMu     sync.RWMutex
+	sharedStore Store = NewMemoryStore()
+)
+
+// SetSharedStore sets the store used by the shared rate limit filters, e.g. a redis store.
+// It must be set before the filters are created.
+func SetSharedStore(store Store) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	sharedStore = store
+}
+
+// SharedStore returns the store shared by the rate limit filters, default an in-memory store of this instance.
+func SharedStore() Store {
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+
+	return sharedStore
+}
+
+type counter struct {
+	value  int64
+	expire time.Time
+}
+
+// MemoryStore is a Store in memory, the counters are only shared in this instance.
+type MemoryStore struct {
+	mu        sync.Mutex
+	counters  map[string]*counter
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		counters:  make(map[string]*counter),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+func (m *MemoryStore) Incr(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	now := m.now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sweep(now)
+
+	c, ok := m.counters[key]
+	if !ok || !now.Before(c.expire) {
+		c = &counter{expire: now.Add(ttl)}
+		m.counters[key] = c
+	}
+	c.value += n
+
+	return c.value, nil
+}
+
+func (m *MemoryStore) Get(ctx context.Context, key string) (int64, error) {
+	now := m.now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.counters[key]
+	if !ok || !now.Before(c.expire) {
+		return 0, nil
+	}
+
+	return c.value, nil
+}
+
+// sweep deletes the expired counters at most once per sweep interval.
+func (m *MemoryStore) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < _sweepInterval {
+		return
+	}
+	m.lastSweep = now
+
+	for k, v := range m.counters {
+		if !now.Before(v.expire) {
+			delete(m.counters, k)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package balance
 
 import (
 	"errors"
+	"time"
 
 	"github.com/KKKKjl/tinykit/internal/registry"
 )
@@ -9,13 +10,19 @@ import (
 type BalanceType string
 
 const (
-	WEIGHT_ROUND_ROBIN BalanceType = "weight_round_robin"
-	ROUND_ROBIN                    = "round_robin"
-	CONSISTENT_HASHING             = "consistent_hashing"
+	WEIGHT_ROUND_ROBIN         BalanceType = "weight_round_robin"
+	ROUND_ROBIN                            = "round_robin"
+	CONSISTENT_HASHING                     = "consistent_hashing"
+	BOUNDED_CONSISTENT_HASHING             = "bounded_consistent_hashing"
+	MAGLEV                                 = "maglev"
+	LEAST_REQUEST                          = "least_request"
+	P2C                                    = "p2c"
+	PEAK_EWMA                              = "peak_ewma"
 )
 
 var (
-	balancer = make(map[BalanceType]Picker)
+	// factories of the balance types, every proxy gets its own picker
+	balancer = make(map[BalanceType]func() Picker)
 )
 
 var (
@@ -30,11 +37,33 @@ type Picker interface {
 	Scheme() string
 }
 
+// DoneInfo is the result of a call to a picked service.
+type DoneInfo struct {
+	Err     error
+	Latency time.Duration // until the first response
+}
+
+// Tracker is implemented by the pickers tracking the calls to the picked services,
+// Done is called once when a call to the service returned by Pick completes.
+type Tracker interface {
+	Done(service *registry.Service, info DoneInfo)
+}
+
+// IsSupported reports whether the balance type is registered, empty type means the default one.
+func IsSupported(balanceType BalanceType) bool {
+	if balanceType == "" {
+		return true
+	}
+
+	_, ok := balancer[balanceType]
+	return ok
+}
+
 // create balance instance.
 // If balance type is not support, it will return default type(round robin).
 func NewBalancer(balanceType BalanceType) Picker {
-	if balance, ok := balancer[balanceType]; ok {
-		return balance
+	if factory, ok := balancer[balanceType]; ok {
+		return factory()
 	}
 
 	return NewRoundRobin()
@@ -1,84 +1,226 @@
 package balance
 
 import (
-	"hash/crc32"
+	"hash/fnv"
+	"math"
 	"sort"
 	"strconv"
+	"sync"
 
 	"github.com/KKKKjl/tinykit/internal/registry"
 )
 
+const (
+	_defaultReplicas   = 100
+	_defaultLoadFactor = 1.25
+)
+
 // Hash maps bytes to uint32
 type Hash func(data []byte) uint32
 
-type Map struct {
-	hash     Hash
-	replicas int
-	keys     []int // Sorted
-	hashMap  map[int]string
-}
+type (
+	// member is a service the ring or table was built for.
+	member struct {
+		addr   string
+		weight int
+	}
+
+	node struct {
+		hash  uint32
+		index int // index of the service
+	}
+
+	// Map is a consistent hash ring rebuilt when the picked services change, every service has
+	// replicas virtual nodes per weight. With a load factor the ring is bounded, a service takes at most
+	// load factor times the average outstanding requests, the others are passed to the next nodes.
+	Map struct {
+		mu         sync.Mutex
+		hash       Hash
+		replicas   int
+		loadFactor float64
+		members    []member
+		nodes      []node // sorted by hash
+		inflight   map[string]int
+	}
+)
 
 func init() {
-	balancer[CONSISTENT_HASHING] = NewConsistentHashing(10, nil)
+	balancer[CONSISTENT_HASHING] = func() Picker { return NewConsistentHashing(_defaultReplicas, nil) }
+	balancer[BOUNDED_CONSISTENT_HASHING] = func() Picker { return NewBoundedConsistentHashing(_defaultReplicas, _defaultLoadFactor, nil) }
 }
 
 func NewConsistentHashing(replicas int, fn Hash) *Map {
+	if replicas <= 0 {
+		replicas = _defaultReplicas
+	}
+
 	m := &Map{
 		replicas: replicas,
 		hash:     fn,
-		hashMap:  make(map[int]string),
+		inflight: make(map[string]int),
 	}
 	if m.hash == nil {
-		m.hash = crc32.ChecksumIEEE
+		m.hash = func(data []byte) uint32 {
+			return uint32(hash64(data))
+		}
 	}
 	return m
 }
 
-func (m *Map) Add(service ...*registry.Service) {
-	for _, v := range service {
-		if v == nil || len(v.Addr) == 0 {
-			continue
-		}
-
-		for i := 0; i < m.replicas; i++ {
-			hash := int(m.hash([]byte(strconv.Itoa(i) + v.Addr)))
-			m.keys = append(m.keys, hash)
-			m.hashMap[hash] = v.Addr
-		}
+// NewBoundedConsistentHashing creates a ring with bounded loads, loadFactor must be greater than 1.
+func NewBoundedConsistentHashing(replicas int, loadFactor float64, fn Hash) *Map {
+	if loadFactor <= 1 {
+		loadFactor = _defaultLoadFactor
 	}
 
-	sort.Ints(m.keys)
+	m := NewConsistentHashing(replicas, fn)
+	m.loadFactor = loadFactor
+	return m
 }
 
-func (m *Map) Remove(server *registry.Service) {
-	for i := 0; i < m.replicas; i++ {
-		hash := int(m.hash([]byte(strconv.Itoa(i) + server.Addr)))
+func (m *Map) Pick(key string, services []*registry.Service) (*registry.Service, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-		if _, ok := m.hashMap[hash]; ok {
-			delete(m.hashMap, hash)
-		}
+	if !sameMembers(m.members, services) {
+		m.build(services)
 	}
-}
 
-func (m *Map) Pick(key string, services []*registry.Service) (*registry.Service, error) {
-	if len(m.keys) == 0 {
+	if len(m.nodes) == 0 {
 		return nil, EmptyServiceErr
 	}
 
-	hash := int(m.hash([]byte(key)))
+	hash := m.hash([]byte(key))
 
 	// Binary search for appropriate replica.
-	idx := sort.Search(len(m.keys), func(i int) bool {
-		return m.keys[i] >= hash
+	idx := sort.Search(len(m.nodes), func(i int) bool {
+		return m.nodes[i].hash >= hash
 	})
 
-	service := registry.Service{
-		Addr: m.hashMap[m.keys[idx%len(m.keys)]],
+	service := services[m.nodes[idx%len(m.nodes)].index]
+	if m.loadFactor > 0 {
+		service = m.bounded(idx, services)
+		m.inflight[service.Addr]++
+	}
+
+	return service, nil
+}
+
+// bounded walks the ring from idx to the first service below the capacity.
+func (m *Map) bounded(idx int, services []*registry.Service) *registry.Service {
+	var total int
+	for _, v := range m.members {
+		total += m.inflight[v.addr]
+	}
+
+	capacity := int(math.Ceil(m.loadFactor * float64(total+1) / float64(len(m.members))))
+
+	for i := 0; i < len(m.nodes); i++ {
+		service := services[m.nodes[(idx+i)%len(m.nodes)].index]
+		if m.inflight[service.Addr] < capacity {
+			return service
+		}
 	}
 
-	return &service, nil
+	// unreachable as the capacity exceeds the average load
+	return services[m.nodes[idx%len(m.nodes)].index]
+}
+
+func (m *Map) Done(service *registry.Service, info DoneInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.inflight[service.Addr] <= 1 {
+		delete(m.inflight, service.Addr)
+		return
+	}
+
+	m.inflight[service.Addr]--
+}
+
+func (m *Map) build(services []*registry.Service) {
+	m.members = members(services)
+	m.nodes = m.nodes[:0]
+
+	for i, v := range services {
+		if v == nil || len(v.Addr) == 0 {
+			continue
+		}
+
+		for j := 0; j < m.replicas*weight(v); j++ {
+			m.nodes = append(m.nodes, node{
+				hash:  m.hash([]byte(strconv.Itoa(j) + v.Addr)),
+				index: i,
+			})
+		}
+	}
+
+	sort.Slice(m.nodes, func(i, j int) bool {
+		return m.nodes[i].hash < m.nodes[j].hash
+	})
 }
 
 func (m *Map) Scheme() string {
+	if m.loadFactor > 0 {
+		return "bounded_consistent_hashing"
+	}
+
 	return "consistent_hashing"
 }
+
+// hash64 is fnv-1a finalized by murmur3, the virtual nodes of similar addrs spread evenly.
+func hash64(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+
+	return x
+}
+
+// weight returns the weight of the service, at least 1.
+func weight(service *registry.Service) int {
+	if service.Weight <= 0 {
+		return 1
+	}
+
+	return service.Weight
+}
+
+func members(services []*registry.Service) []member {
+	members := make([]member, len(services))
+	for i, v := range services {
+		if v != nil {
+			members[i] = member{addr: v.Addr, weight: weight(v)}
+		}
+	}
+
+	return members
+}
+
+// sameMembers reports whether the services are the members in the same order.
+func sameMembers(members []member, services []*registry.Service) bool {
+	if len(members) != len(services) {
+		return false
+	}
+
+	for i, v := range services {
+		if v == nil {
+			if members[i] != (member{}) {
+				return false
+			}
+			continue
+		}
+
+		if members[i].addr != v.Addr || members[i].weight != weight(v) {
+			return false
+		}
+	}
+
+	return true
+}
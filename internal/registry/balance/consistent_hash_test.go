@@ -1,65 +1,165 @@
 package balance
 
-// func TestConsistentHashing(t *testing.T) {
-// 	hash := NewConsistentHashing(10, nil)
-
-// 	services := []*registry.Service{
-// 		{
-// 			Name:     "host1",
-// 			Version:  "v1",
-// 			Addr:     "localhost:8080",
-// 			Metadata: make(map[string]string),
-// 			Weight:   3,
-// 		},
-// 		{
-// 			Name:     "host2",
-// 			Version:  "v1",
-// 			Addr:     "localhost:8081",
-// 			Metadata: make(map[string]string),
-// 			Weight:   2,
-// 		},
-// 		{
-// 			Name:     "host3",
-// 			Version:  "v1",
-// 			Addr:     "localhost:8082",
-// 			Metadata: make(map[string]string),
-// 			Weight:   1,
-// 		},
-// 	}
-
-// 	hash.Add(services...)
-
-// 	testCases := map[string]string{
-// 		"host1": "localhost:8080",
-// 		"host2": "localhost:8081",
-// 		"host3": "localhost:8082",
-// 	}
-
-// 	for k, v := range testCases {
-// 		if res, _ := hash.Pick(k); res.Addr != v {
-// 			t.Errorf("Asking for %s, get %s", v, res.Addr)
-// 		}
-// 	}
-
-// 	hash.Add(&registry.Service{
-// 		Name: "host4",
-// 		Addr: "localhost:8083",
-// 	})
-
-// 	for k, v := range testCases {
-// 		if res, _ := hash.Pick(k); res.Addr != v {
-// 			t.Errorf("Asking for %s, get %s", v, res.Addr)
-// 		}
-// 	}
-
-// 	hash.Remove(&registry.Service{
-// 		Name: "host1",
-// 		Addr: "localhost:8080",
-// 	})
-
-// 	for k, v := range testCases {
-// 		if res, _ := hash.Pick(k); res.Addr != v {
-// 			t.Errorf("Asking for %s, get %s", v, res.Addr)
-// 		}
-// 	}
-// }
+import (
+	"fmt"
+	"testing"
+
+	"github.com/KKKKjl/tinykit/internal/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func newServices(n int) []*registry.Service {
+	services := make([]*registry.Service, 0, n)
+	for i := 0; i < n; i++ {
+		services = append(services, &registry.Service{
+			Addr:   fmt.Sprintf("localhost:%d", 8080+i),
+			Weight: 1,
+		})
+	}
+
+	return services
+}
+
+// pickAll picks a service for each of n keys.
+func pickAll(t *testing.T, picker Picker, services []*registry.Service, n int) map[string]string {
+	picked := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("10.0.0.%d", i)
+
+		service, err := picker.Pick(key, services)
+		if err != nil {
+			t.Fatal(err)
+		}
+		picked[key] = service.Addr
+	}
+
+	return picked
+}
+
+// moved counts the keys picking another service.
+func moved(a, b map[string]string) int {
+	var n int
+	for k, v := range a {
+		if b[k] != v {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestConsistentHashing(t *testing.T) {
+	assert := assert.New(t)
+
+	hash := NewConsistentHashing(100, nil)
+	services := newServices(4)
+
+	before := pickAll(t, hash, services, 1000)
+	assert.Equal(before, pickAll(t, hash, services, 1000))
+
+	// the picked services are the discovered ones
+	service, _ := hash.Pick("10.0.0.1", services)
+	assert.Contains(services, service)
+
+	// only the keys of the removed service move
+	after := pickAll(t, hash, services[:3], 1000)
+	for k, v := range before {
+		if v != services[3].Addr {
+			assert.Equal(v, after[k])
+		}
+	}
+
+	// keys move to the added service only
+	added := pickAll(t, hash, newServices(5), 1000)
+	for k, v := range added {
+		if v != "localhost:8084" {
+			assert.Equal(before[k], v)
+		}
+	}
+
+	_, err := hash.Pick("10.0.0.1", nil)
+	assert.Equal(EmptyServiceErr, err)
+}
+
+func TestConsistentHashingWeight(t *testing.T) {
+	assert := assert.New(t)
+
+	services := newServices(2)
+	services[1].Weight = 3
+
+	counts := make(map[string]int)
+	for _, v := range pickAll(t, NewConsistentHashing(100, nil), services, 4000) {
+		counts[v]++
+	}
+
+	assert.InDelta(3000, counts["localhost:8081"], 300)
+}
+
+func TestBoundedConsistentHashing(t *testing.T) {
+	assert := assert.New(t)
+
+	hash := NewBoundedConsistentHashing(100, 1.25, nil)
+	services := newServices(4)
+
+	// the same key spills over to the other services once its service is full
+	picked := make(map[string]int)
+	for i := 0; i < 20; i++ {
+		service, err := hash.Pick("10.0.0.1", services)
+		assert.Nil(err)
+		picked[service.Addr]++
+	}
+
+	assert.Len(picked, 4)
+	for _, v := range picked {
+		assert.LessOrEqual(v, 7)
+	}
+
+	for addr, n := range picked {
+		for i := 0; i < n; i++ {
+			hash.Done(&registry.Service{Addr: addr}, DoneInfo{})
+		}
+	}
+	assert.Empty(hash.inflight)
+
+	// without load the key sticks to its service
+	a, _ := hash.Pick("10.0.0.1", services)
+	hash.Done(a, DoneInfo{})
+	b, _ := hash.Pick("10.0.0.1", services)
+	assert.Equal(a, b)
+}
+
+func TestMaglev(t *testing.T) {
+	assert := assert.New(t)
+
+	maglev := NewMaglev(65537)
+	services := newServices(5)
+
+	before := pickAll(t, maglev, services, 2000)
+	assert.Equal(before, pickAll(t, maglev, services, 2000))
+
+	counts := make(map[string]int)
+	for _, v := range before {
+		counts[v]++
+	}
+	for _, v := range counts {
+		assert.InDelta(400, v, 100)
+	}
+
+	// removing a service moves its keys and few others
+	after := pickAll(t, maglev, services[:4], 2000)
+	assert.Less(moved(before, after), counts[services[4].Addr]+100)
+
+	// weights
+	services = newServices(2)
+	services[0].Weight = 2
+
+	counts = make(map[string]int)
+	for _, v := range pickAll(t, maglev, services, 3000) {
+		counts[v]++
+	}
+	assert.InDelta(2000, counts["localhost:8080"], 200)
+	assert.Equal(65537, len(maglev.table))
+
+	_, err := maglev.Pick("10.0.0.1", nil)
+	assert.Equal(EmptyServiceErr, err)
+}
@@ -0,0 +1,85 @@
+package balance
+
+import (
+	"sync"
+
+	"github.com/KKKKjl/tinykit/internal/registry"
+)
+
+// inflight counts the outstanding requests of the services by addr.
+type inflight struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// load returns the outstanding requests per weight of the service after picking it, mu must be held.
+func (f *inflight) load(service *registry.Service) float64 {
+	return float64(f.counts[service.Addr]+1) / float64(weight(service))
+}
+
+func (f *inflight) Done(service *registry.Service, info DoneInfo) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.counts[service.Addr] <= 1 {
+		delete(f.counts, service.Addr)
+		return
+	}
+
+	f.counts[service.Addr]--
+}
+
+// LeastRequest picks the service with the least outstanding requests per weight,
+// ties are broken in round robin order.
+type LeastRequest struct {
+	inflight
+	next int
+}
+
+func init() {
+	balancer[LEAST_REQUEST] = func() Picker { return NewLeastRequest() }
+}
+
+func NewLeastRequest() *LeastRequest {
+	return &LeastRequest{
+		inflight: inflight{counts: make(map[string]int)},
+	}
+}
+
+func (l *LeastRequest) Pick(key string, services []*registry.Service) (*registry.Service, error) {
+	length := len(services)
+	if length == 0 {
+		return nil, EmptyServiceErr
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.next++
+
+	var (
+		best      *registry.Service
+		bestScore float64
+	)
+	for i := 0; i < length; i++ {
+		v := services[(l.next+i)%length]
+		if v == nil {
+			continue
+		}
+
+		if score := l.load(v); best == nil || score < bestScore {
+			best, bestScore = v, score
+		}
+	}
+
+	if best == nil {
+		return nil, NilServiceErr
+	}
+
+	l.counts[best.Addr]++
+	return best, nil
+}
+
+func (l *LeastRequest) Scheme() string {
+	return "least_request"
+}
@@ -0,0 +1,72 @@
+package balance
+
+import (
+	"testing"
+
+	"github.com/KKKKjl/tinykit/internal/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeastRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	services := []*registry.Service{
+		{Addr: "localhost:8080", Weight: 1},
+		{Addr: "localhost:8081", Weight: 1},
+		{Addr: "localhost:8082", Weight: 2},
+	}
+
+	leastRequest := NewLeastRequest()
+
+	// the weight 2 service takes two requests per request of the others
+	picked := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		service, err := leastRequest.Pick("", services)
+		assert.Nil(err)
+		picked[service.Addr]++
+	}
+	assert.Equal(map[string]int{"localhost:8080": 1, "localhost:8081": 1, "localhost:8082": 2}, picked)
+
+	// the completed service is picked next
+	leastRequest.Done(services[1], DoneInfo{})
+	service, _ := leastRequest.Pick("", services)
+	assert.Equal("localhost:8081", service.Addr)
+
+	for _, v := range services {
+		for leastRequest.counts[v.Addr] > 0 {
+			leastRequest.Done(v, DoneInfo{})
+		}
+	}
+	assert.Empty(leastRequest.counts)
+
+	_, err := leastRequest.Pick("", nil)
+	assert.Equal(EmptyServiceErr, err)
+}
+
+func TestPowerOfTwoChoices(t *testing.T) {
+	assert := assert.New(t)
+
+	services := []*registry.Service{
+		{Addr: "localhost:8080"},
+		{Addr: "localhost:8081"},
+		{Addr: "localhost:8082"},
+	}
+
+	p2c := NewPowerOfTwoChoices(1)
+	p2c.counts["localhost:8080"] = 5
+
+	// the busy service loses both choices it takes part in
+	picked := make(map[string]int)
+	for i := 0; i < 20; i++ {
+		service, err := p2c.Pick("", services)
+		assert.Nil(err)
+		picked[service.Addr]++
+		p2c.Done(service, DoneInfo{})
+	}
+	assert.Zero(picked["localhost:8080"])
+	assert.Equal(20, picked["localhost:8081"]+picked["localhost:8082"])
+
+	service, _ := p2c.Pick("", services[:1])
+	assert.Equal("localhost:8080", service.Addr)
+	assert.Equal(6, p2c.counts["localhost:8080"])
+}
@@ -0,0 +1,123 @@
+package balance
+
+import (
+	"hash/crc32"
+	"sync"
+
+	"github.com/KKKKjl/tinykit/internal/registry"
+)
+
+// _defaultTableSize is a prime much larger than the number of services.
+const _defaultTableSize = 65537
+
+// Maglev picks services from a lookup table rebuilt when the picked services change,
+// services take table entries in proportion to their weights and the entries move minimally
+// when a service is added or removed.
+// reference: https://research.google/pubs/pub44824/
+type Maglev struct {
+	mu      sync.Mutex
+	size    int // prime
+	members []member
+	table   []int // entry -> index of the service
+}
+
+func init() {
+	balancer[MAGLEV] = func() Picker { return NewMaglev(_defaultTableSize) }
+}
+
+// NewMaglev creates a maglev picker, size must be a prime.
+func NewMaglev(size int) *Maglev {
+	if size < 2 {
+		size = _defaultTableSize
+	}
+
+	return &Maglev{size: size}
+}
+
+func (m *Maglev) Pick(key string, services []*registry.Service) (*registry.Service, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !sameMembers(m.members, services) {
+		m.build(services)
+	}
+
+	if len(m.table) == 0 {
+		return nil, EmptyServiceErr
+	}
+
+	return services[m.table[hash64([]byte(key))%uint64(m.size)]], nil
+}
+
+func (m *Maglev) build(services []*registry.Service) {
+	m.members = members(services)
+	m.table = nil
+
+	type permutation struct {
+		index  int
+		offset uint64
+		skip   uint64
+		next   uint64 // next position of the permutation
+		weight float64
+		credit float64
+	}
+
+	var (
+		perms     []*permutation
+		maxWeight int
+	)
+	for i, v := range services {
+		if v == nil || len(v.Addr) == 0 {
+			continue
+		}
+
+		perms = append(perms, &permutation{
+			index:  i,
+			offset: hash64([]byte(v.Addr)) % uint64(m.size),
+			skip:   uint64(crc32.ChecksumIEEE([]byte(v.Addr)))%uint64(m.size-1) + 1,
+			weight: float64(weight(v)),
+		})
+
+		if weight(v) > maxWeight {
+			maxWeight = weight(v)
+		}
+	}
+
+	if len(perms) == 0 {
+		return
+	}
+
+	table := make([]int, m.size)
+	for i := range table {
+		table[i] = -1
+	}
+
+	for filled := 0; ; {
+		for _, p := range perms {
+			// the heaviest services take an entry every round, the others in proportion
+			p.credit += p.weight / float64(maxWeight)
+			if p.credit < 1 {
+				continue
+			}
+			p.credit--
+
+			entry := (p.offset + p.next*p.skip) % uint64(m.size)
+			for table[entry] >= 0 {
+				p.next++
+				entry = (p.offset + p.next*p.skip) % uint64(m.size)
+			}
+
+			table[entry] = p.index
+			p.next++
+
+			if filled++; filled == m.size {
+				m.table = table
+				return
+			}
+		}
+	}
+}
+
+func (m *Maglev) Scheme() string {
+	return "maglev"
+}
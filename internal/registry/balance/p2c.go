@@ -0,0 +1,71 @@
+package balance
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/KKKKjl/tinykit/internal/registry"
+)
+
+// PowerOfTwoChoices picks two random services and chooses the one with less outstanding requests per weight.
+type PowerOfTwoChoices struct {
+	inflight
+	rand *rand.Rand
+}
+
+func init() {
+	balancer[P2C] = func() Picker { return NewPowerOfTwoChoices(time.Now().UnixNano()) }
+}
+
+func NewPowerOfTwoChoices(seed int64) *PowerOfTwoChoices {
+	return &PowerOfTwoChoices{
+		inflight: inflight{counts: make(map[string]int)},
+		rand:     rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (p *PowerOfTwoChoices) Pick(key string, services []*registry.Service) (*registry.Service, error) {
+	length := len(services)
+	if length == 0 {
+		return nil, EmptyServiceErr
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	best := services[0]
+	if length > 1 {
+		i, j := pickTwo(p.rand, length)
+
+		a, b := services[i], services[j]
+		switch {
+		case a == nil:
+			best = b
+		case b == nil || p.load(a) <= p.load(b):
+			best = a
+		default:
+			best = b
+		}
+	}
+
+	if best == nil {
+		return nil, NilServiceErr
+	}
+
+	p.counts[best.Addr]++
+	return best, nil
+}
+
+func (p *PowerOfTwoChoices) Scheme() string {
+	return "p2c"
+}
+
+// pickTwo returns two distinct random indexes less than n, n must be greater than 1.
+func pickTwo(r *rand.Rand, n int) (int, int) {
+	i, j := r.Intn(n), r.Intn(n-1)
+	if j >= i {
+		j++
+	}
+
+	return i, j
+}
@@ -0,0 +1,199 @@
+package balance
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/KKKKjl/tinykit/internal/registry"
+)
+
+const (
+	_defaultDecay = 10 * time.Second
+
+	// latency of the services never observed
+	_defaultLatency = 30 * time.Millisecond
+	// latency of a failed request, so a service refusing connections does not look fast
+	_failurePenalty = time.Second
+	// floor of the decayed latency, so the outstanding requests of an idle service still cost
+	_minLatency = time.Millisecond
+)
+
+// ewma is the peak ewma of the latency of a service.
+type ewma struct {
+	value    float64   // nanoseconds, zero until observed
+	stamp    time.Time // last update
+	inflight int
+	picked   time.Time // last pick
+}
+
+// PeakEWMA picks the cheaper of two random services, the cost of a service is the peak ewma of its
+// latency multiplied by its outstanding requests. A latency above the average replaces it at once,
+// lower latencies and idle time decay it within the decay time, so slow services are probed again
+// after a while. New services start at a default latency and failures count as a penalty latency.
+type PeakEWMA struct {
+	mu     sync.Mutex
+	decay  time.Duration
+	states map[string]*ewma // addr -> ewma
+	swept  time.Time
+	rand   *rand.Rand
+	now    func() time.Time
+}
+
+func init() {
+	balancer[PEAK_EWMA] = func() Picker { return NewPeakEWMA(_defaultDecay, time.Now().UnixNano()) }
+}
+
+func NewPeakEWMA(decay time.Duration, seed int64) *PeakEWMA {
+	if decay <= 0 {
+		decay = _defaultDecay
+	}
+
+	return &PeakEWMA{
+		decay:  decay,
+		states: make(map[string]*ewma),
+		rand:   rand.New(rand.NewSource(seed)),
+		now:    time.Now,
+	}
+}
+
+func (p *PeakEWMA) Pick(key string, services []*registry.Service) (*registry.Service, error) {
+	length := len(services)
+	if length == 0 {
+		return nil, EmptyServiceErr
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	p.sweep(services, now)
+
+	best := services[0]
+	if length > 1 {
+		i, j := pickTwo(p.rand, length)
+
+		a, b := services[i], services[j]
+		switch {
+		case a == nil:
+			best = b
+		case b == nil:
+			best = a
+		default:
+			if p.cost(b, now) < p.cost(a, now) {
+				best = b
+			} else {
+				best = a
+			}
+		}
+	}
+
+	if best == nil {
+		return nil, NilServiceErr
+	}
+
+	s := p.state(best.Addr, now)
+	s.inflight++
+	s.picked = now
+
+	return best, nil
+}
+
+func (p *PeakEWMA) Done(service *registry.Service, info DoneInfo) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+
+	s := p.state(service.Addr, now)
+	if s.inflight > 0 {
+		s.inflight--
+	}
+
+	// canceled by the client, the latency says nothing about the service
+	if errors.Is(info.Err, context.Canceled) {
+		return
+	}
+
+	latency := float64(info.Latency)
+	if info.Err != nil && latency < float64(_failurePenalty) {
+		latency = float64(_failurePenalty)
+	}
+
+	switch {
+	case latency > s.value:
+		s.value = latency
+	case info.Err == nil:
+		// failures returning fast must not make the service look faster
+		w := p.decayed(s, now)
+		s.value = s.value*w + latency*(1-w)
+	default:
+		return
+	}
+	s.stamp = now
+}
+
+// cost returns the decayed latency multiplied by the outstanding requests after picking the service,
+// services never observed cost the default latency.
+func (p *PeakEWMA) cost(service *registry.Service, now time.Time) float64 {
+	s, ok := p.states[service.Addr]
+	if !ok {
+		return float64(_defaultLatency) / float64(weight(service))
+	}
+
+	latency := float64(_defaultLatency)
+	if s.value > 0 {
+		latency = math.Max(s.value*p.decayed(s, now), float64(_minLatency))
+	}
+
+	return latency * float64(s.inflight+1) / float64(weight(service))
+}
+
+// decayed is the share of the previous value after the time elapsed since the last update.
+func (p *PeakEWMA) decayed(s *ewma, now time.Time) float64 {
+	elapsed := now.Sub(s.stamp)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	return math.Exp(-float64(elapsed) / float64(p.decay))
+}
+
+func (p *PeakEWMA) state(addr string, now time.Time) *ewma {
+	s, ok := p.states[addr]
+	if !ok {
+		s = &ewma{stamp: now}
+		p.states[addr] = s
+	}
+
+	return s
+}
+
+// sweep drops the states of the idle services not picked within the decay time once per decay time,
+// e.g. the services removed from the discovery, mu must be held.
+func (p *PeakEWMA) sweep(services []*registry.Service, now time.Time) {
+	if now.Sub(p.swept) < p.decay {
+		return
+	}
+	p.swept = now
+
+	live := make(map[string]struct{}, len(services))
+	for _, v := range services {
+		if v != nil {
+			live[v.Addr] = struct{}{}
+		}
+	}
+
+	for addr, s := range p.states {
+		if _, ok := live[addr]; !ok && s.inflight == 0 && now.Sub(s.picked) > p.decay {
+			delete(p.states, addr)
+		}
+	}
+}
+
+func (p *PeakEWMA) Scheme() string {
+	return "peak_ewma"
+}
@@ -0,0 +1,142 @@
+package balance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/KKKKjl/tinykit/internal/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeakEWMA(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1000, 0)
+	slow := &registry.Service{Addr: "localhost:8080"}
+	fast := &registry.Service{Addr: "localhost:8081"}
+	services := []*registry.Service{slow, fast}
+
+	ewma := NewPeakEWMA(10*time.Second, 1)
+	ewma.now = func() time.Time { return now }
+
+	ewma.Done(slow, DoneInfo{Latency: 100 * time.Millisecond})
+	ewma.Done(fast, DoneInfo{Latency: 10 * time.Millisecond})
+
+	for i := 0; i < 10; i++ {
+		service, err := ewma.Pick("", services)
+		assert.Nil(err)
+		assert.Equal(fast, service)
+
+		now = now.Add(100 * time.Millisecond)
+		ewma.Done(service, DoneInfo{Latency: 10 * time.Millisecond})
+	}
+
+	// outstanding requests of the fast service
+	for i := 0; i < 20; i++ {
+		ewma.Pick("", []*registry.Service{fast})
+	}
+	service, _ := ewma.Pick("", services)
+	assert.Equal(slow, service)
+	ewma.Done(slow, DoneInfo{Latency: 100 * time.Millisecond})
+
+	for i := 0; i < 20; i++ {
+		ewma.Done(fast, DoneInfo{Latency: 10 * time.Millisecond})
+	}
+	service, _ = ewma.Pick("", services)
+	assert.Equal(fast, service)
+	ewma.Done(fast, DoneInfo{Latency: 10 * time.Millisecond})
+
+	// a peak replaces the average at once
+	ewma.Done(fast, DoneInfo{Latency: 500 * time.Millisecond})
+	service, _ = ewma.Pick("", services)
+	assert.Equal(slow, service)
+	ewma.Done(slow, DoneInfo{Latency: 100 * time.Millisecond})
+
+	// fast failures do not lower the average
+	ewma.Done(fast, DoneInfo{Latency: time.Millisecond, Err: EmptyServiceErr})
+	service, _ = ewma.Pick("", services)
+	assert.Equal(slow, service)
+}
+
+func TestPeakEWMAProbe(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1000, 0)
+	slow := &registry.Service{Addr: "localhost:8080"}
+	fast := &registry.Service{Addr: "localhost:8081"}
+	services := []*registry.Service{slow, fast}
+
+	ewma := NewPeakEWMA(10*time.Second, 1)
+	ewma.now = func() time.Time { return now }
+
+	ewma.Done(slow, DoneInfo{Latency: 100 * time.Millisecond})
+
+	// the idle slow service decays below the busy fast one
+	for i := 0; i < 30; i++ {
+		now = now.Add(time.Second)
+
+		service, _ := ewma.Pick("", services)
+		if service == slow {
+			assert.Greater(i, 20)
+			return
+		}
+		ewma.Done(service, DoneInfo{Latency: 10 * time.Millisecond})
+	}
+
+	t.Fatal("slow service never probed")
+}
+
+func TestPeakEWMANewService(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1000, 0)
+	known := &registry.Service{Addr: "localhost:8080"}
+	added := &registry.Service{Addr: "localhost:8081"}
+	services := []*registry.Service{known, added}
+
+	ewma := NewPeakEWMA(10*time.Second, 1)
+	ewma.now = func() time.Time { return now }
+
+	ewma.Done(known, DoneInfo{Latency: 10 * time.Millisecond})
+
+	// the added service does not take all the requests before its first response
+	var picked int
+	for i := 0; i < 20; i++ {
+		if service, _ := ewma.Pick("", services); service == added {
+			picked++
+		}
+	}
+	assert.Less(picked, 10)
+
+	// a service refusing connections does not look fast
+	refused := &registry.Service{Addr: "localhost:8082"}
+	ewma.Done(refused, DoneInfo{Latency: time.Microsecond, Err: EmptyServiceErr})
+	for i := 0; i < 10; i++ {
+		service, _ := ewma.Pick("", []*registry.Service{refused, added})
+		assert.Equal(added, service)
+		ewma.Done(service, DoneInfo{Latency: 20 * time.Millisecond})
+	}
+}
+
+func TestPeakEWMASweep(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1000, 0)
+	a := &registry.Service{Addr: "localhost:8080"}
+	b := &registry.Service{Addr: "localhost:8081"}
+
+	ewma := NewPeakEWMA(10*time.Second, 1)
+	ewma.now = func() time.Time { return now }
+
+	ewma.Pick("", []*registry.Service{a})
+	ewma.Pick("", []*registry.Service{b})
+	ewma.Done(b, DoneInfo{Latency: 10 * time.Millisecond})
+
+	// b is removed, a still has a request in flight
+	now = now.Add(time.Minute)
+	ewma.Pick("", []*registry.Service{})
+	ewma.Pick("", []*registry.Service{a})
+
+	assert.Contains(ewma.states, a.Addr)
+	assert.NotContains(ewma.states, b.Addr)
+}
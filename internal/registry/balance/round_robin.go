@@ -11,7 +11,7 @@ type RoundRobin struct {
 }
 
 func init() {
-	balancer[ROUND_ROBIN] = NewRoundRobin()
+	balancer[ROUND_ROBIN] = func() Picker { return NewRoundRobin() }
 }
 
 func NewRoundRobin() *RoundRobin {
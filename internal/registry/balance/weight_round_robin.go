@@ -10,7 +10,7 @@ type WeightRoundRobin struct {
 }
 
 func init() {
-	balancer[WEIGHT_ROUND_ROBIN] = NewWeightRoundRobin()
+	balancer[WEIGHT_ROUND_ROBIN] = func() Picker { return NewWeightRoundRobin() }
 }
 
 func NewWeightRoundRobin() *WeightRoundRobin {
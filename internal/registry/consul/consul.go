@@ -0,0 +1,451 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KKKKjl/tinykit/internal/registry"
+	"github.com/KKKKjl/tinykit/logger"
+)
+
+const (
+	_defaultAddress = "http://127.0.0.1:8500"
+	_defaultScheme  = "http"
+	_defaultWait    = time.Minute
+
+	_indexHeader = "X-Consul-Index"
+	_tokenHeader = "X-Consul-Token"
+	_passing     = "passing"
+	_tagPrefix   = "tag:"
+)
+
+var (
+	log     = logger.GetLogger()
+	mainLog = log.WithField("prefix", "consul")
+)
+
+var _ registry.Resolver = (*ConsulDiscovery)(nil)
+
+type (
+	// Config of the consul discovery, Wait is the max duration of a blocking query.
+	Config struct {
+		Address    string // http://host:port of the consul agent
+		Token      string
+		Datacenter string
+		Scheme     string // scheme of the service addrs
+		Wait       time.Duration
+	}
+
+	// ConsulDiscovery watches the passing instances of the resolved services by blocking queries
+	// to the health api of consul. The version of an instance is its meta version.
+	ConsulDiscovery struct {
+		conf    Config
+		client  *http.Client
+		retry   time.Duration // wait after a failed query
+		ctx     context.Context
+		cancel  context.CancelFunc
+		mu      sync.Mutex
+		watches map[string]*watch // name -> watch
+	}
+
+	// watch keeps the passing instances of a service, the last ones are kept on failure.
+	// It is watched until released by all the views returned by Resolve.
+	watch struct {
+		discovery *ConsulDiscovery
+		name      string
+		refs      int           // guarded by the mutex of the discovery
+		ready     chan struct{} // closed once fetched the first time
+		ctx       context.Context
+		cancel    context.CancelFunc
+		mu        sync.RWMutex
+		services  []*registry.Service
+		index     uint64
+	}
+
+	// serviceView is the builder of a version of a watched service.
+	serviceView struct {
+		watch   *watch
+		version string
+	}
+
+	// entry is a result of /v1/health/service/<name>.
+	entry struct {
+		Node struct {
+			Node       string
+			Address    string
+			Datacenter string
+		}
+		Service struct {
+			ID      string
+			Service string
+			Tags    []string
+			Address string
+			Port    int
+			Meta    map[string]string
+			Weights struct {
+				Passing int
+			}
+		}
+		Checks []struct {
+			Status string
+		}
+	}
+)
+
+func New(c Config) *ConsulDiscovery {
+	if c.Address == "" {
+		c.Address = _defaultAddress
+	}
+
+	if c.Scheme == "" {
+		c.Scheme = _defaultScheme
+	}
+
+	if c.Wait <= 0 {
+		c.Wait = _defaultWait
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &ConsulDiscovery{
+		conf:    c,
+		client:  &http.Client{},
+		retry:   5 * time.Second,
+		ctx:     ctx,
+		cancel:  cancel,
+		watches: make(map[string]*watch),
+	}
+}
+
+// Resolve returns the builder of the passing instances of the service, empty version means any version.
+// The instances are fetched once before returning and then watched in the background until all the
+// builders of the service are released.
+func (d *ConsulDiscovery) Resolve(name, version string) registry.Builder {
+	d.mu.Lock()
+	w, ok := d.watches[name]
+	if !ok {
+		ctx, cancel := context.WithCancel(d.ctx)
+		w = &watch{discovery: d, name: name, ready: make(chan struct{}), ctx: ctx, cancel: cancel}
+		d.watches[name] = w
+	}
+	w.refs++
+	d.mu.Unlock()
+
+	// fetched without holding the lock, the other callers of the service wait for the first fetch
+	if !ok {
+		if err := w.refresh(); err != nil {
+			mainLog.Errorf("Fetch service %s error: %v", name, err)
+		}
+		close(w.ready)
+
+		go w.run()
+	}
+	<-w.ready
+
+	return &serviceView{watch: w, version: version}
+}
+
+// GetService returns the instances of all the resolved services.
+func (d *ConsulDiscovery) GetService() ([]*registry.Service, error) {
+	return d.ListServer(), nil
+}
+
+func (d *ConsulDiscovery) ListServer() []*registry.Service {
+	d.mu.Lock()
+	names := make([]string, 0, len(d.watches))
+	for k := range d.watches {
+		names = append(names, k)
+	}
+	d.mu.Unlock()
+	sort.Strings(names)
+
+	var services []*registry.Service
+	for _, v := range names {
+		// released meanwhile
+		if w := d.watch(v); w != nil {
+			services = append(services, w.instances("")...)
+		}
+	}
+
+	return services
+}
+
+// PutServer adds a service until the next change of the watched service.
+func (d *ConsulDiscovery) PutServer(service *registry.Service) {
+	if w := d.watch(service.Name); w != nil {
+		w.put(service)
+	}
+}
+
+// DelServer removes a service until the next change of the watched service.
+func (d *ConsulDiscovery) DelServer(service *registry.Service) {
+	if w := d.watch(service.Name); w != nil {
+		w.del(service)
+	}
+}
+
+func (d *ConsulDiscovery) watch(name string) *watch {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.watches[name]
+}
+
+func (d *ConsulDiscovery) Scheme() string {
+	return "consul"
+}
+
+// Close stops watching the services.
+func (d *ConsulDiscovery) Close() {
+	d.cancel()
+}
+
+// health queries the passing instances of the service, a non zero index blocks until the index changes or wait elapses.
+func (d *ConsulDiscovery) health(ctx context.Context, name string, index uint64) ([]*registry.Service, uint64, error) {
+	query := url.Values{}
+	query.Set(_passing, "true")
+	if d.conf.Datacenter != "" {
+		query.Set("dc", d.conf.Datacenter)
+	}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", d.conf.Wait.String())
+	}
+
+	// consul adds up to wait/16 to the wait
+	ctx, cancel := context.WithTimeout(ctx, d.conf.Wait+d.conf.Wait/16+5*time.Second)
+	defer cancel()
+
+	u := strings.TrimSuffix(d.conf.Address, "/") + "/v1/health/service/" + url.PathEscape(name) + "?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if d.conf.Token != "" {
+		req.Header.Set(_tokenHeader, d.conf.Token)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("consul status %d", resp.StatusCode)
+	}
+
+	var entries []entry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, err
+	}
+
+	index, _ = strconv.ParseUint(resp.Header.Get(_indexHeader), 10, 64)
+
+	services := make([]*registry.Service, 0, len(entries))
+	for _, v := range entries {
+		if !v.passing() {
+			continue
+		}
+
+		services = append(services, v.service(d.conf.Scheme))
+	}
+
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Addr < services[j].Addr
+	})
+
+	return services, index, nil
+}
+
+// passing reports whether all the checks of the instance are passing.
+func (e *entry) passing() bool {
+	for _, v := range e.Checks {
+		if v.Status != _passing {
+			return false
+		}
+	}
+
+	return true
+}
+
+// service converts the instance, its meta and tags are the metadata, a tag is the key tag:<name> with value true.
+func (e *entry) service(scheme string) *registry.Service {
+	host := e.Service.Address
+	if host == "" {
+		host = e.Node.Address
+	}
+
+	metadata := make(map[string]string, len(e.Service.Meta)+len(e.Service.Tags)+2)
+	for k, v := range e.Service.Meta {
+		metadata[k] = v
+	}
+	metadata["id"] = e.Service.ID
+	metadata["node"] = e.Node.Node
+	for _, v := range e.Service.Tags {
+		metadata[_tagPrefix+v] = "true"
+	}
+
+	weight := e.Service.Weights.Passing
+	if weight <= 0 {
+		weight = 1
+	}
+
+	return &registry.Service{
+		Name:     e.Service.Service,
+		Version:  e.Service.Meta["version"],
+		Addr:     scheme + "://" + net.JoinHostPort(host, strconv.Itoa(e.Service.Port)),
+		Weight:   weight,
+		Metadata: metadata,
+	}
+}
+
+func (w *watch) run() {
+	ctx := w.ctx
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		if err := w.refresh(); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			mainLog.Errorf("Watch service %s error, keep %d instances: %v", w.name, len(w.instances("")), err)
+
+			select {
+			case <-time.After(w.discovery.retry):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// refresh waits for the next change of the instances and replaces them.
+func (w *watch) refresh() error {
+	w.mu.RLock()
+	index := w.index
+	w.mu.RUnlock()
+
+	services, next, err := w.discovery.health(w.ctx, w.name, index)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	switch {
+	case next < w.index:
+		// the index went backwards, e.g. after a snapshot restore, fetch again without blocking
+		next = 0
+	case next == 0:
+		// a zero index does not block
+		next = 1
+	}
+
+	w.index = next
+	w.services = services
+
+	return nil
+}
+
+// instances returns the instances of the version, empty version means any.
+func (w *watch) instances(version string) []*registry.Service {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	services := make([]*registry.Service, 0, len(w.services))
+	for _, v := range w.services {
+		if version == "" || v.Version == version {
+			services = append(services, v)
+		}
+	}
+
+	return services
+}
+
+// release stops watching the service once all its views are released.
+func (w *watch) release() {
+	d := w.discovery
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	w.refs--
+	if w.refs > 0 {
+		return
+	}
+
+	if d.watches[w.name] == w {
+		delete(d.watches, w.name)
+	}
+	w.cancel()
+}
+
+func (w *watch) put(service *registry.Service) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i, v := range w.services {
+		if v.Addr == service.Addr {
+			w.services[i] = service
+			return
+		}
+	}
+
+	// copy on write, the instances may be read concurrently
+	w.services = append(w.services[:len(w.services):len(w.services)], service)
+}
+
+func (w *watch) del(service *registry.Service) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i, v := range w.services {
+		if v.Addr == service.Addr {
+			w.services = append(w.services[:i:i], w.services[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *serviceView) GetService() ([]*registry.Service, error) {
+	return s.ListServer(), nil
+}
+
+func (s *serviceView) PutServer(service *registry.Service) {
+	s.watch.put(service)
+}
+
+func (s *serviceView) ListServer() []*registry.Service {
+	return s.watch.instances(s.version)
+}
+
+func (s *serviceView) DelServer(service *registry.Service) {
+	s.watch.del(service)
+}
+
+// Release releases the watch of the service, the view must not be used afterwards.
+func (s *serviceView) Release() {
+	s.watch.release()
+}
+
+func (s *serviceView) Scheme() string {
+	return "consul"
+}
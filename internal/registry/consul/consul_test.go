@@ -0,0 +1,204 @@
+package consul
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/KKKKjl/tinykit/internal/registry"
+)
+
+// fakeConsul serves /v1/health/service/<name> with blocking queries.
+type fakeConsul struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	index   uint64
+	entries map[string][]map[string]interface{}
+	fail    bool
+	queries []string
+}
+
+func newFakeConsul() *fakeConsul {
+	f := &fakeConsul{index: 1, entries: make(map[string][]map[string]interface{})}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *fakeConsul) set(name string, entries ...map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.entries[name] = entries
+	f.index++
+	f.cond.Broadcast()
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.queries = append(f.queries, r.URL.RawQuery)
+
+	if f.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if r.Header.Get("X-Consul-Token") != "token" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64); index > 0 {
+		wait, _ := time.ParseDuration(r.URL.Query().Get("wait"))
+		timer := time.AfterFunc(wait, func() {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.cond.Broadcast()
+		})
+		defer timer.Stop()
+
+		deadline := time.Now().Add(wait)
+		for f.index == index && time.Now().Before(deadline) {
+			f.cond.Wait()
+		}
+	}
+
+	name := r.URL.Path[len("/v1/health/service/"):]
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	json.NewEncoder(w).Encode(f.entries[name])
+}
+
+func instance(id, addr string, port int, version string, status string) map[string]interface{} {
+	return map[string]interface{}{
+		"Node": map[string]interface{}{"Node": "node-" + id, "Address": "10.0.0.3"},
+		"Service": map[string]interface{}{
+			"ID":      id,
+			"Service": "greeter",
+			"Tags":    []string{"primary", "http"},
+			"Address": addr,
+			"Port":    port,
+			"Meta":    map[string]string{"version": version, "zone": "a"},
+			"Weights": map[string]int{"Passing": 3, "Warning": 1},
+		},
+		"Checks": []map[string]string{{"Status": "passing"}, {"Status": status}},
+	}
+}
+
+func addrs(services []*registry.Service) []string {
+	addrs := make([]string, 0, len(services))
+	for _, v := range services {
+		addrs = append(addrs, v.Addr)
+	}
+
+	return addrs
+}
+
+func TestResolve(t *testing.T) {
+	assert := assert.New(t)
+
+	f := newFakeConsul()
+	f.set("greeter",
+		instance("b", "10.0.0.2", 8080, "v2", "passing"),
+		instance("a", "10.0.0.1", 8080, "v1", "passing"),
+		instance("c", "", 8081, "v1", "passing"),
+		instance("d", "10.0.0.4", 8080, "v1", "critical"),
+	)
+
+	server := httptest.NewServer(f)
+	defer server.Close()
+
+	d := New(Config{Address: server.URL, Token: "token", Datacenter: "dc1", Wait: 100 * time.Millisecond})
+	defer d.Close()
+
+	builder := d.Resolve("greeter", "")
+	services := builder.ListServer()
+	assert.Equal([]string{"http://10.0.0.1:8080", "http://10.0.0.2:8080", "http://10.0.0.3:8081"}, addrs(services))
+
+	service := services[0]
+	assert.Equal("greeter", service.Name)
+	assert.Equal("v1", service.Version)
+	assert.Equal(3, service.Weight)
+	assert.Equal(map[string]string{"version": "v1", "zone": "a", "id": "a", "node": "node-a", "tag:primary": "true", "tag:http": "true"}, service.Metadata)
+
+	v1 := d.Resolve("greeter", "v1")
+	assert.Equal([]string{"http://10.0.0.1:8080", "http://10.0.0.3:8081"}, addrs(v1.ListServer()))
+
+	f.mu.Lock()
+	assert.Equal("dc=dc1&passing=true", f.queries[0])
+	f.mu.Unlock()
+
+	// the blocking query returns on change
+	f.set("greeter", instance("a", "10.0.0.1", 8080, "v1", "passing"))
+	assert.Eventually(func() bool {
+		return len(builder.ListServer()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Len(d.ListServer(), 1)
+}
+
+func TestKeepOnFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	f := newFakeConsul()
+	f.set("greeter", instance("a", "10.0.0.1", 8080, "v1", "passing"))
+
+	server := httptest.NewServer(f)
+	defer server.Close()
+
+	d := New(Config{Address: server.URL, Token: "token", Wait: 50 * time.Millisecond})
+	d.retry = 10 * time.Millisecond
+	defer d.Close()
+
+	builder := d.Resolve("greeter", "")
+	assert.Len(builder.ListServer(), 1)
+
+	f.mu.Lock()
+	f.fail = true
+	f.mu.Unlock()
+
+	time.Sleep(200 * time.Millisecond)
+	assert.Len(builder.ListServer(), 1)
+
+	f.mu.Lock()
+	f.fail = false
+	f.mu.Unlock()
+
+	f.set("greeter", instance("a", "10.0.0.1", 8080, "v1", "passing"), instance("b", "10.0.0.2", 8080, "v1", "passing"))
+	assert.Eventually(func() bool {
+		return len(builder.ListServer()) == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRelease(t *testing.T) {
+	assert := assert.New(t)
+
+	f := newFakeConsul()
+	f.set("greeter", instance("a", "10.0.0.1", 8080, "v1", "passing"))
+
+	server := httptest.NewServer(f)
+	defer server.Close()
+
+	d := New(Config{Address: server.URL, Token: "token", Wait: time.Minute})
+	defer d.Close()
+
+	all := d.Resolve("greeter", "")
+	v1 := d.Resolve("greeter", "v1")
+	w := d.watch("greeter")
+
+	// still used by the v1 route
+	all.(registry.Releaser).Release()
+	assert.Len(d.ListServer(), 1)
+	assert.Nil(w.ctx.Err())
+
+	// the blocking query is canceled
+	v1.(registry.Releaser).Release()
+	assert.Empty(d.ListServer())
+	assert.NotNil(w.ctx.Err())
+}
@@ -0,0 +1,248 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	_resolvConf    = "/etc/resolv.conf"
+	_maxPacketSize = 65535
+	_defaultNdots  = 1
+)
+
+var (
+	IdMismatchErr = errors.New("DNS response id mismatch.")
+	NoRecordsErr  = errors.New("No DNS records found.")
+)
+
+// client sends the queries to a single DNS server, over tcp if the udp response is truncated.
+// The relative names are completed by the search domains like the system resolver.
+type client struct {
+	server string // host:port
+	search []string
+	ndots  int
+	dialer net.Dialer
+}
+
+// resolvConf is the system resolver config.
+type resolvConf struct {
+	server string // host:port of the first nameserver
+	search []string
+	ndots  int
+}
+
+// exchange queries the records of the type of name, the candidates of a relative name are tried in order
+// until one has records.
+func (c *client) exchange(ctx context.Context, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	var (
+		empty   *dnsmessage.Message
+		lastErr error
+	)
+	for _, v := range c.candidates(name) {
+		resp, err := c.query(ctx, v, qtype)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if len(resp.Answers) > 0 {
+			return resp, nil
+		}
+
+		if empty == nil {
+			empty = resp
+		}
+	}
+
+	if empty != nil {
+		return empty, nil
+	}
+
+	return nil, lastErr
+}
+
+// candidates returns the names to query for name, a relative name with at least ndots dots is tried as is
+// before the search domains, the others after them.
+func (c *client) candidates(name string) []string {
+	if strings.HasSuffix(name, ".") || len(c.search) == 0 {
+		return []string{name}
+	}
+
+	names := make([]string, 0, len(c.search)+1)
+	for _, v := range c.search {
+		names = append(names, name+"."+strings.Trim(v, "."))
+	}
+
+	if strings.Count(name, ".") >= c.ndots {
+		return append([]string{name}, names...)
+	}
+
+	return append(names, name)
+}
+
+// query queries the records of the type of the name.
+func (c *client) query(ctx context.Context, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	n, err := dnsmessage.NewName(fqdn(name))
+	if err != nil {
+		return nil, err
+	}
+
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               uint16(rand.Intn(1 << 16)),
+			RecursionDesired: true,
+		},
+		Questions: []dnsmessage.Question{{
+			Name:  n,
+			Type:  qtype,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+
+	packet, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.roundTrip(ctx, "udp", packet)
+	if err == nil && resp.Truncated {
+		resp, err = c.roundTrip(ctx, "tcp", packet)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.ID != query.ID {
+		return nil, IdMismatchErr
+	}
+
+	if resp.RCode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("lookup %s %s: %s", name, qtype, resp.RCode)
+	}
+
+	return resp, nil
+}
+
+func (c *client) roundTrip(ctx context.Context, network string, packet []byte) (*dnsmessage.Message, error) {
+	conn, err := c.dialer.DialContext(ctx, network, c.server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	buf := make([]byte, _maxPacketSize)
+
+	var n int
+	if network == "tcp" {
+		// tcp messages are prefixed by their length
+		prefixed := make([]byte, 2+len(packet))
+		binary.BigEndian.PutUint16(prefixed, uint16(len(packet)))
+		copy(prefixed[2:], packet)
+
+		if _, err := conn.Write(prefixed); err != nil {
+			return nil, err
+		}
+
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return nil, err
+		}
+
+		n = int(binary.BigEndian.Uint16(buf[:2]))
+		if _, err := io.ReadFull(conn, buf[:n]); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(packet); err != nil {
+			return nil, err
+		}
+
+		if n, err = conn.Read(buf); err != nil {
+			return nil, err
+		}
+	}
+
+	var msg dnsmessage.Message
+	if err := msg.Unpack(buf[:n]); err != nil {
+		return nil, err
+	}
+
+	return &msg, nil
+}
+
+// readResolvConf returns the first nameserver, the search domains and ndots of the resolv.conf file.
+func readResolvConf(file string) (*resolvConf, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	conf := &resolvConf{ndots: _defaultNdots}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "nameserver":
+			if conf.server == "" {
+				conf.server = net.JoinHostPort(fields[1], "53")
+			}
+		case "search", "domain":
+			// the last one wins
+			conf.search = fields[1:]
+		case "options":
+			for _, v := range fields[1:] {
+				if n, err := strconv.Atoi(strings.TrimPrefix(v, "ndots:")); err == nil && strings.HasPrefix(v, "ndots:") {
+					conf.ndots = n
+				}
+			}
+		}
+	}
+
+	if conf.server == "" {
+		return nil, fmt.Errorf("no nameserver in %s", file)
+	}
+
+	return conf, nil
+}
+
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+
+	return name + "."
+}
+
+// minTTL returns the lowest ttl of the answers, zero if there is no answer.
+func minTTL(resources []dnsmessage.Resource) time.Duration {
+	var ttl uint32
+	for i, v := range resources {
+		if i == 0 || v.Header.TTL < ttl {
+			ttl = v.Header.TTL
+		}
+	}
+
+	return time.Duration(ttl) * time.Second
+}
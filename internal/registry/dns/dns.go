@@ -0,0 +1,487 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/KKKKjl/tinykit/internal/registry"
+	"github.com/KKKKjl/tinykit/logger"
+)
+
+const (
+	_defaultScheme   = "http"
+	_defaultInterval = 30 * time.Second
+	_defaultTimeout  = 2 * time.Second
+
+	// bounds of the wait before resolving again
+	_minInterval   = time.Second
+	_retryInterval = 5 * time.Second
+)
+
+var (
+	log     = logger.GetLogger()
+	mainLog = log.WithField("prefix", "dns")
+)
+
+var _ registry.Resolver = (*DNSDiscovery)(nil)
+
+type (
+	// Config of the dns discovery, Port is the port of the A/AAAA names without one.
+	// A name is resolved again after the lowest ttl of its records, at most after Interval.
+	// The relative names, e.g. greeter.default, are completed by the Search domains like the system
+	// resolver, the names with at least Ndots dots are tried as is first.
+	Config struct {
+		Server   string   // host:port, defaults to the first nameserver of /etc/resolv.conf
+		Search   []string // defaults to the search domains of /etc/resolv.conf with the default server
+		Ndots    int      // defaults to the ndots of /etc/resolv.conf with the default server, else 1
+		Scheme   string
+		Port     int
+		Interval time.Duration
+		Timeout  time.Duration
+	}
+
+	// DNSDiscovery resolves the services by dns, the name of a service is either a host name with an optional
+	// port, e.g. greeter.default.svc.cluster.local:8080, resolved by A/AAAA records, or a SRV name starting
+	// with an underscore, e.g. _http._tcp.greeter.default.svc.cluster.local.
+	DNSDiscovery struct {
+		conf    Config
+		client  *client
+		mu      sync.Mutex
+		targets map[string]*Target // name -> target
+		done    chan struct{}
+	}
+
+	// Target is the builder of the services of a dns name, the last resolved services are kept on failure.
+	// It is resolved until released by all the users it was returned to by Resolve.
+	Target struct {
+		discovery *DNSDiscovery
+		name      string
+		refs      int           // guarded by the mutex of the discovery
+		ready     chan struct{} // closed once resolved the first time
+		stop      chan struct{}
+		mu        sync.RWMutex
+		services  []*registry.Service
+	}
+)
+
+func New(c Config) (*DNSDiscovery, error) {
+	if c.Server == "" {
+		conf, err := readResolvConf(_resolvConf)
+		if err != nil {
+			return nil, err
+		}
+		c.Server = conf.server
+
+		if c.Search == nil {
+			c.Search = conf.search
+		}
+
+		if c.Ndots <= 0 {
+			c.Ndots = conf.ndots
+		}
+	}
+
+	if c.Ndots <= 0 {
+		c.Ndots = _defaultNdots
+	}
+
+	if c.Scheme == "" {
+		c.Scheme = _defaultScheme
+	}
+
+	if c.Interval <= 0 {
+		c.Interval = _defaultInterval
+	}
+
+	if c.Timeout <= 0 {
+		c.Timeout = _defaultTimeout
+	}
+
+	return &DNSDiscovery{
+		conf:    c,
+		client:  &client{server: c.Server, search: c.Search, ndots: c.Ndots},
+		targets: make(map[string]*Target),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Resolve returns the builder of the name, the name is resolved once before returning and then in the background
+// until the builder is released. Dns records have no version, the version is ignored.
+func (d *DNSDiscovery) Resolve(name, version string) registry.Builder {
+	d.mu.Lock()
+	t, ok := d.targets[name]
+	if !ok {
+		if version != "" {
+			mainLog.Warnf("Version %s of %s is ignored by dns discovery.", version, name)
+		}
+
+		t = &Target{discovery: d, name: name, ready: make(chan struct{}), stop: make(chan struct{})}
+		d.targets[name] = t
+	}
+	t.refs++
+	d.mu.Unlock()
+
+	// resolved without holding the lock, the other callers of the name wait for the first resolution
+	if !ok {
+		wait := t.refresh()
+		close(t.ready)
+
+		go t.run(wait)
+	}
+	<-t.ready
+
+	return t
+}
+
+// GetService returns the services of all the resolved names.
+func (d *DNSDiscovery) GetService() ([]*registry.Service, error) {
+	return d.ListServer(), nil
+}
+
+func (d *DNSDiscovery) ListServer() []*registry.Service {
+	d.mu.Lock()
+	names := make([]string, 0, len(d.targets))
+	for k := range d.targets {
+		names = append(names, k)
+	}
+	d.mu.Unlock()
+	sort.Strings(names)
+
+	var services []*registry.Service
+	for _, v := range names {
+		// released meanwhile
+		if t := d.target(v); t != nil {
+			services = append(services, t.ListServer()...)
+		}
+	}
+
+	return services
+}
+
+func (d *DNSDiscovery) PutServer(service *registry.Service) {
+	if t := d.target(service.Name); t != nil {
+		t.PutServer(service)
+	}
+}
+
+func (d *DNSDiscovery) DelServer(service *registry.Service) {
+	if t := d.target(service.Name); t != nil {
+		t.DelServer(service)
+	}
+}
+
+func (d *DNSDiscovery) target(name string) *Target {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.targets[name]
+}
+
+func (d *DNSDiscovery) Scheme() string {
+	return "dns"
+}
+
+// Close stops resolving the names.
+func (d *DNSDiscovery) Close() {
+	close(d.done)
+}
+
+func (t *Target) run(wait time.Duration) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			timer.Reset(t.refresh())
+		case <-t.stop:
+			return
+		case <-t.discovery.done:
+			return
+		}
+	}
+}
+
+// Release stops resolving the name once all the users returned the target by Resolve released it.
+func (t *Target) Release() {
+	d := t.discovery
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	t.refs--
+	if t.refs > 0 {
+		return
+	}
+
+	if d.targets[t.name] == t {
+		delete(d.targets, t.name)
+	}
+	close(t.stop)
+}
+
+// refresh resolves the name and returns the wait before the next resolution.
+func (t *Target) refresh() time.Duration {
+	conf := t.discovery.conf
+
+	ctx, cancel := context.WithTimeout(context.Background(), conf.Timeout)
+	defer cancel()
+
+	services, ttl, err := t.resolve(ctx)
+	if err != nil {
+		mainLog.Errorf("Resolve %s failed, keep %d services: %v", t.name, len(t.ListServer()), err)
+
+		if conf.Interval < _retryInterval {
+			return conf.Interval
+		}
+		return _retryInterval
+	}
+
+	t.mu.Lock()
+	t.services = services
+	t.mu.Unlock()
+
+	switch {
+	case ttl <= 0 || ttl > conf.Interval:
+		return conf.Interval
+	case ttl < _minInterval:
+		return _minInterval
+	default:
+		return ttl
+	}
+}
+
+// resolve returns the services of the name sorted by addr and the lowest ttl of the records.
+func (t *Target) resolve(ctx context.Context) ([]*registry.Service, time.Duration, error) {
+	conf := t.discovery.conf
+
+	if strings.HasPrefix(t.name, "_") {
+		return t.resolveSRV(ctx)
+	}
+
+	host, port := t.name, conf.Port
+	if h, p, err := net.SplitHostPort(t.name); err == nil {
+		host = h
+		port, _ = strconv.Atoi(p)
+	}
+
+	ips, ttl, err := t.discovery.lookupHost(ctx, host, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	services := make([]*registry.Service, 0, len(ips))
+	for _, ip := range ips {
+		services = append(services, t.service(ip, port, 1, nil))
+	}
+
+	sortServices(services)
+	return services, ttl, nil
+}
+
+// resolveSRV returns the services of the targets with the lowest priority weighted by the srv weight,
+// the targets are resolved from the additional records or by A/AAAA queries.
+func (t *Target) resolveSRV(ctx context.Context) ([]*registry.Service, time.Duration, error) {
+	resp, err := t.discovery.client.exchange(ctx, t.name, dnsmessage.TypeSRV)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var records []*dnsmessage.SRVResource
+	for _, v := range resp.Answers {
+		if srv, ok := v.Body.(*dnsmessage.SRVResource); ok {
+			records = append(records, srv)
+		}
+	}
+
+	if len(records) == 0 {
+		return nil, 0, NoRecordsErr
+	}
+
+	// the targets of a higher priority are only used if the lower ones are unreachable
+	priority := records[0].Priority
+	for _, v := range records {
+		if v.Priority < priority {
+			priority = v.Priority
+		}
+	}
+
+	ttl := minTTL(resp.Answers)
+
+	var services []*registry.Service
+	for _, v := range records {
+		if v.Priority != priority {
+			continue
+		}
+
+		ips, hostTTL, err := t.discovery.lookupHost(ctx, v.Target.String(), resp.Additionals)
+		if err != nil {
+			mainLog.Errorf("Resolve target %s of %s failed: %v", v.Target, t.name, err)
+			continue
+		}
+
+		if hostTTL > 0 && hostTTL < ttl {
+			ttl = hostTTL
+		}
+
+		// a zero weight is the lowest one
+		weight := int(v.Weight)
+		if weight == 0 {
+			weight = 1
+		}
+
+		metadata := map[string]string{
+			"target":   strings.TrimSuffix(v.Target.String(), "."),
+			"priority": strconv.Itoa(int(v.Priority)),
+		}
+
+		for _, ip := range ips {
+			services = append(services, t.service(ip, int(v.Port), weight, metadata))
+		}
+	}
+
+	if len(services) == 0 {
+		return nil, 0, NoRecordsErr
+	}
+
+	sortServices(services)
+	return services, ttl, nil
+}
+
+func (t *Target) service(ip net.IP, port int, weight int, metadata map[string]string) *registry.Service {
+	host := ip.String()
+	if port > 0 {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	} else if ip.To4() == nil {
+		host = "[" + host + "]"
+	}
+
+	return &registry.Service{
+		Name:     t.name,
+		Addr:     t.discovery.conf.Scheme + "://" + host,
+		Weight:   weight,
+		Metadata: metadata,
+	}
+}
+
+// lookupHost returns the A and AAAA addresses of the host and their lowest ttl,
+// the additional records of a SRV response are used if they contain the host.
+func (d *DNSDiscovery) lookupHost(ctx context.Context, host string, additionals []dnsmessage.Resource) ([]net.IP, time.Duration, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, 0, nil
+	}
+
+	if ips, ttl := addresses(fqdn(host), additionals); len(ips) > 0 {
+		return ips, ttl, nil
+	}
+
+	var (
+		ips     []net.IP
+		answers []dnsmessage.Resource
+		lastErr error
+	)
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		resp, err := d.client.exchange(ctx, host, qtype)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		answers = append(answers, resp.Answers...)
+	}
+
+	ips, ttl := addresses("", answers)
+	if len(ips) == 0 {
+		if lastErr != nil {
+			return nil, 0, lastErr
+		}
+		return nil, 0, NoRecordsErr
+	}
+
+	return ips, ttl, nil
+}
+
+// addresses returns the A and AAAA addresses of the resources of the name and their lowest ttl, empty name means any.
+func addresses(name string, resources []dnsmessage.Resource) ([]net.IP, time.Duration) {
+	var (
+		ips     []net.IP
+		matched []dnsmessage.Resource
+	)
+	for _, v := range resources {
+		if name != "" && !strings.EqualFold(v.Header.Name.String(), name) {
+			continue
+		}
+
+		switch body := v.Body.(type) {
+		case *dnsmessage.AResource:
+			ips = append(ips, net.IP(body.A[:]))
+		case *dnsmessage.AAAAResource:
+			ips = append(ips, net.IP(body.AAAA[:]))
+		default:
+			continue
+		}
+		matched = append(matched, v)
+	}
+
+	return ips, minTTL(matched)
+}
+
+func (t *Target) GetService() ([]*registry.Service, error) {
+	return t.ListServer(), nil
+}
+
+// PutServer adds a service until the next resolution.
+func (t *Target) PutServer(service *registry.Service) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, v := range t.services {
+		if v.Addr == service.Addr {
+			t.services[i] = service
+			return
+		}
+	}
+
+	// copy on write, the returned slices are shared
+	t.services = append(t.services[:len(t.services):len(t.services)], service)
+}
+
+// DelServer removes a service until the next resolution.
+func (t *Target) DelServer(service *registry.Service) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, v := range t.services {
+		if v.Addr == service.Addr {
+			t.services = append(t.services[:i:i], t.services[i+1:]...)
+			return
+		}
+	}
+}
+
+func (t *Target) ListServer() []*registry.Service {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	services := make([]*registry.Service, len(t.services))
+	copy(services, t.services)
+
+	return services
+}
+
+func (t *Target) Scheme() string {
+	return "dns"
+}
+
+func sortServices(services []*registry.Service) {
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Addr < services[j].Addr
+	})
+}
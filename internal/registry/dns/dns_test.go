@@ -0,0 +1,343 @@
+package dns
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/KKKKjl/tinykit/internal/registry"
+)
+
+type record struct {
+	name  string
+	qtype dnsmessage.Type
+	ttl   uint32
+	body  dnsmessage.ResourceBody
+}
+
+// stub is a dns server answering from its records over udp and tcp.
+type stub struct {
+	mu        sync.Mutex
+	records   []record
+	fail      bool
+	truncated bool // udp responses are truncated
+	udp       net.PacketConn
+	tcp       net.Listener
+}
+
+func newStub(t *testing.T, records ...record) *stub {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		udp.Close()
+		t.Skip("tcp port taken:", err)
+	}
+
+	s := &stub{records: records, udp: udp, tcp: tcp}
+	go s.serveUDP()
+	go s.serveTCP()
+
+	t.Cleanup(func() {
+		udp.Close()
+		tcp.Close()
+	})
+
+	return s
+}
+
+func (s *stub) set(fail bool, records ...record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fail = fail
+	if !fail {
+		s.records = records
+	}
+}
+
+func (s *stub) serveUDP() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		s.udp.WriteTo(s.answer(buf[:n], true), addr)
+	}
+}
+
+func (s *stub) serveTCP() {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+
+			buf := make([]byte, 512)
+			if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+				return
+			}
+
+			n := int(binary.BigEndian.Uint16(buf[:2]))
+			if _, err := io.ReadFull(conn, buf[:n]); err != nil {
+				return
+			}
+
+			resp := s.answer(buf[:n], false)
+			length := make([]byte, 2)
+			binary.BigEndian.PutUint16(length, uint16(len(resp)))
+			conn.Write(append(length, resp...))
+		}()
+	}
+}
+
+func (s *stub) answer(packet []byte, udp bool) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var query dnsmessage.Message
+	if err := query.Unpack(packet); err != nil {
+		return nil
+	}
+
+	resp := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: query.ID, Response: true},
+		Questions: query.Questions,
+	}
+
+	switch {
+	case s.fail:
+		resp.RCode = dnsmessage.RCodeServerFailure
+	case s.truncated && udp:
+		resp.Truncated = true
+	default:
+		q := query.Questions[0]
+		for _, v := range s.records {
+			rr := dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(v.name), Class: dnsmessage.ClassINET, TTL: v.ttl},
+				Body:   v.body,
+			}
+
+			switch {
+			case v.name == q.Name.String() && v.qtype == q.Type:
+				resp.Answers = append(resp.Answers, rr)
+			case q.Type == dnsmessage.TypeSRV && v.qtype == dnsmessage.TypeA:
+				// addresses of the srv targets
+				resp.Additionals = append(resp.Additionals, rr)
+			}
+		}
+	}
+
+	b, _ := resp.Pack()
+	return b
+}
+
+func a(name string, ip string, ttl uint32) record {
+	var body dnsmessage.AResource
+	copy(body.A[:], net.ParseIP(ip).To4())
+	return record{name: name, qtype: dnsmessage.TypeA, ttl: ttl, body: &body}
+}
+
+func aaaa(name string, ip string, ttl uint32) record {
+	var body dnsmessage.AAAAResource
+	copy(body.AAAA[:], net.ParseIP(ip))
+	return record{name: name, qtype: dnsmessage.TypeAAAA, ttl: ttl, body: &body}
+}
+
+func srv(name string, target string, port, priority, weight uint16) record {
+	return record{name: name, qtype: dnsmessage.TypeSRV, ttl: 60, body: &dnsmessage.SRVResource{
+		Target:   dnsmessage.MustNewName(target),
+		Port:     port,
+		Priority: priority,
+		Weight:   weight,
+	}}
+}
+
+func addrs(services []*registry.Service) []string {
+	addrs := make([]string, 0, len(services))
+	for _, v := range services {
+		addrs = append(addrs, v.Addr)
+	}
+
+	return addrs
+}
+
+func TestResolveHost(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newStub(t,
+		a("greeter.local.", "10.0.0.2", 60),
+		a("greeter.local.", "10.0.0.1", 5),
+		aaaa("greeter.local.", "fd00::1", 60),
+	)
+
+	d, err := New(Config{Server: s.udp.LocalAddr().String(), Port: 8080})
+	assert.Nil(err)
+	defer d.Close()
+
+	target := d.Resolve("greeter.local", "").(*Target)
+	assert.Equal([]string{"http://10.0.0.1:8080", "http://10.0.0.2:8080", "http://[fd00::1]:8080"}, addrs(target.ListServer()))
+	assert.Equal("greeter.local", target.ListServer()[0].Name)
+
+	// the lowest ttl
+	assert.Equal(5*time.Second, target.refresh())
+
+	// the port of the name
+	target = d.Resolve("greeter.local:9000", "").(*Target)
+	assert.Equal("http://10.0.0.1:9000", target.ListServer()[0].Addr)
+
+	// the same target is returned
+	assert.Equal(target, d.Resolve("greeter.local:9000", ""))
+	assert.Len(d.ListServer(), 6)
+
+	// the last services are kept on failure
+	s.set(true)
+	assert.Equal(_retryInterval, target.refresh())
+	assert.Len(target.ListServer(), 3)
+
+	s.set(false, a("greeter.local.", "10.0.0.3", 600))
+	assert.Equal(_defaultInterval, target.refresh())
+	assert.Equal([]string{"http://10.0.0.3:9000"}, addrs(target.ListServer()))
+}
+
+func TestResolveSRV(t *testing.T) {
+	assert := assert.New(t)
+
+	const name = "_http._tcp.greeter.local."
+	s := newStub(t,
+		srv(name, "a.greeter.local.", 8081, 10, 3),
+		srv(name, "b.greeter.local.", 8082, 10, 0),
+		srv(name, "backup.greeter.local.", 8083, 20, 1),
+		a("a.greeter.local.", "10.0.0.1", 30),
+		a("b.greeter.local.", "10.0.0.2", 30),
+	)
+
+	d, err := New(Config{Server: s.udp.LocalAddr().String(), Scheme: "https"})
+	assert.Nil(err)
+	defer d.Close()
+
+	target := d.Resolve(name, "v1").(*Target)
+	services := target.ListServer()
+	assert.Equal([]string{"https://10.0.0.1:8081", "https://10.0.0.2:8082"}, addrs(services))
+	assert.Equal(3, services[0].Weight)
+	assert.Equal(1, services[1].Weight)
+	assert.Equal("a.greeter.local", services[0].Metadata["target"])
+	assert.Equal("10", services[0].Metadata["priority"])
+
+	// falls back to tcp
+	s.mu.Lock()
+	s.truncated = true
+	s.mu.Unlock()
+
+	target.DelServer(services[0])
+	assert.Len(target.ListServer(), 1)
+	assert.Equal(30*time.Second, target.refresh())
+	assert.Len(target.ListServer(), 2)
+}
+
+func TestRefresh(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newStub(t, a("greeter.local.", "10.0.0.1", 1))
+
+	d, err := New(Config{Server: s.udp.LocalAddr().String(), Port: 80})
+	assert.Nil(err)
+	defer d.Close()
+
+	target := d.Resolve("greeter.local", "")
+	s.set(false, a("greeter.local.", "10.0.0.2", 1))
+
+	assert.Eventually(func() bool {
+		services := target.ListServer()
+		return len(services) == 1 && services[0].Addr == "http://10.0.0.2:80"
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+func TestSearch(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newStub(t,
+		a("greeter.default.svc.cluster.local.", "10.0.0.1", 60),
+		a("greeter.default.", "10.0.0.2", 60),
+	)
+
+	d, err := New(Config{Server: s.udp.LocalAddr().String(), Search: []string{"svc.cluster.local", "cluster.local"}, Ndots: 5, Port: 80})
+	assert.Nil(err)
+	defer d.Close()
+
+	// fewer dots than ndots, the search domains first
+	assert.Equal([]string{"http://10.0.0.1:80"}, addrs(d.Resolve("greeter.default", "").ListServer()))
+
+	// the absolute names are not completed
+	assert.Equal([]string{"http://10.0.0.2:80"}, addrs(d.Resolve("greeter.default.", "").ListServer()))
+
+	c := &client{search: []string{"svc.cluster.local"}, ndots: 1}
+	assert.Equal([]string{"greeter.default", "greeter.default.svc.cluster.local"}, c.candidates("greeter.default"))
+	assert.Equal([]string{"greeter.svc.cluster.local", "greeter"}, c.candidates("greeter"))
+}
+
+func TestReadResolvConf(t *testing.T) {
+	assert := assert.New(t)
+
+	file := filepath.Join(t.TempDir(), "resolv.conf")
+	os.WriteFile(file, []byte(`# kubernetes
+nameserver 10.96.0.10
+nameserver 10.96.0.11
+search default.svc.cluster.local svc.cluster.local cluster.local
+options ndots:5 timeout:2
+`), 0o644)
+
+	conf, err := readResolvConf(file)
+	assert.Nil(err)
+	assert.Equal("10.96.0.10:53", conf.server)
+	assert.Equal([]string{"default.svc.cluster.local", "svc.cluster.local", "cluster.local"}, conf.search)
+	assert.Equal(5, conf.ndots)
+
+	os.WriteFile(file, []byte("search local\n"), 0o644)
+	_, err = readResolvConf(file)
+	assert.NotNil(err)
+}
+
+func TestRelease(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newStub(t, a("greeter.local.", "10.0.0.1", 1))
+
+	d, err := New(Config{Server: s.udp.LocalAddr().String(), Port: 80})
+	assert.Nil(err)
+	defer d.Close()
+
+	first := d.Resolve("greeter.local", "").(*Target)
+	second := d.Resolve("greeter.local", "").(*Target)
+	assert.Equal(first, second)
+
+	// still used by the second route
+	first.Release()
+	assert.Len(d.ListServer(), 1)
+	select {
+	case <-first.stop:
+		t.Fatal("target stopped while in use")
+	default:
+	}
+
+	second.Release()
+	assert.Empty(d.ListServer())
+	<-first.stop
+
+	// resolved again by a new route
+	assert.NotEqual(first, d.Resolve("greeter.local", ""))
+}
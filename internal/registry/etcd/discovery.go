@@ -2,16 +2,22 @@ package etcd
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	clientv3 "go.etcd.io/etcd/client/v3"
 
 	"github.com/KKKKjl/tinykit/internal/registry"
+	"github.com/KKKKjl/tinykit/internal/registry/memory"
 	"github.com/KKKKjl/tinykit/logger"
 )
 
+const _defaultPrefix = "/discovery/"
+
 var (
 	log     = logger.GetLogger()
 	mainLog = log.WithField("prefix", "discovery")
@@ -19,118 +25,193 @@ var (
 
 var (
 	once              sync.Once
-	_defaultDiscovery *EtcdDiscovery
+	_defaultDiscovery registry.Builder
 )
 
+// Config of the etcd client shared by the discovery and the registrar, TLS and the credentials are optional.
 type Config struct {
 	Nodes       []string
 	DialTimeout time.Duration
 	Prefix      string
+	Username    string
+	Password    string
+	TLS         *tls.Config
+}
+
+// withDefault returns a copy of the config with the defaults of the unset fields.
+func (c *Config) withDefault() *Config {
+	conf := Config{}
+	if c != nil {
+		conf = *c
+	}
+
+	if len(conf.Nodes) == 0 {
+		conf.Nodes = []string{"localhost:2379"}
+	}
+
+	if conf.DialTimeout <= 0 {
+		conf.DialTimeout = time.Second * 3
+	}
+
+	if conf.Prefix == "" {
+		conf.Prefix = _defaultPrefix
+	}
+
+	return &conf
 }
 
+// newClient creates the client, it connects in the background.
+func newClient(c *Config) (*clientv3.Client, error) {
+	return clientv3.New(clientv3.Config{
+		Endpoints:   c.Nodes,
+		DialTimeout: c.DialTimeout,
+		Username:    c.Username,
+		Password:    c.Password,
+		TLS:         c.TLS,
+	})
+}
+
+// EtcdDiscovery serves the services registered under the prefix from a cache kept up to date
+// by watching the prefix, the instances are keyed by <prefix><name>/<addr>. The prefix is only read
+// again when the watch fails, e.g. the watched revision is compacted.
 type EtcdDiscovery struct {
 	client         *clientv3.Client
-	service        map[string]*registry.Service
+	service        map[string]*registry.Service   // etcd key -> service
+	names          map[string][]*registry.Service // name -> services sorted by key
+	all            []*registry.Service            // services sorted by key
 	mu             sync.RWMutex
-	updateInterval time.Duration
+	updateInterval time.Duration // retry interval of a failed watch
+	dialTimeout    time.Duration
 	done           chan struct{}
 	prefix         string
 }
 
 func initDefault() {
 	once.Do(func() {
-		_defaultDiscovery = New(nil)
+		discovery, err := New(nil)
+		if err != nil {
+			mainLog.Errorf("Create etcd discovery error, no service is discovered: %v", err)
+			_defaultDiscovery = memory.New().Builder()
+			return
+		}
+
+		_defaultDiscovery = discovery
 	})
 }
 
+// Builder returns the discovery of the local etcd, an empty discovery if the client can not be created.
 func Builder() registry.Builder {
-	if _defaultDiscovery == nil {
-		initDefault()
-	}
+	initDefault()
 	return _defaultDiscovery
 }
 
-func New(c *Config) *EtcdDiscovery {
-	if c == nil {
-		c = &Config{
-			Nodes:       []string{"localhost:2379"},
-			DialTimeout: time.Second * 3,
-			Prefix:      "/discovery/",
-		}
-	}
+// New creates the discovery, the client connects in the background and the services are synced once connected.
+func New(c *Config) (*EtcdDiscovery, error) {
+	c = c.withDefault()
 
-	cli, err := clientv3.New(clientv3.Config{
-		Endpoints:   c.Nodes,
-		DialTimeout: c.DialTimeout,
-	})
+	cli, err := newClient(c)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	discovery := &EtcdDiscovery{
 		service:        make(map[string]*registry.Service),
 		client:         cli,
 		updateInterval: time.Second * 3,
+		dialTimeout:    c.DialTimeout,
 		done:           make(chan struct{}),
 		prefix:         c.Prefix,
 	}
 
-	go discovery.watch()
+	// load the services before serving, the watch retries on failure
+	rev, err := discovery.sync()
+	if err != nil {
+		mainLog.Errorf("sync servers error: %s", err)
+	}
+
+	go discovery.watch(rev)
 
-	return discovery
+	return discovery, nil
 }
 
+// Key returns the etcd key of a service instance.
+func Key(prefix string, service *registry.Service) string {
+	return prefix + service.Name + "/" + service.Addr
+}
+
+// GetService returns the cached services sorted by key.
 func (e *EtcdDiscovery) GetService() ([]*registry.Service, error) {
-	servers := make([]*registry.Service, 0)
+	return e.ListServer(), nil
+}
 
-	resp, err := e.client.Get(context.TODO(), e.prefix, clientv3.WithPrefix())
-	if err != nil {
-		return servers, err
+// Resolve returns a builder serving the instances of the named service.
+func (e *EtcdDiscovery) Resolve(name, version string) registry.Builder {
+	return &serviceView{discovery: e, name: name, version: version}
+}
+
+// instances returns the cached services of the name and version sorted by key.
+func (e *EtcdDiscovery) instances(name, version string) []*registry.Service {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	indexed := e.all
+	if name != "" {
+		indexed = e.names[name]
 	}
 
-	for _, v := range resp.Kvs {
-		var service registry.Service
-		if err := json.Unmarshal(v.Value, &service); err != nil {
-			continue
+	servers := make([]*registry.Service, 0, len(indexed))
+	for _, v := range indexed {
+		if version == "" || v.Version == version {
+			servers = append(servers, v)
 		}
+	}
 
-		e.PutServer(&service)
-		servers = append(servers, &service)
+	return servers
+}
+
+// reindex sorts the cached services by key after a change, mu must be held.
+func (e *EtcdDiscovery) reindex() {
+	keys := make([]string, 0, len(e.service))
+	for k := range e.service {
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
 
-	return servers, nil
+	e.all = make([]*registry.Service, 0, len(keys))
+	e.names = make(map[string][]*registry.Service)
+	for _, k := range keys {
+		service := e.service[k]
+
+		e.all = append(e.all, service)
+		e.names[service.Name] = append(e.names[service.Name], service)
+	}
 }
 
 func (e *EtcdDiscovery) PutServer(service *registry.Service) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	if _, ok := e.service[service.Name]; !ok {
-		e.service[service.Name] = service
-	}
+	e.service[Key(e.prefix, service)] = service
+	e.reindex()
 }
 
 func (e *EtcdDiscovery) DelServer(service *registry.Service) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	delete(e.service, service.Name)
+	delete(e.service, Key(e.prefix, service))
+	e.reindex()
 }
 
 func (e *EtcdDiscovery) ListServer() []*registry.Service {
-	e.mu.RLock()
-	defer e.mu.RUnlock()
-
-	servers := make([]*registry.Service, 0, len(e.service))
-	for _, v := range e.service {
-		servers = append(servers, v)
-	}
-
-	return servers
+	return e.instances("", "")
 }
 
-func (e *EtcdDiscovery) watch() {
-	wch := e.client.Watch(context.TODO(), e.prefix, clientv3.WithPrefix())
+func (e *EtcdDiscovery) watch(rev int64) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wch := e.newWatch(ctx, rev)
 
 	ticker := time.NewTicker(e.updateInterval)
 	defer ticker.Stop()
@@ -138,15 +219,33 @@ func (e *EtcdDiscovery) watch() {
 	for {
 		select {
 		case res, ok := <-wch:
-			if ok {
-				if err := e.eventCallback(res.Events); err != nil {
-					mainLog.Errorf("watch update callback error: %s", err)
+			if !ok || res.Err() != nil {
+				// e.g. the revision is compacted, reload and watch again
+				mainLog.Errorf("watch servers closed: %v", res.Err())
+
+				if rev, err := e.sync(); err == nil {
+					wch = e.newWatch(ctx, rev)
+				} else {
+					mainLog.Errorf("sync servers error: %s", err)
+					wch = nil
 				}
+				continue
 			}
+
+			e.eventCallback(res.Events)
 		case <-ticker.C:
-			if err := e.sync(); err != nil {
+			// the watch keeps the cache up to date, a full read would race with its events
+			if wch != nil {
+				continue
+			}
+
+			rev, err := e.sync()
+			if err != nil {
 				mainLog.Errorf("sync servers error: %s", err)
+				continue
 			}
+
+			wch = e.newWatch(ctx, rev)
 		case <-e.done:
 			if err := e.client.Close(); err != nil {
 				mainLog.Errorf("close etcd client error: %s", err)
@@ -156,45 +255,87 @@ func (e *EtcdDiscovery) watch() {
 	}
 }
 
-func (e *EtcdDiscovery) eventCallback(events []*clientv3.Event) error {
+// newWatch watches the changes after rev, zero rev means the changes from now.
+func (e *EtcdDiscovery) newWatch(ctx context.Context, rev int64) clientv3.WatchChan {
+	opts := []clientv3.OpOption{clientv3.WithPrefix()}
+	if rev > 0 {
+		opts = append(opts, clientv3.WithRev(rev+1))
+	}
+
+	return e.client.Watch(clientv3.WithRequireLeader(ctx), e.prefix, opts...)
+}
+
+func (e *EtcdDiscovery) eventCallback(events []*clientv3.Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
 	for _, event := range events {
-		var service registry.Service
-		if err := json.Unmarshal(event.Kv.Value, &service); err != nil {
-			mainLog.Error("Fail to unmarshal:", err)
-			continue
-		}
+		key := string(event.Kv.Key)
 
 		switch event.Type {
 		case clientv3.EventTypePut:
-			e.PutServer(&service)
-			mainLog.Infof("Watch put server change: %s", service.Name)
+			service, err := parse(e.prefix, key, event.Kv.Value)
+			if err != nil {
+				mainLog.Errorf("Fail to unmarshal %s: %v", key, err)
+				continue
+			}
+
+			e.service[key] = service
+			mainLog.Infof("Watch put server change: %s(%s)", service.Name, service.Addr)
 
 		case clientv3.EventTypeDelete:
-			e.DelServer(&service)
-			mainLog.Infof("Watch del server change: %s", service.Name)
+			// delete events carry the key only
+			delete(e.service, key)
+			mainLog.Infof("Watch del server change: %s", key)
 		}
 	}
 
-	return nil
+	e.reindex()
 }
 
-func (e *EtcdDiscovery) sync() error {
-	resp, err := e.client.Get(context.TODO(), e.prefix, clientv3.WithPrefix())
+// sync replaces the cache with the registered services and returns the revision read.
+func (e *EtcdDiscovery) sync() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.dialTimeout)
+	defer cancel()
+
+	resp, err := e.client.Get(ctx, e.prefix, clientv3.WithPrefix())
 	if err != nil {
-		return err
+		return 0, err
 	}
 
+	services := make(map[string]*registry.Service, len(resp.Kvs))
 	for _, v := range resp.Kvs {
-		var service registry.Service
-		if err := json.Unmarshal(v.Value, &service); err != nil {
-			mainLog.Error("Fail to unmarshal:", err)
+		service, err := parse(e.prefix, string(v.Key), v.Value)
+		if err != nil {
+			mainLog.Errorf("Fail to unmarshal %s: %v", v.Key, err)
 			continue
 		}
 
-		e.PutServer(&service)
+		services[string(v.Key)] = service
 	}
 
-	return nil
+	e.mu.Lock()
+	e.service = services
+	e.reindex()
+	e.mu.Unlock()
+
+	return resp.Header.Revision, nil
+}
+
+// parse decodes a registered service, its name is the first segment of the key after the prefix.
+func parse(prefix, key string, value []byte) (*registry.Service, error) {
+	var service registry.Service
+	if err := json.Unmarshal(value, &service); err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimPrefix(key, prefix)
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		name = name[:i]
+	}
+	service.Name = name
+
+	return &service, nil
 }
 
 func (r *EtcdDiscovery) Scheme() string {
@@ -204,3 +345,30 @@ func (r *EtcdDiscovery) Scheme() string {
 func (e *EtcdDiscovery) Close() {
 	e.done <- struct{}{}
 }
+
+// serviceView is the builder of a named service of the discovery.
+type serviceView struct {
+	discovery *EtcdDiscovery
+	name      string
+	version   string
+}
+
+func (s *serviceView) GetService() ([]*registry.Service, error) {
+	return s.ListServer(), nil
+}
+
+func (s *serviceView) PutServer(service *registry.Service) {
+	s.discovery.PutServer(service)
+}
+
+func (s *serviceView) ListServer() []*registry.Service {
+	return s.discovery.instances(s.name, s.version)
+}
+
+func (s *serviceView) DelServer(service *registry.Service) {
+	s.discovery.DelServer(service)
+}
+
+func (s *serviceView) Scheme() string {
+	return "etcd"
+}
@@ -1 +1,177 @@
 package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/KKKKjl/tinykit/internal/registry"
+)
+
+func newDiscovery() *EtcdDiscovery {
+	return &EtcdDiscovery{
+		service: make(map[string]*registry.Service),
+		prefix:  _defaultPrefix,
+	}
+}
+
+func put(service *registry.Service) *clientv3.Event {
+	buf, _ := json.Marshal(service)
+	return &clientv3.Event{
+		Type: clientv3.EventTypePut,
+		Kv:   &mvccpb.KeyValue{Key: []byte(Key(_defaultPrefix, service)), Value: buf},
+	}
+}
+
+func del(key string) *clientv3.Event {
+	return &clientv3.Event{
+		Type: clientv3.EventTypeDelete,
+		Kv:   &mvccpb.KeyValue{Key: []byte(key)},
+	}
+}
+
+func TestEventCallback(t *testing.T) {
+	assert := assert.New(t)
+
+	discovery := newDiscovery()
+	discovery.eventCallback([]*clientv3.Event{
+		put(&registry.Service{Name: "greeter", Version: "v1", Addr: "http://localhost:8081"}),
+		put(&registry.Service{Name: "greeter", Version: "v2", Addr: "http://localhost:8080"}),
+		put(&registry.Service{Name: "user", Version: "v1", Addr: "http://localhost:8082"}),
+	})
+
+	services, err := discovery.GetService()
+	assert.Nil(err)
+	assert.Len(services, 3)
+
+	greeter := discovery.Resolve("greeter", "")
+	services, _ = greeter.GetService()
+	if assert.Len(services, 2) {
+		assert.Equal("http://localhost:8080", services[0].Addr)
+		assert.Equal("http://localhost:8081", services[1].Addr)
+	}
+
+	services, _ = discovery.Resolve("greeter", "v1").GetService()
+	if assert.Len(services, 1) {
+		assert.Equal("http://localhost:8081", services[0].Addr)
+	}
+
+	// delete events have no value
+	discovery.eventCallback([]*clientv3.Event{del("/discovery/greeter/http://localhost:8080")})
+	services, _ = greeter.GetService()
+	if assert.Len(services, 1) {
+		assert.Equal("http://localhost:8081", services[0].Addr)
+	}
+
+	// an update replaces the instance
+	discovery.eventCallback([]*clientv3.Event{put(&registry.Service{Name: "greeter", Version: "v1", Addr: "http://localhost:8081", Weight: 3})})
+	services, _ = greeter.GetService()
+	if assert.Len(services, 1) {
+		assert.Equal(3, services[0].Weight)
+	}
+
+	assert.Empty(discovery.Resolve("unknown", "").ListServer())
+}
+
+// fakeWatcher hands out the watch channels of the test.
+type fakeWatcher struct {
+	clientv3.Watcher
+	chans chan chan clientv3.WatchResponse
+}
+
+func (f *fakeWatcher) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	return <-f.chans
+}
+
+func (f *fakeWatcher) Close() error {
+	return nil
+}
+
+// countingKV counts the reads of the prefix.
+type countingKV struct {
+	*fakeKV
+	mu   sync.Mutex
+	gets int
+}
+
+func (c *countingKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	c.mu.Lock()
+	c.gets++
+	c.mu.Unlock()
+
+	resp, err := c.fakeKV.Get(ctx, key, opts...)
+	if err == nil {
+		resp.Header = &etcdserverpb.ResponseHeader{Revision: 2}
+	}
+
+	return resp, err
+}
+
+func (c *countingKV) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.gets
+}
+
+func TestWatch(t *testing.T) {
+	assert := assert.New(t)
+
+	kv := &countingKV{fakeKV: &fakeKV{data: make(map[string]string)}}
+	watcher := &fakeWatcher{chans: make(chan chan clientv3.WatchResponse, 2)}
+
+	client := clientv3.NewCtxClient(context.Background())
+	client.KV, client.Watcher = kv, watcher
+
+	discovery := newDiscovery()
+	discovery.client = client
+	discovery.updateInterval = 10 * time.Millisecond
+	discovery.dialTimeout = time.Second
+	discovery.done = make(chan struct{})
+
+	first := make(chan clientv3.WatchResponse, 1)
+	watcher.chans <- first
+	go discovery.watch(1)
+	defer discovery.Close()
+
+	first <- clientv3.WatchResponse{Events: []*clientv3.Event{put(&registry.Service{Name: "greeter", Addr: "http://localhost:8080"})}}
+	assert.Eventually(func() bool { return len(discovery.ListServer()) == 1 }, time.Second, time.Millisecond)
+
+	// no full read while the watch is up
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(0, kv.count())
+
+	// the watch failed, the prefix is read again and watched from the read revision
+	watcher.chans <- make(chan clientv3.WatchResponse)
+	close(first)
+
+	assert.Eventually(func() bool { return kv.count() == 1 }, time.Second, time.Millisecond)
+	assert.Eventually(func() bool { return len(discovery.ListServer()) == 0 }, time.Second, time.Millisecond)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(1, kv.count())
+}
+
+func TestParse(t *testing.T) {
+	assert := assert.New(t)
+
+	// the name is taken from the key, the legacy keys have no addr
+	service, err := parse(_defaultPrefix, "/discovery/node1", []byte(`{"name":"/discovery/node1","addr":"http://localhost:8090"}`))
+	assert.Nil(err)
+	assert.Equal("node1", service.Name)
+	assert.Equal("http://localhost:8090", service.Addr)
+
+	service, err = parse(_defaultPrefix, "/discovery/greeter/http://localhost:8090", []byte(`{"addr":"http://localhost:8090"}`))
+	assert.Nil(err)
+	assert.Equal("greeter", service.Name)
+
+	_, err = parse(_defaultPrefix, "/discovery/greeter/http://localhost:8090", nil)
+	assert.NotNil(err)
+}
@@ -3,7 +3,10 @@ package etcd
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	clientv3 "go.etcd.io/etcd/client/v3"
@@ -11,86 +14,322 @@ import (
 	"github.com/KKKKjl/tinykit/internal/registry"
 )
 
-type EtcdClient struct {
-	client *clientv3.Client
-	ctx    context.Context
-	cancel context.CancelFunc
+const (
+	_defaultTTL = 10 * time.Second
+
+	// backoff of granting a lost lease again
+	_minBackoff = 100 * time.Millisecond
+	_maxBackoff = 5 * time.Second
+)
+
+var (
+	NilServiceErr   = errors.New("Service is nil.")
+	EmptyNameErr    = errors.New("Service name is empty.")
+	EmptyAddressErr = errors.New("Service addr is empty.")
+	ClosedErr       = errors.New("Registrar is closed.")
+)
+
+var _ registry.Registry = (*Registrar)(nil)
+
+// Registrar registers the service instances under a lease kept alive in the background. If the lease is lost,
+// e.g. etcd restarted or the network was down for longer than the ttl, a new lease is granted and the
+// instances are put again. Close revokes the lease, so the instances are deregistered at once.
+type Registrar struct {
+	kv       clientv3.KV
+	lease    clientv3.Lease
+	closer   func() error
+	prefix   string
+	ttl      time.Duration
+	timeout  time.Duration
+	mu       sync.Mutex
+	services map[string]string // key -> value
+	leaseID  clientv3.LeaseID  // zero until granted
+	granted  chan struct{}     // signals the keepalive loop a lease is granted
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
 }
 
-// create a etcd client instance
-func NewEtcdClient() (*EtcdClient, error) {
-	cli, err := clientv3.New(clientv3.Config{
-		Endpoints:   []string{"localhost:2379"},
-		DialTimeout: time.Second * 3,
-	})
+// NewRegistrar creates a registrar, the instances live ttl after the last keepalive.
+func NewRegistrar(c *Config, ttl time.Duration) (*Registrar, error) {
+	c = c.withDefault()
+
+	cli, err := newClient(c)
 	if err != nil {
 		return nil, err
 	}
 
+	return newRegistrar(cli.KV, cli.Lease, cli.Close, c, ttl), nil
+}
+
+func newRegistrar(kv clientv3.KV, lease clientv3.Lease, closer func() error, c *Config, ttl time.Duration) *Registrar {
+	if ttl < time.Second {
+		ttl = _defaultTTL
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
-	res := &EtcdClient{
-		client: cli,
-		ctx:    ctx,
-		cancel: cancel,
+	r := &Registrar{
+		kv:       kv,
+		lease:    lease,
+		closer:   closer,
+		prefix:   c.Prefix,
+		ttl:      ttl,
+		timeout:  c.DialTimeout,
+		services: make(map[string]string),
+		granted:  make(chan struct{}, 1),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
 	}
 
-	return res, nil
+	go r.keepAlive()
+
+	return r
 }
 
-func (e *EtcdClient) putWithLease(key string, value string, ttl int64) error {
-	// create new lease
-	resp, err := e.client.Grant(context.TODO(), ttl)
+// Register puts the instance keyed by its name and addr, a registered instance is updated in place,
+// e.g. to change its weight or metadata.
+func (r *Registrar) Register(service *registry.Service) error {
+	if err := check(service); err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(service)
 	if err != nil {
 		return err
 	}
 
-	// bind renew id
-	if _, err = e.client.Put(context.TODO(), key, value, clientv3.WithLease(resp.ID)); err != nil {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ctx.Err() != nil {
+		return ClosedErr
+	}
+
+	if r.leaseID == 0 {
+		if err := r.grant(); err != nil {
+			return err
+		}
+	}
+
+	key := Key(r.prefix, service)
+	if err := r.put(key, string(buf)); err != nil {
 		return err
 	}
 
-	// renew
-	e.keepAlive(e.ctx, resp.ID)
+	r.services[key] = string(buf)
+	mainLog.Infof("Registered %s(%s) with lease %x.", service.Name, service.Addr, r.leaseID)
 
 	return nil
 }
 
-func (e *EtcdClient) Register(service *registry.Service, ttl int64) error {
-	if !strings.HasPrefix(service.Name, "/discovery/") {
-		service.Name = "/discovery/" + service.Name
+// Deregister deletes the instance keyed by its name and addr.
+func (r *Registrar) Deregister(service *registry.Service) error {
+	if err := check(service); err != nil {
+		return err
 	}
 
-	buf, err := json.Marshal(service)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := Key(r.prefix, service)
+	delete(r.services, key)
+
+	ctx, cancel := context.WithTimeout(r.ctx, r.timeout)
+	defer cancel()
+
+	_, err := r.kv.Delete(ctx, key)
+	return err
+}
+
+// GetService returns the registered instances of the name sorted by addr.
+func (r *Registrar) GetService(name string) ([]*registry.Service, error) {
+	return r.list(r.prefix + name + "/")
+}
+
+// ListServices returns all the registered instances sorted by key.
+func (r *Registrar) ListServices() ([]*registry.Service, error) {
+	return r.list(r.prefix)
+}
+
+func (r *Registrar) list(prefix string) ([]*registry.Service, error) {
+	ctx, cancel := context.WithTimeout(r.ctx, r.timeout)
+	defer cancel()
+
+	resp, err := r.kv.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	services := make([]*registry.Service, 0, len(resp.Kvs))
+	for _, v := range resp.Kvs {
+		service, err := parse(r.prefix, string(v.Key), v.Value)
+		if err != nil {
+			mainLog.Errorf("Fail to unmarshal %s: %v", v.Key, err)
+			continue
+		}
+
+		services = append(services, service)
+	}
+
+	return services, nil
+}
+
+// Close stops the keepalive and revokes the lease, the registered instances are deleted.
+func (r *Registrar) Close() error {
+	r.cancel()
+	<-r.done
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.leaseID != 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+		defer cancel()
+
+		if _, err := r.lease.Revoke(ctx, r.leaseID); err != nil {
+			mainLog.Errorf("Revoke lease %x error: %v", r.leaseID, err)
+		}
+		r.leaseID = 0
 	}
 
-	return e.putWithLease(service.Name, string(buf), ttl)
+	if r.closer != nil {
+		return r.closer()
+	}
+
+	return nil
 }
 
-func (e *EtcdClient) keepAlive(ctx context.Context, id clientv3.LeaseID) error {
-	keepAliveCh, err := e.client.KeepAlive(ctx, id)
+// grant creates the lease, the lock must be held.
+func (r *Registrar) grant() error {
+	ctx, cancel := context.WithTimeout(r.ctx, r.timeout)
+	defer cancel()
+
+	resp, err := r.lease.Grant(ctx, int64(r.ttl/time.Second))
 	if err != nil {
-		return err
+		return fmt.Errorf("grant lease: %w", err)
+	}
+
+	r.leaseID = resp.ID
+
+	select {
+	case r.granted <- struct{}{}:
+	default:
 	}
 
-	go func() {
-		for {
+	return nil
+}
+
+// put writes the key under the lease, the lock must be held.
+func (r *Registrar) put(key, value string) error {
+	ctx, cancel := context.WithTimeout(r.ctx, r.timeout)
+	defer cancel()
+
+	_, err := r.kv.Put(ctx, key, value, clientv3.WithLease(r.leaseID))
+	return err
+}
+
+// keepAlive keeps the lease alive and registers the instances again when it is lost.
+func (r *Registrar) keepAlive() {
+	defer close(r.done)
+
+	for {
+		r.mu.Lock()
+		id := r.leaseID
+		r.mu.Unlock()
+
+		if id == 0 {
 			select {
-			case res := <-keepAliveCh:
-				mainLog.Debugf("%d keep alive successfully", res.ID)
-			case <-ctx.Done():
-				// revoke lease
-				e.client.Revoke(context.TODO(), id)
+			case <-r.granted:
+				continue
+			case <-r.ctx.Done():
 				return
 			}
 		}
-	}()
 
+		ch, err := r.lease.KeepAlive(r.ctx, id)
+		if err == nil {
+			// closed when the lease expires or ctx is done
+			for res := range ch {
+				mainLog.Debugf("Lease %x kept alive, ttl %d.", res.ID, res.TTL)
+			}
+		}
+
+		if r.ctx.Err() != nil {
+			return
+		}
+
+		mainLog.Warnf("Lease %x lost, registering again: %v", id, err)
+		r.recover(id)
+	}
+}
+
+// recover grants a new lease in place of the lost one and puts the instances again, it retries with backoff until done.
+func (r *Registrar) recover(lost clientv3.LeaseID) {
+	backoff := _minBackoff
+
+	for {
+		err := r.reregister(lost)
+		if err == nil {
+			return
+		}
+
+		mainLog.Errorf("Register again error, retry in %s: %v", backoff, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-r.ctx.Done():
+			return
+		}
+
+		if backoff *= 2; backoff > _maxBackoff {
+			backoff = _maxBackoff
+		}
+	}
+}
+
+func (r *Registrar) reregister(lost clientv3.LeaseID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ctx.Err() != nil {
+		return nil
+	}
+
+	// a failed attempt may have granted a lease already
+	if r.leaseID == lost {
+		if err := r.grant(); err != nil {
+			return err
+		}
+
+		// drop the signal, the loop reads the lease after returning
+		select {
+		case <-r.granted:
+		default:
+		}
+	}
+
+	for k, v := range r.services {
+		if err := r.put(k, v); err != nil {
+			return err
+		}
+	}
+
+	mainLog.Infof("Registered %d services again with lease %x.", len(r.services), r.leaseID)
 	return nil
 }
 
-func (e *EtcdClient) Close() error {
-	defer e.cancel()
-	return e.client.Close()
+func check(service *registry.Service) error {
+	switch {
+	case service == nil:
+		return NilServiceErr
+	case service.Name == "":
+		return EmptyNameErr
+	case service.Addr == "":
+		return EmptyAddressErr
+	case strings.Contains(service.Name, "/"):
+		return fmt.Errorf("invalid service name %q", service.Name)
+	}
+
+	return nil
 }
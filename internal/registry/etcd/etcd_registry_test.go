@@ -0,0 +1,208 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/KKKKjl/tinykit/internal/registry"
+)
+
+type fakeKV struct {
+	clientv3.KV
+	mu   sync.Mutex
+	data map[string]string
+	puts int
+}
+
+func (f *fakeKV) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.data[key] = val
+	f.puts++
+	return &clientv3.PutResponse{}, nil
+}
+
+func (f *fakeKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	resp := &clientv3.GetResponse{}
+	for k, v := range f.data {
+		if strings.HasPrefix(k, key) {
+			resp.Kvs = append(resp.Kvs, &mvccpb.KeyValue{Key: []byte(k), Value: []byte(v)})
+		}
+	}
+
+	sort.Slice(resp.Kvs, func(i, j int) bool {
+		return string(resp.Kvs[i].Key) < string(resp.Kvs[j].Key)
+	})
+
+	return resp, nil
+}
+
+func (f *fakeKV) Delete(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.data, key)
+	return &clientv3.DeleteResponse{}, nil
+}
+
+func (f *fakeKV) get(key string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	v, ok := f.data[key]
+	return v, ok
+}
+
+// fakeLease grants increasing ids, a keepalive channel is closed by expire.
+type fakeLease struct {
+	clientv3.Lease
+	mu      sync.Mutex
+	next    clientv3.LeaseID
+	fail    int // number of grants to fail
+	alive   map[clientv3.LeaseID]chan *clientv3.LeaseKeepAliveResponse
+	revoked []clientv3.LeaseID
+}
+
+func (f *fakeLease) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.fail > 0 {
+		f.fail--
+		return nil, errors.New("etcdserver: request timed out")
+	}
+
+	f.next++
+	return &clientv3.LeaseGrantResponse{ID: f.next, TTL: ttl}, nil
+}
+
+func (f *fakeLease) KeepAlive(ctx context.Context, id clientv3.LeaseID) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ch := make(chan *clientv3.LeaseKeepAliveResponse, 1)
+	ch <- &clientv3.LeaseKeepAliveResponse{ID: id, TTL: 10}
+	f.alive[id] = ch
+
+	go func() {
+		<-ctx.Done()
+		f.expire(id)
+	}()
+
+	return ch, nil
+}
+
+func (f *fakeLease) Revoke(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseRevokeResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.revoked = append(f.revoked, id)
+	return &clientv3.LeaseRevokeResponse{}, nil
+}
+
+func (f *fakeLease) expire(id clientv3.LeaseID) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if ch, ok := f.alive[id]; ok {
+		close(ch)
+		delete(f.alive, id)
+	}
+}
+
+func (f *fakeLease) keptAlive(id clientv3.LeaseID) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, ok := f.alive[id]
+	return ok
+}
+
+func newFakeRegistrar() (*Registrar, *fakeKV, *fakeLease) {
+	kv := &fakeKV{data: make(map[string]string)}
+	lease := &fakeLease{alive: make(map[clientv3.LeaseID]chan *clientv3.LeaseKeepAliveResponse)}
+
+	return newRegistrar(kv, lease, nil, (&Config{}).withDefault(), time.Second), kv, lease
+}
+
+func TestRegister(t *testing.T) {
+	assert := assert.New(t)
+
+	r, kv, lease := newFakeRegistrar()
+
+	assert.Equal(EmptyNameErr, r.Register(&registry.Service{Addr: "http://localhost:8080"}))
+	assert.NotNil(r.Register(&registry.Service{Name: "a/b", Addr: "http://localhost:8080"}))
+
+	a := &registry.Service{Name: "greeter", Addr: "http://localhost:8080", Weight: 1}
+	b := &registry.Service{Name: "greeter", Addr: "http://localhost:8081", Weight: 1}
+	c := &registry.Service{Name: "user", Addr: "http://localhost:8082"}
+	for _, v := range []*registry.Service{b, a, c} {
+		assert.Nil(r.Register(v))
+	}
+
+	// a single lease is kept alive
+	assert.Eventually(func() bool { return lease.keptAlive(1) }, time.Second, 10*time.Millisecond)
+
+	services, err := r.GetService("greeter")
+	assert.Nil(err)
+	assert.Equal([]*registry.Service{a, b}, services)
+
+	// updated in place
+	a.Weight = 5
+	a.Metadata = map[string]string{"zone": "a"}
+	assert.Nil(r.Register(a))
+
+	services, err = r.ListServices()
+	assert.Nil(err)
+	assert.Len(services, 3)
+	assert.Equal(5, services[0].Weight)
+	assert.Equal("a", services[0].Metadata["zone"])
+
+	assert.Nil(r.Deregister(c))
+	services, _ = r.ListServices()
+	assert.Len(services, 2)
+
+	assert.Nil(r.Close())
+	assert.Equal([]clientv3.LeaseID{1}, lease.revoked)
+	assert.Equal(ClosedErr, r.Register(c))
+	assert.Equal(4, kv.puts)
+}
+
+func TestLeaseLost(t *testing.T) {
+	assert := assert.New(t)
+
+	r, kv, lease := newFakeRegistrar()
+	defer r.Close()
+
+	a := &registry.Service{Name: "greeter", Addr: "http://localhost:8080"}
+	assert.Nil(r.Register(a))
+	assert.Eventually(func() bool { return lease.keptAlive(1) }, time.Second, 10*time.Millisecond)
+
+	// the keys expire with the lease, the first grant after fails
+	lease.mu.Lock()
+	lease.fail = 1
+	lease.mu.Unlock()
+
+	kv.mu.Lock()
+	kv.data = make(map[string]string)
+	kv.mu.Unlock()
+	lease.expire(1)
+
+	assert.Eventually(func() bool { return lease.keptAlive(2) }, 2*time.Second, 10*time.Millisecond)
+
+	_, ok := kv.get(Key(_defaultPrefix, a))
+	assert.True(ok)
+}
@@ -0,0 +1,103 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+
+	"github.com/KKKKjl/tinykit/internal/registry"
+	"github.com/KKKKjl/tinykit/internal/registry/memory"
+	"github.com/KKKKjl/tinykit/logger"
+	"github.com/KKKKjl/tinykit/utils"
+)
+
+// DefaultKey is the key of the service list in a services file.
+const DefaultKey = "services"
+
+var (
+	log     = logger.GetLogger()
+	mainLog = log.WithField("prefix", "registry")
+)
+
+// Registry serves the services listed under a key of a yaml or json file and reloads them when the
+// file changes, e.g.
+//
+//	services:
+//	  - name: greeter
+//	    addr: 127.0.0.1:8081
+//	    weight: 2
+//
+// A file failing to load keeps the previous services.
+type Registry struct {
+	*memory.Registry
+	file string
+	key  string
+}
+
+// New loads the services under key of the file, empty key means DefaultKey.
+func New(file, key string) (*Registry, error) {
+	if key == "" {
+		key = DefaultKey
+	}
+
+	r := &Registry{
+		Registry: memory.New(),
+		file:     filepath.Clean(file),
+		key:      key,
+	}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Reload reads the file and replaces the services.
+func (r *Registry) Reload() error {
+	services, err := Load(r.file, r.key)
+	if err != nil {
+		return err
+	}
+
+	r.Set(services)
+	return nil
+}
+
+// Load reads the services under key of the file, the format is taken from the file extension.
+func Load(file, key string) ([]*registry.Service, error) {
+	v := viper.New()
+	v.SetConfigFile(file)
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("read services %s: %w", file, err)
+	}
+
+	var services []*registry.Service
+	if err := v.UnmarshalKey(key, &services); err != nil {
+		return nil, fmt.Errorf("decode services %s: %w", file, err)
+	}
+
+	for i, v := range services {
+		if v == nil || v.Name == "" || v.Addr == "" {
+			return nil, fmt.Errorf("%s: %s[%d]: name and addr are required", file, key, i)
+		}
+	}
+
+	return services, nil
+}
+
+// Watch reloads the services on file changes until ctx is done.
+func (r *Registry) Watch(ctx context.Context) error {
+	return utils.WatchFile(ctx, r.file, func() {
+		if err := r.Reload(); err != nil {
+			mainLog.Errorf("Reload services failed, keep the previous ones: %v", err)
+			return
+		}
+		mainLog.Infof("Reloaded services from %s.", r.file)
+	}, func(err error) {
+		mainLog.Errorf("Watch services %s error: %v", r.file, err)
+	})
+}
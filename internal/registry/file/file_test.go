@@ -0,0 +1,66 @@
+package file
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+
+	file := filepath.Join(dir, "services.json")
+	assert.Nil(ioutil.WriteFile(file, []byte(`{"services": [{"name": "a", "addr": "http://localhost:8080", "weight": 2, "metadata": {"zone": "a"}}]}`), 0644))
+
+	services, err := Load(file, DefaultKey)
+	assert.Nil(err)
+	assert.Len(services, 1)
+	assert.Equal("a", services[0].Name)
+	assert.Equal(2, services[0].Weight)
+	assert.Equal("a", services[0].Metadata["zone"])
+
+	// nested key of a config file
+	file = filepath.Join(dir, "config.yaml")
+	assert.Nil(ioutil.WriteFile(file, []byte("registry:\n  static:\n    services:\n      - name: b\n        addr: http://localhost:8081\n"), 0644))
+
+	services, err = Load(file, "registry.static.services")
+	assert.Nil(err)
+	assert.Equal("http://localhost:8081", services[0].Addr)
+
+	assert.Nil(ioutil.WriteFile(file, []byte("services:\n  - name: c\n"), 0644))
+	_, err = Load(file, DefaultKey)
+	assert.NotNil(err)
+}
+
+func TestWatch(t *testing.T) {
+	assert := assert.New(t)
+
+	file := filepath.Join(t.TempDir(), "services.yaml")
+	assert.Nil(ioutil.WriteFile(file, []byte("services:\n  - name: a\n    addr: http://localhost:8080\n"), 0644))
+
+	r, err := New(file, "")
+	assert.Nil(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.Nil(r.Watch(ctx))
+
+	resolved := r.Resolve("a", "")
+	assert.Len(resolved.ListServer(), 1)
+
+	assert.Nil(ioutil.WriteFile(file, []byte("services:\n  - name: a\n    addr: http://localhost:8080\n  - name: a\n    addr: http://localhost:8081\n"), 0644))
+	assert.Eventually(func() bool {
+		return len(resolved.ListServer()) == 2
+	}, 2*time.Second, 20*time.Millisecond)
+
+	// an invalid file keeps the services
+	assert.Nil(ioutil.WriteFile(file, []byte("services:\n  - name: a\n"), 0644))
+	time.Sleep(300 * time.Millisecond)
+	assert.Len(resolved.ListServer(), 2)
+}
@@ -0,0 +1,131 @@
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// checkFunc checks the service at addr, nil means healthy.
+type checkFunc func(ctx context.Context, addr string) error
+
+func newCheck(c Config) checkFunc {
+	switch c.Type {
+	case "http":
+		return httpCheck(c.Path)
+	case "grpc":
+		return grpcCheck(c.Service, c.TLS)
+	default:
+		return tcpCheck
+	}
+}
+
+// httpCheck requests the path and expects a 2xx or 3xx status.
+func httpCheck(path string) checkFunc {
+	if path == "" {
+		path = "/"
+	}
+
+	// probes must not reuse the connections of a dead backend
+	client := &http.Client{
+		Transport: &http.Transport{DisableKeepAlives: true},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return func(ctx context.Context, addr string) error {
+		u, err := url.Parse(addr)
+		if err != nil || u.Host == "" {
+			u = &url.URL{Scheme: "http", Host: addr}
+		}
+		u.Path = path
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("User-Agent", "TinyKit-HealthCheck")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return fmt.Errorf("unhealthy status %d", resp.StatusCode)
+		}
+
+		return nil
+	}
+}
+
+func tcpCheck(ctx context.Context, addr string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", hostPort(addr))
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// grpcCheck calls the standard grpc.health.v1.Health/Check, nil tls means plaintext.
+func grpcCheck(service string, tlsConfig *tls.Config) checkFunc {
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	return func(ctx context.Context, addr string) error {
+		conn, err := grpc.DialContext(ctx, hostPort(addr), grpc.WithTransportCredentials(creds), grpc.WithBlock())
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: service})
+		if err != nil {
+			return err
+		}
+
+		if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+			return fmt.Errorf("unhealthy status %s", resp.Status)
+		}
+
+		return nil
+	}
+}
+
+// hostPort returns host:port of addr, which is either a url or host:port.
+func hostPort(addr string) string {
+	if !strings.Contains(addr, "://") {
+		return addr
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return addr
+	}
+
+	if u.Port() != "" {
+		return u.Host
+	}
+
+	switch u.Scheme {
+	case "https", "wss":
+		return net.JoinHostPort(u.Hostname(), "443")
+	default:
+		return net.JoinHostPort(u.Hostname(), "80")
+	}
+}
@@ -0,0 +1,295 @@
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/KKKKjl/tinykit/internal/registry"
+	"github.com/KKKKjl/tinykit/logger"
+)
+
+const (
+	_defaultInterval           = 10 * time.Second
+	_defaultTimeout            = 2 * time.Second
+	_defaultHealthyThreshold   = 2
+	_defaultUnhealthyThreshold = 3
+)
+
+var (
+	log     = logger.GetLogger()
+	mainLog = log.WithField("prefix", "health")
+
+	// states shared by name, e.g. by the checkers of a route before and after a reload
+	sharedMu sync.Mutex
+	shared   = make(map[string]*stateSet)
+)
+
+type (
+	// Config of the active health check, a service turns unhealthy after UnhealthyThreshold
+	// consecutive failed checks and healthy again after HealthyThreshold consecutive passed checks.
+	// The checkers of the same non empty Name share the health states, the last created one checks.
+	Config struct {
+		Name               string
+		Type               string      // http, tcp or grpc, default tcp
+		Path               string      // path of the http check, default /
+		Service            string      // service of the grpc check, empty checks the whole server
+		TLS                *tls.Config // tls of the grpc check, nil means plaintext
+		Interval           time.Duration
+		Timeout            time.Duration
+		HealthyThreshold   int
+		UnhealthyThreshold int
+	}
+
+	// Status is the health state of a service, services are healthy until checked otherwise.
+	Status struct {
+		Addr      string    `json:"addr"`
+		Name      string    `json:"name"`
+		Healthy   bool      `json:"healthy"`
+		Successes int       `json:"successes"` // consecutive passed checks
+		Failures  int       `json:"failures"`  // consecutive failed checks
+		LastCheck time.Time `json:"lastCheck"`
+		LastError string    `json:"lastError,omitempty"`
+	}
+
+	// stateSet is the health states of the checkers of a name.
+	stateSet struct {
+		mu       sync.RWMutex
+		states   map[string]*Status // addr -> status
+		checkers []*Checker         // guarded by sharedMu, the last one checks
+	}
+
+	// Checker checks the services of a builder periodically.
+	Checker struct {
+		builder registry.Builder
+		conf    Config
+		check   checkFunc
+		set     *stateSet
+		stop    chan struct{}
+		once    sync.Once
+	}
+)
+
+func New(builder registry.Builder, c Config) *Checker {
+	if c.Interval <= 0 {
+		c.Interval = _defaultInterval
+	}
+
+	if c.Timeout <= 0 {
+		c.Timeout = _defaultTimeout
+	}
+
+	if c.HealthyThreshold <= 0 {
+		c.HealthyThreshold = _defaultHealthyThreshold
+	}
+
+	if c.UnhealthyThreshold <= 0 {
+		c.UnhealthyThreshold = _defaultUnhealthyThreshold
+	}
+
+	checker := &Checker{
+		builder: builder,
+		conf:    c,
+		stop:    make(chan struct{}),
+	}
+	checker.check = newCheck(c)
+	checker.set = acquire(checker)
+
+	return checker
+}
+
+// acquire returns the states of the name of the checker, the checker takes over the checks of the name
+// until stopped. The services known by a previous checker keep their states, e.g. across a reload.
+func acquire(c *Checker) *stateSet {
+	if c.conf.Name == "" {
+		return &stateSet{states: make(map[string]*Status), checkers: []*Checker{c}}
+	}
+
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+
+	set, ok := shared[c.conf.Name]
+	if !ok {
+		set = &stateSet{states: make(map[string]*Status)}
+		shared[c.conf.Name] = set
+	}
+	set.checkers = append(set.checkers, c)
+
+	return set
+}
+
+// release hands the checks back to the previous checker, the states are dropped with the last checker.
+func (c *Checker) release() {
+	if c.conf.Name == "" {
+		return
+	}
+
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+
+	set := c.set
+	for i, v := range set.checkers {
+		if v == c {
+			set.checkers = append(set.checkers[:i:i], set.checkers[i+1:]...)
+			break
+		}
+	}
+
+	if len(set.checkers) == 0 && shared[c.conf.Name] == set {
+		delete(shared, c.conf.Name)
+	}
+}
+
+// active reports whether the checker checks the services of its name.
+func (c *Checker) active() bool {
+	if c.conf.Name == "" {
+		return true
+	}
+
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+
+	return c.set.checkers[len(c.set.checkers)-1] == c
+}
+
+// Start checks the services every interval until Stop is called.
+func (c *Checker) Start() {
+	go func() {
+		ticker := time.NewTicker(c.conf.Interval)
+		defer ticker.Stop()
+
+		for {
+			c.CheckAll()
+
+			select {
+			case <-ticker.C:
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the checks, the next checker of the name, if any, is kept checking.
+func (c *Checker) Stop() {
+	c.once.Do(func() {
+		close(c.stop)
+		c.release()
+	})
+}
+
+// CheckAll checks the current services once, the states of removed services are dropped.
+// It is skipped while a newer checker of the same name checks.
+func (c *Checker) CheckAll() {
+	if !c.active() {
+		return
+	}
+
+	services, err := c.builder.GetService()
+	if err != nil {
+		mainLog.Errorf("Fail to get service from discovery(%s): %v", c.builder.Scheme(), err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, v := range services {
+		wg.Add(1)
+		go func(service *registry.Service) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), c.conf.Timeout)
+			defer cancel()
+
+			c.update(service, c.check(ctx, service.Addr))
+		}(v)
+	}
+	wg.Wait()
+
+	c.set.mu.Lock()
+	defer c.set.mu.Unlock()
+
+	alive := make(map[string]struct{}, len(services))
+	for _, v := range services {
+		alive[v.Addr] = struct{}{}
+	}
+
+	for addr := range c.set.states {
+		if _, ok := alive[addr]; !ok {
+			delete(c.set.states, addr)
+		}
+	}
+}
+
+func (c *Checker) update(service *registry.Service, err error) {
+	c.set.mu.Lock()
+	defer c.set.mu.Unlock()
+
+	state, ok := c.set.states[service.Addr]
+	if !ok {
+		state = &Status{Addr: service.Addr, Healthy: true}
+		c.set.states[service.Addr] = state
+	}
+	state.Name = service.Name
+	state.LastCheck = time.Now()
+
+	if err != nil {
+		state.Failures++
+		state.Successes = 0
+		state.LastError = err.Error()
+
+		if state.Healthy && state.Failures >= c.conf.UnhealthyThreshold {
+			state.Healthy = false
+			mainLog.Warnf("Service %s(%s) is unhealthy: %v", service.Name, service.Addr, err)
+		}
+		return
+	}
+
+	state.Successes++
+	state.Failures = 0
+	state.LastError = ""
+
+	if !state.Healthy && state.Successes >= c.conf.HealthyThreshold {
+		state.Healthy = true
+		mainLog.Infof("Service %s(%s) is healthy again.", service.Name, service.Addr)
+	}
+}
+
+// IsHealthy reports whether the service at addr is healthy, unchecked services are healthy.
+func (c *Checker) IsHealthy(addr string) bool {
+	c.set.mu.RLock()
+	defer c.set.mu.RUnlock()
+
+	state, ok := c.set.states[addr]
+	return !ok || state.Healthy
+}
+
+// Filter returns the healthy services.
+func (c *Checker) Filter(services []*registry.Service) []*registry.Service {
+	healthy := make([]*registry.Service, 0, len(services))
+	for _, v := range services {
+		if c.IsHealthy(v.Addr) {
+			healthy = append(healthy, v)
+		}
+	}
+
+	return healthy
+}
+
+// Status returns the health states sorted by addr.
+func (c *Checker) Status() []Status {
+	c.set.mu.RLock()
+	defer c.set.mu.RUnlock()
+
+	status := make([]Status, 0, len(c.set.states))
+	for _, v := range c.set.states {
+		status = append(status, *v)
+	}
+
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Addr < status[j].Addr
+	})
+
+	return status
+}
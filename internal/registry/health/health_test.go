@@ -0,0 +1,167 @@
+package health
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	grpchealth "google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/KKKKjl/tinykit/internal/registry"
+	"github.com/KKKKjl/tinykit/internal/registry/static"
+)
+
+func TestHttpCheck(t *testing.T) {
+	assert := assert.New(t)
+
+	var status int32 = http.StatusOK
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/healthz", r.URL.Path)
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer backend.Close()
+
+	services := []*registry.Service{{Name: "a", Addr: backend.URL}, {Name: "b", Addr: "http://127.0.0.1:1"}}
+	checker := New(static.New(services...), Config{Type: "http", Path: "/healthz", HealthyThreshold: 2, UnhealthyThreshold: 2})
+
+	checker.CheckAll()
+	assert.Len(checker.Filter(services), 2)
+
+	checker.CheckAll()
+	assert.Equal([]*registry.Service{services[0]}, checker.Filter(services))
+
+	// unhealthy after 2 failures, healthy again after 2 successes
+	atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+	checker.CheckAll()
+	assert.True(checker.IsHealthy(backend.URL))
+	checker.CheckAll()
+	assert.False(checker.IsHealthy(backend.URL))
+	for _, v := range checker.Status() {
+		if v.Addr == backend.URL {
+			assert.Equal("unhealthy status 503", v.LastError)
+		}
+	}
+
+	atomic.StoreInt32(&status, http.StatusOK)
+	checker.CheckAll()
+	assert.False(checker.IsHealthy(backend.URL))
+	checker.CheckAll()
+	assert.True(checker.IsHealthy(backend.URL))
+}
+
+func TestShared(t *testing.T) {
+	assert := assert.New(t)
+
+	services := []*registry.Service{{Name: "a", Addr: "127.0.0.1:1"}}
+	c := Config{Name: "greeter", UnhealthyThreshold: 1}
+
+	checker := New(static.New(services...), c)
+	checker.CheckAll()
+	assert.False(checker.IsHealthy(services[0].Addr))
+
+	// the reloaded route keeps the state, the previous checker stops checking
+	reloaded := New(static.New(), c)
+	assert.False(reloaded.IsHealthy(services[0].Addr))
+	checker.CheckAll()
+	assert.Len(reloaded.Status(), 1)
+
+	checker.Stop()
+	assert.False(reloaded.IsHealthy(services[0].Addr))
+
+	// a removed route drops its states
+	reloaded.Stop()
+	added := New(static.New(services...), c)
+	defer added.Stop()
+	assert.True(added.IsHealthy(services[0].Addr))
+}
+
+func TestTcpCheck(t *testing.T) {
+	assert := assert.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	defer listener.Close()
+
+	builder := static.New(&registry.Service{Addr: "http://" + listener.Addr().String()})
+	checker := New(builder, Config{UnhealthyThreshold: 1})
+
+	checker.CheckAll()
+	assert.True(checker.Status()[0].Healthy)
+
+	listener.Close()
+	checker.CheckAll()
+	assert.False(checker.Status()[0].Healthy)
+
+	// states of removed services are dropped
+	builder.DelServer(&registry.Service{Addr: "http://" + listener.Addr().String()})
+	checker.CheckAll()
+	assert.Empty(checker.Status())
+}
+
+func TestGrpcCheck(t *testing.T) {
+	assert := assert.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+
+	server := grpc.NewServer()
+	healthServer := grpchealth.NewServer()
+	grpc_health_v1.RegisterHealthServer(server, healthServer)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	builder := static.New(&registry.Service{Addr: listener.Addr().String()})
+	checker := New(builder, Config{Type: "grpc", Service: "helloworld.Greeter", Timeout: time.Second, UnhealthyThreshold: 1})
+
+	healthServer.SetServingStatus("helloworld.Greeter", grpc_health_v1.HealthCheckResponse_SERVING)
+	checker.CheckAll()
+	assert.True(checker.Status()[0].Healthy)
+
+	healthServer.SetServingStatus("helloworld.Greeter", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	checker.CheckAll()
+	assert.False(checker.Status()[0].Healthy)
+	assert.Equal("unhealthy status NOT_SERVING", checker.Status()[0].LastError)
+}
+
+func TestGrpcCheckTLS(t *testing.T) {
+	assert := assert.New(t)
+
+	// borrow the certificate of a tls test server
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(ts.TLS)))
+	grpc_health_v1.RegisterHealthServer(server, grpchealth.NewServer())
+	go server.Serve(listener)
+	defer server.Stop()
+
+	builder := static.New(&registry.Service{Addr: listener.Addr().String()})
+
+	checker := New(builder, Config{Type: "grpc", Timeout: time.Second, UnhealthyThreshold: 1})
+	checker.CheckAll()
+	assert.False(checker.Status()[0].Healthy)
+
+	tls := ts.Client().Transport.(*http.Transport).TLSClientConfig
+	checker = New(builder, Config{Type: "grpc", Timeout: time.Second, UnhealthyThreshold: 1, TLS: tls})
+	checker.CheckAll()
+	assert.True(checker.Status()[0].Healthy)
+}
+
+func TestHostPort(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("localhost:8080", hostPort("localhost:8080"))
+	assert.Equal("localhost:8080", hostPort("http://localhost:8080/api"))
+	assert.Equal("localhost:80", hostPort("http://localhost"))
+	assert.Equal("localhost:443", hostPort("https://localhost"))
+}
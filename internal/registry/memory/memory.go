@@ -0,0 +1,213 @@
+package memory
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/KKKKjl/tinykit/internal/registry"
+)
+
+var (
+	NilServiceErr   = errors.New("Service is nil.")
+	EmptyNameErr    = errors.New("Service name is empty.")
+	EmptyAddressErr = errors.New("Service addr is empty.")
+)
+
+var _ registry.Registry = (*Registry)(nil)
+
+// Registry keeps the services in memory grouped by name, for tests and embedded use.
+// The instances of a name are kept in the order they were registered.
+type Registry struct {
+	mu       sync.RWMutex
+	services map[string][]*registry.Service // name -> instances
+	names    []string
+}
+
+func New(services ...*registry.Service) *Registry {
+	r := &Registry{
+		services: make(map[string][]*registry.Service),
+	}
+
+	for _, v := range services {
+		r.Register(v)
+	}
+
+	return r
+}
+
+// Register adds the service or replaces the instance of the same name and addr.
+func (r *Registry) Register(service *registry.Service) error {
+	if err := check(service); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.put(service)
+	return nil
+}
+
+func (r *Registry) put(service *registry.Service) {
+	instances, ok := r.services[service.Name]
+	if !ok {
+		r.names = append(r.names, service.Name)
+	}
+
+	for i, v := range instances {
+		if v.Addr == service.Addr {
+			instances[i] = service
+			return
+		}
+	}
+
+	r.services[service.Name] = append(instances, service)
+}
+
+// Deregister removes the instance of the same name and addr.
+func (r *Registry) Deregister(service *registry.Service) error {
+	if err := check(service); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	instances := r.services[service.Name]
+	for i, v := range instances {
+		if v.Addr != service.Addr {
+			continue
+		}
+
+		if len(instances) == 1 {
+			delete(r.services, service.Name)
+			for j, name := range r.names {
+				if name == service.Name {
+					r.names = append(r.names[:j], r.names[j+1:]...)
+					break
+				}
+			}
+			return nil
+		}
+
+		// copy on write, the returned slices are shared
+		r.services[service.Name] = append(instances[:i:i], instances[i+1:]...)
+		return nil
+	}
+
+	return nil
+}
+
+// Set replaces all the services, invalid ones are skipped.
+func (r *Registry) Set(services []*registry.Service) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.services = make(map[string][]*registry.Service)
+	r.names = nil
+
+	for _, v := range services {
+		if check(v) == nil {
+			r.put(v)
+		}
+	}
+}
+
+// GetService returns the instances of the name, empty if none is registered.
+func (r *Registry) GetService(name string) ([]*registry.Service, error) {
+	return r.instances(name, ""), nil
+}
+
+// ListServices returns all the instances grouped by name.
+func (r *Registry) ListServices() ([]*registry.Service, error) {
+	return r.instances("", ""), nil
+}
+
+// instances returns the services of the name and version, empty name or version means any.
+func (r *Registry) instances(name, version string) []*registry.Service {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := r.names
+	if name != "" {
+		names = []string{name}
+	}
+
+	var services []*registry.Service
+	for _, n := range names {
+		for _, v := range r.services[n] {
+			if version == "" || v.Version == version {
+				services = append(services, v)
+			}
+		}
+	}
+
+	return services
+}
+
+// Builder returns a builder serving all the services, it resolves the named services.
+func (r *Registry) Builder() registry.Builder {
+	return &view{registry: r}
+}
+
+// Resolve returns a builder serving the instances of the named service.
+func (r *Registry) Resolve(name, version string) registry.Builder {
+	return &view{registry: r, name: name, version: version}
+}
+
+func check(service *registry.Service) error {
+	switch {
+	case service == nil:
+		return NilServiceErr
+	case service.Name == "":
+		return EmptyNameErr
+	case service.Addr == "":
+		return EmptyAddressErr
+	}
+
+	return nil
+}
+
+// view is the builder of the services of a name and version.
+type view struct {
+	registry *Registry
+	name     string
+	version  string
+}
+
+func (v *view) GetService() ([]*registry.Service, error) {
+	return v.ListServer(), nil
+}
+
+// PutServer registers the service, the name of the view is used if the service has none.
+func (v *view) PutServer(service *registry.Service) {
+	if service != nil && service.Name == "" {
+		s := *service
+		s.Name = v.name
+		service = &s
+	}
+
+	v.registry.Register(service)
+}
+
+func (v *view) ListServer() []*registry.Service {
+	return v.registry.instances(v.name, v.version)
+}
+
+func (v *view) DelServer(service *registry.Service) {
+	if service != nil && service.Name == "" {
+		s := *service
+		s.Name = v.name
+		service = &s
+	}
+
+	v.registry.Deregister(service)
+}
+
+func (v *view) Resolve(name, version string) registry.Builder {
+	return v.registry.Resolve(name, version)
+}
+
+func (v *view) Scheme() string {
+	return "memory"
+}
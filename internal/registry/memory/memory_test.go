@@ -0,0 +1,79 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/KKKKjl/tinykit/internal/registry"
+)
+
+func TestRegistry(t *testing.T) {
+	assert := assert.New(t)
+
+	r := New()
+	assert.Equal(EmptyNameErr, r.Register(&registry.Service{Addr: "a"}))
+	assert.Equal(EmptyAddressErr, r.Register(&registry.Service{Name: "a"}))
+	assert.Equal(NilServiceErr, r.Register(nil))
+
+	a1 := &registry.Service{Name: "a", Addr: "1", Version: "v1"}
+	a2 := &registry.Service{Name: "a", Addr: "2", Version: "v2"}
+	b1 := &registry.Service{Name: "b", Addr: "1"}
+	for _, v := range []*registry.Service{a1, b1, a2} {
+		assert.Nil(r.Register(v))
+	}
+
+	services, err := r.GetService("a")
+	assert.Nil(err)
+	assert.Equal([]*registry.Service{a1, a2}, services)
+
+	services, err = r.ListServices()
+	assert.Nil(err)
+	assert.Equal([]*registry.Service{a1, a2, b1}, services)
+
+	// replaced in place
+	a1v3 := &registry.Service{Name: "a", Addr: "1", Version: "v3"}
+	assert.Nil(r.Register(a1v3))
+	services, _ = r.GetService("a")
+	assert.Equal([]*registry.Service{a1v3, a2}, services)
+
+	assert.Nil(r.Deregister(a1))
+	assert.Nil(r.Deregister(b1))
+	services, _ = r.ListServices()
+	assert.Equal([]*registry.Service{a2}, services)
+
+	services, _ = r.GetService("b")
+	assert.Empty(services)
+}
+
+func TestBuilder(t *testing.T) {
+	assert := assert.New(t)
+
+	a1 := &registry.Service{Name: "a", Addr: "1", Version: "v1"}
+	a2 := &registry.Service{Name: "a", Addr: "2", Version: "v2"}
+	b1 := &registry.Service{Name: "b", Addr: "1"}
+	r := New(a1, a2, b1)
+
+	builder := r.Builder()
+	assert.Equal([]*registry.Service{a1, a2, b1}, builder.ListServer())
+
+	view := builder.(registry.Resolver).Resolve("a", "v2")
+	assert.Equal([]*registry.Service{a2}, view.ListServer())
+
+	// the view changes with the registry
+	a3 := &registry.Service{Name: "a", Addr: "3", Version: "v2"}
+	r.Register(a3)
+	assert.Equal([]*registry.Service{a2, a3}, view.ListServer())
+
+	// services put without a name take the name of the view
+	view = r.Resolve("c", "")
+	view.PutServer(&registry.Service{Addr: "1"})
+	services, _ := r.GetService("c")
+	assert.Equal("c", services[0].Name)
+
+	view.DelServer(&registry.Service{Addr: "1"})
+	assert.Empty(view.ListServer())
+
+	r.Set([]*registry.Service{b1, {Name: "invalid"}})
+	assert.Equal([]*registry.Service{b1}, builder.ListServer())
+}
@@ -0,0 +1,302 @@
+package outlier
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/KKKKjl/tinykit/internal/registry"
+	"github.com/KKKKjl/tinykit/logger"
+)
+
+const (
+	_defaultConsecutiveErrors  = 5
+	_defaultBaseEjectionTime   = 30 * time.Second
+	_defaultMaxEjectionTime    = 300 * time.Second
+	_defaultMaxEjectionPercent = 50
+)
+
+var (
+	log     = logger.GetLogger()
+	mainLog = log.WithField("prefix", "outlier")
+
+	// detectors shared by name, e.g. by the proxies of a route before and after a reload
+	sharedMu  sync.Mutex
+	detectors = make(map[string]*Detector)
+)
+
+type (
+	// Config of the passive outlier detection, a service is ejected after ConsecutiveErrors
+	// consecutive failed requests. The ejection time starts at BaseEjectionTime and doubles on
+	// every ejection up to MaxEjectionTime, at most MaxEjectionPercent of the services are ejected.
+	// The detectors of the same non empty Name share their states, see Acquire.
+	Config struct {
+		Name               string
+		ConsecutiveErrors  int
+		BaseEjectionTime   time.Duration
+		MaxEjectionTime    time.Duration
+		MaxEjectionPercent int
+	}
+
+	// Status is the outlier state of a service.
+	Status struct {
+		Addr              string     `json:"addr"`
+		Ejected           bool       `json:"ejected"`
+		EjectedUntil      *time.Time `json:"ejectedUntil,omitempty"`
+		ConsecutiveErrors int        `json:"consecutiveErrors"`
+		Ejections         int        `json:"ejections"` // total ejections
+		LastError         string     `json:"lastError,omitempty"`
+	}
+
+	// Stats is the outlier states and the counters of a detector.
+	Stats struct {
+		Ejections  uint64   `json:"ejections"`  // total ejections
+		Suppressed uint64   `json:"suppressed"` // outliers not ejected, max ejection percent reached
+		Ejected    int      `json:"ejected"`    // services currently ejected
+		Services   []Status `json:"services"`
+	}
+
+	state struct {
+		errors     int       // consecutive errors
+		until      time.Time // end of the last ejection
+		multiplier int       // multiplier of the next ejection time, decays while not ejected
+		ejections  int
+		lastError  string
+	}
+
+	// Detector ejects the services failing consecutively, it is fed by the results of the proxied requests.
+	Detector struct {
+		name       string
+		conf       Config
+		refs       int // guarded by sharedMu
+		mu         sync.RWMutex
+		states     map[string]*state // addr -> state
+		total      int               // number of services at the last filter
+		ejections  uint64
+		suppressed uint64
+		now        func() time.Time
+	}
+)
+
+func New(c Config) *Detector {
+	return &Detector{
+		name:   c.Name,
+		conf:   defaults(c),
+		states: make(map[string]*state),
+		now:    time.Now,
+	}
+}
+
+// Acquire returns the detector of the name of the config, the states of the services are kept while it is
+// acquired, e.g. from the proxy of a route to the proxy of the reloaded route. The config replaces the
+// previous one, the detector must be released once unused. Empty name means a new detector.
+func Acquire(c Config) *Detector {
+	if c.Name == "" {
+		return New(c)
+	}
+
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+
+	d, ok := detectors[c.Name]
+	if ok {
+		d.mu.Lock()
+		d.conf = defaults(c)
+		d.mu.Unlock()
+	} else {
+		d = New(c)
+		detectors[c.Name] = d
+	}
+	d.refs++
+
+	return d
+}
+
+// Release drops the states of the detector once released by all the users it was returned to by Acquire.
+func (d *Detector) Release() {
+	if d.name == "" {
+		return
+	}
+
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+
+	d.refs--
+	if d.refs <= 0 && detectors[d.name] == d {
+		delete(detectors, d.name)
+	}
+}
+
+func defaults(c Config) Config {
+	if c.ConsecutiveErrors <= 0 {
+		c.ConsecutiveErrors = _defaultConsecutiveErrors
+	}
+
+	if c.BaseEjectionTime <= 0 {
+		c.BaseEjectionTime = _defaultBaseEjectionTime
+	}
+
+	if c.MaxEjectionTime <= 0 {
+		c.MaxEjectionTime = _defaultMaxEjectionTime
+	}
+
+	if c.MaxEjectionTime < c.BaseEjectionTime {
+		c.MaxEjectionTime = c.BaseEjectionTime
+	}
+
+	if c.MaxEjectionPercent <= 0 {
+		c.MaxEjectionPercent = _defaultMaxEjectionPercent
+	}
+
+	return c
+}
+
+// Filter returns the services not ejected.
+func (d *Detector) Filter(services []*registry.Service) []*registry.Service {
+	now := d.now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.total = len(services)
+
+	available := make([]*registry.Service, 0, len(services))
+	for _, v := range services {
+		if s, ok := d.states[v.Addr]; ok && now.Before(s.until) {
+			continue
+		}
+
+		available = append(available, v)
+	}
+
+	return available
+}
+
+// Report records the result of a request to the service at addr, nil err means success.
+func (d *Detector) Report(addr string, err error) {
+	now := d.now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s, ok := d.states[addr]
+	if !ok {
+		if err == nil {
+			return
+		}
+
+		s = &state{}
+		d.states[addr] = s
+	}
+
+	if err == nil {
+		s.errors = 0
+		return
+	}
+
+	s.errors++
+	s.lastError = err.Error()
+
+	// requests sent before the ejection may still fail
+	if now.Before(s.until) || s.errors < d.conf.ConsecutiveErrors {
+		return
+	}
+
+	if !d.canEject(now) {
+		d.suppressed++
+		mainLog.Warnf("Service %s is an outlier but not ejected, max ejection percent %d%% reached.", addr, d.conf.MaxEjectionPercent)
+		return
+	}
+
+	// the multiplier decays by one for every base ejection time since the last ejection ended
+	if !s.until.IsZero() {
+		s.multiplier -= int(now.Sub(s.until) / d.conf.BaseEjectionTime)
+		if s.multiplier < 0 {
+			s.multiplier = 0
+		}
+	}
+
+	duration := d.conf.MaxEjectionTime
+	if s.multiplier < 30 && d.conf.BaseEjectionTime<<s.multiplier < d.conf.MaxEjectionTime {
+		duration = d.conf.BaseEjectionTime << s.multiplier
+		s.multiplier++
+	}
+
+	s.until = now.Add(duration)
+	s.errors = 0
+	s.ejections++
+	d.ejections++
+
+	mainLog.Warnf("Eject service %s for %s after %d consecutive errors, last error: %v", addr, duration, d.conf.ConsecutiveErrors, err)
+}
+
+// canEject reports whether one more service can be ejected within the max ejection percent.
+func (d *Detector) canEject(now time.Time) bool {
+	var ejected int
+	for _, v := range d.states {
+		if now.Before(v.until) {
+			ejected++
+		}
+	}
+
+	return (ejected+1)*100 <= d.total*d.conf.MaxEjectionPercent
+}
+
+// Stats returns the counters and the outlier states sorted by addr.
+func (d *Detector) Stats() Stats {
+	now := d.now()
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	stats := Stats{
+		Ejections:  d.ejections,
+		Suppressed: d.suppressed,
+		Services:   d.status(now),
+	}
+
+	for _, v := range stats.Services {
+		if v.Ejected {
+			stats.Ejected++
+		}
+	}
+
+	return stats
+}
+
+// Status returns the outlier states sorted by addr.
+func (d *Detector) Status() []Status {
+	now := d.now()
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.status(now)
+}
+
+// status returns the outlier states sorted by addr, the lock must be held.
+func (d *Detector) status(now time.Time) []Status {
+	status := make([]Status, 0, len(d.states))
+	for addr, v := range d.states {
+		s := Status{
+			Addr:              addr,
+			Ejected:           now.Before(v.until),
+			ConsecutiveErrors: v.errors,
+			Ejections:         v.ejections,
+			LastError:         v.lastError,
+		}
+
+		if s.Ejected {
+			until := v.until
+			s.EjectedUntil = &until
+		}
+
+		status = append(status, s)
+	}
+
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Addr < status[j].Addr
+	})
+
+	return status
+}
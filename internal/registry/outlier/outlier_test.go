@@ -0,0 +1,141 @@
+package outlier
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/KKKKjl/tinykit/internal/registry"
+)
+
+var errUnavailable = errors.New("unavailable")
+
+func newDetector(c Config, now *time.Time) *Detector {
+	d := New(c)
+	d.now = func() time.Time { return *now }
+	return d
+}
+
+func fail(d *Detector, addr string, n int) {
+	for i := 0; i < n; i++ {
+		d.Report(addr, errUnavailable)
+	}
+}
+
+func TestEject(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1000, 0)
+	d := newDetector(Config{ConsecutiveErrors: 3, BaseEjectionTime: 10 * time.Second, MaxEjectionTime: 30 * time.Second}, &now)
+
+	services := []*registry.Service{{Addr: "a"}, {Addr: "b"}}
+	assert.Len(d.Filter(services), 2)
+
+	// a success resets the consecutive errors
+	fail(d, "a", 2)
+	d.Report("a", nil)
+	fail(d, "a", 2)
+	assert.Len(d.Filter(services), 2)
+
+	d.Report("a", errUnavailable)
+	assert.Equal([]*registry.Service{services[1]}, d.Filter(services))
+	assert.True(d.Status()[0].Ejected)
+	assert.Equal(1, d.Status()[0].Ejections)
+
+	// the ejection time doubles up to the max
+	for _, v := range []time.Duration{20 * time.Second, 30 * time.Second, 30 * time.Second} {
+		now = now.Add(10 * time.Second)
+		assert.Len(d.Filter(services), 2)
+
+		fail(d, "a", 3)
+		assert.Equal(now.Add(v), *d.Status()[0].EjectedUntil)
+		now = now.Add(v - 10*time.Second)
+	}
+}
+
+func TestEjectionDecay(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1000, 0)
+	d := newDetector(Config{ConsecutiveErrors: 1, BaseEjectionTime: 10 * time.Second}, &now)
+	d.Filter([]*registry.Service{{Addr: "a"}, {Addr: "b"}})
+
+	fail(d, "a", 1)
+	now = now.Add(10 * time.Second)
+	fail(d, "a", 1)
+	assert.Equal(now.Add(20*time.Second), *d.Status()[0].EjectedUntil)
+
+	// healthy for two base ejection times
+	now = now.Add(40 * time.Second)
+	fail(d, "a", 1)
+	assert.Equal(now.Add(10*time.Second), *d.Status()[0].EjectedUntil)
+}
+
+func TestMaxEjectionPercent(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1000, 0)
+	d := newDetector(Config{ConsecutiveErrors: 1, MaxEjectionPercent: 50}, &now)
+
+	services := []*registry.Service{{Addr: "a"}, {Addr: "b"}, {Addr: "c"}, {Addr: "d"}}
+	d.Filter(services)
+
+	fail(d, "a", 1)
+	fail(d, "b", 1)
+	fail(d, "c", 1)
+
+	assert.Equal([]*registry.Service{services[2], services[3]}, d.Filter(services))
+	assert.False(d.Status()[2].Ejected)
+	assert.Equal("unavailable", d.Status()[2].LastError)
+}
+
+func TestStats(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1000, 0)
+	d := newDetector(Config{ConsecutiveErrors: 1, MaxEjectionPercent: 50}, &now)
+	d.Filter([]*registry.Service{{Addr: "a"}, {Addr: "b"}})
+
+	fail(d, "a", 1)
+	fail(d, "b", 1)
+
+	stats := d.Stats()
+	assert.Equal(uint64(1), stats.Ejections)
+	assert.Equal(uint64(1), stats.Suppressed)
+	assert.Equal(1, stats.Ejected)
+	assert.Len(stats.Services, 2)
+
+	now = now.Add(time.Hour)
+	assert.Equal(0, d.Stats().Ejected)
+}
+
+func TestAcquire(t *testing.T) {
+	assert := assert.New(t)
+
+	services := []*registry.Service{{Addr: "a"}, {Addr: "b"}}
+
+	d := Acquire(Config{Name: "greeter", ConsecutiveErrors: 1})
+	d.Filter(services)
+	fail(d, "a", 1)
+
+	// the reloaded route keeps the ejection and applies its config
+	reloaded := Acquire(Config{Name: "greeter", ConsecutiveErrors: 2})
+	d.Release()
+	assert.Same(d, reloaded)
+	assert.Len(reloaded.Filter(services), 1)
+
+	fail(reloaded, "b", 1)
+	assert.Len(reloaded.Filter(services), 1)
+
+	// a removed route drops its states
+	reloaded.Release()
+	added := Acquire(Config{Name: "greeter"})
+	defer added.Release()
+	assert.NotSame(d, added)
+	assert.Len(added.Filter(services), 2)
+
+	// no name, no sharing
+	assert.NotSame(Acquire(Config{}), Acquire(Config{}))
+}
@@ -24,6 +24,18 @@ type Builder interface {
 	Scheme() string
 }
 
+// Resolver is implemented by the discoveries grouping services by name, it returns a builder
+// serving the instances of the named service, empty version means any version.
+type Resolver interface {
+	Resolve(name, version string) Builder
+}
+
+// Releaser is implemented by the builders returned by Resolve holding background tasks, e.g. resolving a
+// name periodically, Release is called once the builder is no longer used.
+type Releaser interface {
+	Release()
+}
+
 type Service struct {
 	Name          string            `json:"name"`
 	Version       string            `json:"version"`
@@ -0,0 +1,79 @@
+package static
+
+import (
+	"sync"
+
+	"github.com/KKKKjl/tinykit/internal/registry"
+)
+
+// StaticDiscovery serves a fixed list of services, e.g. the targets of a route.
+type StaticDiscovery struct {
+	mu      sync.RWMutex
+	service map[string]*registry.Service // addr -> service
+	order   []string
+}
+
+func New(services ...*registry.Service) *StaticDiscovery {
+	discovery := &StaticDiscovery{
+		service: make(map[string]*registry.Service),
+		order:   make([]string, 0, len(services)),
+	}
+
+	for _, v := range services {
+		discovery.PutServer(v)
+	}
+
+	return discovery
+}
+
+func (s *StaticDiscovery) GetService() ([]*registry.Service, error) {
+	return s.ListServer(), nil
+}
+
+func (s *StaticDiscovery) PutServer(service *registry.Service) {
+	if service == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.service[service.Addr]; !ok {
+		s.order = append(s.order, service.Addr)
+	}
+	s.service[service.Addr] = service
+}
+
+func (s *StaticDiscovery) DelServer(service *registry.Service) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.service[service.Addr]; !ok {
+		return
+	}
+
+	delete(s.service, service.Addr)
+	for i, v := range s.order {
+		if v == service.Addr {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// ListServer returns services in the order they were added, so that round robin stays stable.
+func (s *StaticDiscovery) ListServer() []*registry.Service {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	servers := make([]*registry.Service, 0, len(s.order))
+	for _, v := range s.order {
+		servers = append(servers, s.service[v])
+	}
+
+	return servers
+}
+
+func (s *StaticDiscovery) Scheme() string {
+	return "static"
+}
@@ -0,0 +1,386 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/KKKKjl/tinykit/internal/transcode"
+)
+
+const (
+	_defaultDescriptorTTL = 5 * time.Minute
+	_defaultLoadTimeout   = 5 * time.Second
+	_defaultWarmInterval  = 5 * time.Second
+
+	// a missing service or method reloads the descriptors at most once per interval
+	_minReloadInterval = 10 * time.Second
+)
+
+var (
+	ServiceNotFoundErr = errors.New("Rpc service not implemented.")
+	BindingNotFoundErr = errors.New("No rpc method bound to the request.")
+)
+
+type (
+	// DescriptorConfig of the service descriptors resolved by reflection. The descriptors of a server are
+	// refreshed in the background after TTL, Warmup resolves them once the server is discovered.
+	// Services are loaded from files, they are used before reflection for the servers without it.
+	DescriptorConfig struct {
+		TTL      time.Duration
+		Warmup   bool
+		Services map[string]*desc.ServiceDescriptor // fully-qualified name -> descriptor
+	}
+
+	descriptorEntry struct {
+		services   map[string]*desc.ServiceDescriptor // fully-qualified name -> descriptor
+		table      *transcode.Table                   // http bindings of the services
+		err        error
+		loaded     time.Time
+		ready      chan struct{} // closed once loaded
+		refreshing bool
+	}
+
+	// DescriptorCache keeps the service descriptors of the rpc servers by addr, so a transcoded request
+	// costs one rpc instead of listing and resolving the services first. The descriptors of the servers
+	// gone from the discovery are dropped.
+	DescriptorCache struct {
+		conf    DescriptorConfig
+		pool    *ConnPool
+		members func() []string // addrs of the discovered servers
+		mu      sync.Mutex
+		entries map[string]*descriptorEntry // addr -> descriptors
+		table   *transcode.Table            // http bindings of the services loaded from files
+		done    chan struct{}
+		once    sync.Once
+		now     func() time.Time
+	}
+)
+
+// NewDescriptorCache creates a cache resolving the descriptors through the connections of the pool,
+// members returns the addrs of the discovered servers, nil members keeps the descriptors until closed.
+func NewDescriptorCache(c DescriptorConfig, pool *ConnPool, members func() []string) *DescriptorCache {
+	if c.TTL <= 0 {
+		c.TTL = _defaultDescriptorTTL
+	}
+
+	d := &DescriptorCache{
+		conf:    c,
+		pool:    pool,
+		members: members,
+		entries: make(map[string]*descriptorEntry),
+		table:   transcode.NewTable(c.Services),
+		done:    make(chan struct{}),
+		now:     time.Now,
+	}
+
+	if members != nil {
+		go d.run()
+	}
+
+	return d
+}
+
+// FindMethod returns the descriptor of the method of the service served at addr, service is the
+// fully-qualified name, e.g. helloworld.Greeter. The services loaded from files are resolved first.
+func (d *DescriptorCache) FindMethod(ctx context.Context, addr, service, method string) (*desc.MethodDescriptor, error) {
+	if _, ok := d.conf.Services[service]; ok {
+		return findMethod(d.conf.Services, service, method)
+	}
+
+	e, err := d.get(ctx, addr)
+	if status.Code(err) == codes.Unimplemented {
+		// reflection disabled
+		return nil, fmt.Errorf("%w: %s", ServiceNotFoundErr, service)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := findMethod(e.services, service, method)
+	if err == nil {
+		return m, nil
+	}
+
+	// the server may have been redeployed with new services since the load
+	if !d.expire(addr, e) {
+		return nil, err
+	}
+
+	if e, err = d.get(ctx, addr); err != nil {
+		return nil, err
+	}
+
+	return findMethod(e.services, service, method)
+}
+
+// Match returns the rpc method bound to the http method and the escaped path by the google.api.http options
+// of the services at addr and the values of the path variables. The services loaded from files are matched first.
+func (d *DescriptorCache) Match(ctx context.Context, addr, method, path string) (*transcode.Binding, map[string]string, error) {
+	if b, vars, ok := d.table.Match(method, path); ok {
+		return b, vars, nil
+	}
+
+	e, err := d.get(ctx, addr)
+	if status.Code(err) == codes.Unimplemented {
+		return nil, nil, fmt.Errorf("%w: %s %s", BindingNotFoundErr, method, path)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if b, vars, ok := e.table.Match(method, path); ok {
+		return b, vars, nil
+	}
+
+	return nil, nil, fmt.Errorf("%w: %s %s", BindingNotFoundErr, method, path)
+}
+
+// AnyResolver resolves the message types of the services loaded from files and resolved at addr,
+// e.g. the types of the error details. A nil cache resolves the registered types only.
+func (d *DescriptorCache) AnyResolver(addr string) jsonpb.AnyResolver {
+	if d == nil {
+		return dynamic.AnyResolver(nil)
+	}
+
+	files := make([]*desc.FileDescriptor, 0, len(d.conf.Services))
+	for _, v := range d.conf.Services {
+		files = append(files, v.GetFile())
+	}
+
+	d.mu.Lock()
+	e, ok := d.entries[addr]
+	d.mu.Unlock()
+
+	if ok && e.isReady() && e.err == nil {
+		for _, v := range e.services {
+			files = append(files, v.GetFile())
+		}
+	}
+
+	return dynamic.AnyResolver(nil, files...)
+}
+
+// Invalidate drops the descriptors of addr, they are resolved again by the next request.
+func (d *DescriptorCache) Invalidate(addr string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.entries, addr)
+}
+
+// Close stops dropping and warming the descriptors.
+func (d *DescriptorCache) Close() {
+	d.once.Do(func() { close(d.done) })
+}
+
+// get returns the loaded descriptors of addr, the expired ones are returned while refreshed.
+func (d *DescriptorCache) get(ctx context.Context, addr string) (*descriptorEntry, error) {
+	d.mu.Lock()
+	e, ok := d.entries[addr]
+	switch {
+	case !ok:
+		e = d.load(addr)
+		d.entries[addr] = e
+	case e.isReady() && !e.refreshing && d.now().Sub(e.loaded) > d.conf.TTL:
+		e.refreshing = true
+		go d.refresh(addr, e)
+	}
+	d.mu.Unlock()
+
+	select {
+	case <-e.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if e.err != nil {
+		d.mu.Lock()
+		if d.entries[addr] == e {
+			delete(d.entries, addr)
+		}
+		d.mu.Unlock()
+
+		return nil, e.err
+	}
+
+	return e, nil
+}
+
+// load resolves the descriptors of addr in the background.
+func (d *DescriptorCache) load(addr string) *descriptorEntry {
+	e := &descriptorEntry{ready: make(chan struct{})}
+
+	go func() {
+		defer close(e.ready)
+
+		e.services, e.err = d.fetch(addr)
+		e.table = transcode.NewTable(e.services)
+		e.loaded = d.now()
+	}()
+
+	return e
+}
+
+// refresh replaces the expired descriptors once loaded, the expired ones are kept for another TTL on error.
+func (d *DescriptorCache) refresh(addr string, expired *descriptorEntry) {
+	e := d.load(addr)
+	<-e.ready
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	expired.refreshing = false
+
+	if e.err != nil {
+		mainLog.Warnf("Refresh descriptors of %s error: %v", addr, e.err)
+		expired.loaded = d.now()
+		return
+	}
+
+	if d.entries[addr] == expired {
+		d.entries[addr] = e
+	}
+}
+
+// expire drops the descriptors loaded at least the min reload interval ago, it reports whether they were dropped.
+func (d *DescriptorCache) expire(addr string, e *descriptorEntry) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.entries[addr] != e || d.now().Sub(e.loaded) < _minReloadInterval {
+		return false
+	}
+
+	delete(d.entries, addr)
+	return true
+}
+
+// fetch resolves all the services of addr with one reflection stream.
+func (d *DescriptorCache) fetch(addr string) (map[string]*desc.ServiceDescriptor, error) {
+	conn, release, err := d.pool.Get(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), _defaultLoadTimeout)
+	defer cancel()
+
+	source := NewSource(ctx, conn)
+	defer source.Reset()
+
+	names, err := source.ListServices()
+	if err != nil {
+		return nil, err
+	}
+
+	services := make(map[string]*desc.ServiceDescriptor, len(names))
+	for _, v := range names {
+		service, err := source.ResolveService(v)
+		if err != nil {
+			mainLog.Warnf("Resolve service %s of %s error: %v", v, addr, err)
+			continue
+		}
+
+		services[v] = service
+	}
+
+	return services, nil
+}
+
+func (d *DescriptorCache) run() {
+	ticker := time.NewTicker(_defaultWarmInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.sync()
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// sync drops the descriptors of the servers gone from the discovery and warms up the new ones.
+func (d *DescriptorCache) sync() {
+	addrs := d.members()
+
+	members := make(map[string]struct{}, len(addrs))
+	for _, v := range addrs {
+		members[v] = struct{}{}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for addr := range d.entries {
+		if _, ok := members[addr]; !ok {
+			delete(d.entries, addr)
+		}
+	}
+
+	if !d.conf.Warmup {
+		return
+	}
+
+	for addr := range members {
+		if _, ok := d.entries[addr]; ok {
+			continue
+		}
+
+		e := d.load(addr)
+		d.entries[addr] = e
+
+		go func(addr string) {
+			<-e.ready
+			if e.err == nil {
+				return
+			}
+
+			mainLog.Debugf("Warm up descriptors of %s error: %v", addr, e.err)
+
+			d.mu.Lock()
+			defer d.mu.Unlock()
+
+			if d.entries[addr] == e {
+				delete(d.entries, addr)
+			}
+		}(addr)
+	}
+}
+
+func (e *descriptorEntry) isReady() bool {
+	select {
+	case <-e.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// findMethod returns the method of the service matched by its fully-qualified name.
+func findMethod(services map[string]*desc.ServiceDescriptor, service, method string) (*desc.MethodDescriptor, error) {
+	s, ok := services[service]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ServiceNotFoundErr, service)
+	}
+
+	m := s.FindMethodByName(method)
+	if m == nil {
+		return nil, fmt.Errorf("%w: %s/%s", MethodNotImplErr, service, method)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,105 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+func newReflectionServer(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(s, health.NewServer())
+	reflection.Register(s)
+
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	return lis.Addr().String()
+}
+
+func TestDescriptorCache(t *testing.T) {
+	assert := assert.New(t)
+
+	addr := newReflectionServer(t)
+
+	pool := NewConnPool(PoolConfig{}, nil)
+	defer pool.Close()
+
+	d := NewDescriptorCache(DescriptorConfig{TTL: time.Minute}, pool, nil)
+	defer d.Close()
+
+	now := time.Unix(1000, 0)
+	d.now = func() time.Time { return now }
+
+	m, err := d.FindMethod(context.Background(), addr, "grpc.health.v1.Health", "Check")
+	assert.Nil(err)
+	assert.Equal("grpc.health.v1.Health.Check", m.GetFullyQualifiedName())
+
+	// services are matched by their fully-qualified names
+	_, err = d.FindMethod(context.Background(), addr, "Health", "Check")
+	assert.True(errors.Is(err, ServiceNotFoundErr))
+
+	_, err = d.FindMethod(context.Background(), addr, "grpc.health.v1.Health", "Ping")
+	assert.True(errors.Is(err, MethodNotImplErr))
+
+	// served from the cache
+	e := d.entries[addr]
+	_, err = d.FindMethod(context.Background(), addr, "grpc.health.v1.Health", "Watch")
+	assert.Nil(err)
+	assert.Same(e, d.entries[addr])
+	assert.Equal(uint64(1), pool.Stats().Dials)
+
+	// the expired descriptors are served while refreshed
+	now = now.Add(2 * time.Minute)
+	_, err = d.FindMethod(context.Background(), addr, "grpc.health.v1.Health", "Check")
+	assert.Nil(err)
+	assert.Eventually(func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.entries[addr] != e
+	}, time.Second, 10*time.Millisecond)
+
+	d.Invalidate(addr)
+	assert.Empty(d.entries)
+}
+
+func TestDescriptorCacheSync(t *testing.T) {
+	assert := assert.New(t)
+
+	addr := newReflectionServer(t)
+	members := []string{addr}
+
+	pool := NewConnPool(PoolConfig{}, nil)
+	defer pool.Close()
+
+	d := NewDescriptorCache(DescriptorConfig{Warmup: true}, pool, nil)
+	d.members = func() []string { return members }
+
+	d.sync()
+	d.mu.Lock()
+	e := d.entries[addr]
+	d.mu.Unlock()
+
+	assert.NotNil(e)
+	<-e.ready
+	assert.Nil(e.err)
+	assert.Contains(e.services, "grpc.health.v1.Health")
+
+	// dropped once gone from the discovery
+	members = nil
+	d.sync()
+	assert.Empty(d.entries)
+}
@@ -0,0 +1,80 @@
+package request
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/golang/protobuf/proto"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+)
+
+// LoadServices loads the service descriptors of the files by fully-qualified name. The .proto files are
+// parsed with the import paths, relative to them if any, the others are descriptor sets as written by
+// protoc --descriptor_set_out --include_imports.
+func LoadServices(files []string, importPaths []string) (map[string]*desc.ServiceDescriptor, error) {
+	var (
+		fds    []*desc.FileDescriptor
+		protos []string
+	)
+
+	for _, v := range files {
+		if filepath.Ext(v) == ".proto" {
+			protos = append(protos, v)
+			continue
+		}
+
+		set, err := loadDescriptorSet(v)
+		if err != nil {
+			return nil, fmt.Errorf("descriptor set %s: %w", v, err)
+		}
+
+		fds = append(fds, set...)
+	}
+
+	if len(protos) > 0 {
+		parser := protoparse.Parser{ImportPaths: importPaths}
+
+		parsed, err := parser.ParseFiles(protos...)
+		if err != nil {
+			return nil, err
+		}
+
+		fds = append(fds, parsed...)
+	}
+
+	services := make(map[string]*desc.ServiceDescriptor)
+	for _, fd := range fds {
+		for _, v := range fd.GetServices() {
+			services[v.GetFullyQualifiedName()] = v
+		}
+	}
+
+	return services, nil
+}
+
+func loadDescriptorSet(file string) ([]*desc.FileDescriptor, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var set dpb.FileDescriptorSet
+	if err := proto.Unmarshal(buf, &set); err != nil {
+		return nil, err
+	}
+
+	files, err := desc.CreateFileDescriptorsFromSet(&set)
+	if err != nil {
+		return nil, err
+	}
+
+	fds := make([]*desc.FileDescriptor, 0, len(files))
+	for _, v := range set.GetFile() {
+		fds = append(fds, files[v.GetName()])
+	}
+
+	return fds, nil
+}
@@ -0,0 +1,98 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const greeterProto = `syntax = "proto3";
+
+package helloworld;
+
+import "google/protobuf/empty.proto";
+
+service Greeter {
+  rpc SayHello (HelloRequest) returns (HelloReply);
+  rpc Ping (google.protobuf.Empty) returns (google.protobuf.Empty);
+}
+
+message HelloRequest {
+  string name = 1;
+}
+
+message HelloReply {
+  string message = 1;
+}
+`
+
+func TestLoadServices(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, "greeter.proto"), []byte(greeterProto), 0644))
+
+	services, err := LoadServices([]string{"greeter.proto"}, []string{dir})
+	assert.Nil(err)
+	assert.Contains(services, "helloworld.Greeter")
+
+	// the descriptor set of the parsed file including its imports
+	fd := services["helloworld.Greeter"].GetFile()
+	set := desc.ToFileDescriptorSet(append(fd.GetDependencies(), fd)...)
+	buf, err := proto.Marshal(set)
+	assert.Nil(err)
+
+	file := filepath.Join(dir, "greeter.pb")
+	assert.Nil(ioutil.WriteFile(file, buf, 0644))
+
+	services, err = LoadServices([]string{file}, nil)
+	assert.Nil(err)
+	assert.Len(services, 1)
+	assert.NotNil(services["helloworld.Greeter"].FindMethodByName("SayHello"))
+
+	_, err = LoadServices([]string{filepath.Join(dir, "missing.pb")}, nil)
+	assert.NotNil(err)
+}
+
+func TestDescriptorCacheServices(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, "greeter.proto"), []byte(greeterProto), 0644))
+
+	services, err := LoadServices([]string{"greeter.proto"}, []string{dir})
+	assert.Nil(err)
+
+	// a server without reflection
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+
+	s := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(s, health.NewServer())
+	go s.Serve(lis)
+	defer s.Stop()
+
+	pool := NewConnPool(PoolConfig{}, nil)
+	defer pool.Close()
+
+	d := NewDescriptorCache(DescriptorConfig{Services: services}, pool, nil)
+	defer d.Close()
+
+	m, err := d.FindMethod(context.Background(), lis.Addr().String(), "helloworld.Greeter", "SayHello")
+	assert.Nil(err)
+	assert.Equal("helloworld.HelloRequest", m.GetInputType().GetFullyQualifiedName())
+	assert.Equal(uint64(0), pool.Stats().Dials)
+
+	_, err = d.FindMethod(context.Background(), lis.Addr().String(), "grpc.health.v1.Health", "Check")
+	assert.True(errors.Is(err, ServiceNotFoundErr))
+}
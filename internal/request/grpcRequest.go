@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"strings"
 	"time"
 
 	"github.com/jhump/protoreflect/desc"
@@ -15,6 +14,8 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+
+	"github.com/KKKKjl/tinykit/internal/transcode"
 )
 
 var (
@@ -22,6 +23,10 @@ var (
 	NilClientConnErr      = errors.New("Client conn is nil.")
 	MethodNotImplErr      = errors.New("Rpc method not implemented.")
 	NotImplProtoMsgErr    = errors.New("Not implment proto message.")
+
+	// UnavailableErr means the rpc server could not be dialed, the failures of the dialed connections
+	// are rpc statuses with code Unavailable.
+	UnavailableErr = errors.New("rpc server unavailable")
 )
 
 type (
@@ -30,6 +35,11 @@ type (
 		ServiceMethod string
 		Metadata      metadata.MD
 		Data          []byte
+
+		// set by the transcoded requests instead of resolving the method by name
+		Method       *desc.MethodDescriptor
+		Message      *dynamic.Message
+		ResponseBody string // field of the response written as the body
 	}
 
 	RPCResponse struct {
@@ -40,55 +50,40 @@ type (
 	}
 
 	RPCClient struct {
-		stub   grpcdynamic.Stub
-		source *Source
+		stub        grpcdynamic.Stub
+		addr        string
+		descriptors *DescriptorCache
 	}
 )
 
-func NewRPCClient(ctx context.Context, conn *grpc.ClientConn) *RPCClient {
+// NewRPCClient creates a client of the server at addr, the methods are resolved by the descriptors.
+func NewRPCClient(conn *grpc.ClientConn, addr string, descriptors *DescriptorCache) *RPCClient {
 	return &RPCClient{
-		stub:   grpcdynamic.NewStub(conn),
-		source: NewSource(ctx, conn),
+		stub:        grpcdynamic.NewStub(conn),
+		addr:        addr,
+		descriptors: descriptors,
 	}
 }
 
 func (g *RPCClient) Call(ctx context.Context, message RPCRequest) (*RPCResponse, error) {
-	if message.ServiceMethod == "" || message.ServicePath == "" {
-		return nil, EmptyRpcParametersErr
-	}
-
-	services, err := g.source.ListServices()
-	if err != nil {
-		return nil, err
-	}
-
-	var path string
-	for _, v := range services {
-		if strings.Contains(v, message.ServicePath) {
-			path = v
-			break
+	methodDesc, msg := message.Method, message.Message
+	if methodDesc == nil {
+		if message.ServiceMethod == "" || message.ServicePath == "" {
+			return nil, EmptyRpcParametersErr
 		}
-	}
 
-	if path == "" {
-		return nil, fmt.Errorf("rpc server %s not implemented.", message.ServicePath)
-	}
-
-	desc, err := g.source.ResolveService(path)
-	if err != nil {
-		return nil, err
-	}
+		var err error
+		methodDesc, err = g.descriptors.FindMethod(ctx, g.addr, message.ServicePath, message.ServiceMethod)
+		if err != nil {
+			return nil, err
+		}
 
-	methodDesc := desc.FindMethodByName(message.ServiceMethod)
-	if methodDesc == nil {
-		return nil, fmt.Errorf("service path %s not include method %s", message.ServicePath, message.ServiceMethod)
+		if msg, err = g.createMsg(methodDesc, message.Data); err != nil {
+			return nil, err
+		}
 	}
 
 	ctx = metadata.NewOutgoingContext(ctx, message.Metadata)
-	msg, err := g.createMsg(methodDesc, message.Data)
-	if err != nil {
-		return nil, err
-	}
 
 	var (
 		headerMD  metadata.MD
@@ -102,7 +97,7 @@ func (g *RPCClient) Call(ctx context.Context, message RPCRequest) (*RPCResponse,
 	if methodDesc.IsClientStreaming() || methodDesc.IsServerStreaming() {
 		go g.invokeWithServiceStream(ctx, methodDesc, msg, done, dataChan)
 	} else {
-		go g.invokeWithUnary(ctx, methodDesc, msg, &headerMD, &trailerMD, done, dataChan)
+		go g.invokeWithUnary(ctx, methodDesc, msg, message.ResponseBody, &headerMD, &trailerMD, done, dataChan)
 	}
 
 	return &RPCResponse{
@@ -116,27 +111,21 @@ func (g *RPCClient) Call(ctx context.Context, message RPCRequest) (*RPCResponse,
 func (g *RPCClient) createMsg(desc *desc.MethodDescriptor, data []byte) (*dynamic.Message, error) {
 	msg := dynamic.NewMessage(desc.GetInputType())
 	if err := msg.UnmarshalJSON(data); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", transcode.InvalidRequestErr, err)
 	}
 
 	return msg, nil
 }
 
-func (g *RPCClient) invokeWithUnary(ctx context.Context, methodDesc *desc.MethodDescriptor, msg *dynamic.Message, headerMD *metadata.MD, trailerMD *metadata.MD, done chan error, dataChan chan []byte) {
+func (g *RPCClient) invokeWithUnary(ctx context.Context, methodDesc *desc.MethodDescriptor, msg *dynamic.Message, responseBody string, headerMD *metadata.MD, trailerMD *metadata.MD, done chan error, dataChan chan []byte) {
 	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
 	defer cancel()
 
 	resp, err := g.stub.InvokeRpc(ctx, methodDesc, msg, grpc.Header(headerMD), grpc.Trailer(trailerMD))
 	if err != nil {
-		stat, ok := status.FromError(err)
-		if !ok {
-			done <- errors.New("unknown err")
-			return
-		}
-
-		if stat.Code() == codes.Unavailable {
-			done <- errors.New("rpc server unavailable")
-			return
+		// the server no longer serves the cached method
+		if status.Code(err) == codes.Unimplemented {
+			g.descriptors.Invalidate(g.addr)
 		}
 
 		done <- err
@@ -149,7 +138,7 @@ func (g *RPCClient) invokeWithUnary(ctx context.Context, methodDesc *desc.Method
 		return
 	}
 
-	buf, err := res.MarshalJSON()
+	buf, err := transcode.MarshalResponse(res, responseBody)
 	if err != nil {
 		done <- err
 		return
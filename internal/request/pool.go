@@ -0,0 +1,305 @@
+package request
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+
+	"github.com/KKKKjl/tinykit/logger"
+)
+
+const (
+	_defaultIdleTimeout   = 10 * time.Minute
+	_defaultSweepInterval = 30 * time.Second
+)
+
+var (
+	log     = logger.GetLogger()
+	mainLog = log.WithField("prefix", "pool")
+
+	PoolClosedErr = errors.New("Connection pool is closed.")
+
+	// pools shared by name, e.g. by the proxies of an upstream before and after a reload
+	sharedMu sync.Mutex
+	pools    = make(map[string]*ConnPool)
+)
+
+type (
+	// PoolConfig of the client connections to the upstream rpc servers.
+	// Nil TLS means plaintext, zero keepalive time disables the client keepalive pings.
+	// The pools of the same non empty Name share their connections, see AcquirePool.
+	PoolConfig struct {
+		Name        string
+		TLS         *tls.Config
+		Keepalive   keepalive.ClientParameters
+		IdleTimeout time.Duration // unused connections are closed after it
+	}
+
+	// PoolStats is a snapshot of the connection pool.
+	PoolStats struct {
+		Dials     uint64      `json:"dials"`
+		Hits      uint64      `json:"hits"` // requests served by an existing connection
+		Evictions uint64      `json:"evictions"`
+		Conns     []ConnStats `json:"conns"`
+	}
+
+	ConnStats struct {
+		Addr     string    `json:"addr"`
+		State    string    `json:"state"`
+		Inflight int       `json:"inflight"`
+		Requests uint64    `json:"requests"`
+		Created  time.Time `json:"created"`
+		LastUsed time.Time `json:"lastUsed"`
+	}
+
+	pooledConn struct {
+		conn     *grpc.ClientConn
+		inflight int
+		requests uint64
+		created  time.Time
+		lastUsed time.Time
+		evicted  bool // closed once the inflight requests are released
+	}
+
+	// ConnPool keeps one long-lived multiplexed connection per rpc server addr. The connections are evicted
+	// when their addr disappears from the discovery or after the idle timeout, and closed once released.
+	ConnPool struct {
+		conf    PoolConfig
+		opts    []grpc.DialOption
+		mu      sync.Mutex
+		conns   map[string]*pooledConn // addr -> conn
+		stats   PoolStats
+		closed  bool
+		members func() []string // addrs of the discovered servers
+		refs    int             // guarded by sharedMu
+		done    chan struct{}
+		now     func() time.Time
+	}
+)
+
+// NewConnPool creates a pool, members returns the addrs of the discovered servers, the connections
+// of the other addrs are evicted periodically. Nil members keeps the connections until they are idle.
+func NewConnPool(c PoolConfig, members func() []string) *ConnPool {
+	if c.IdleTimeout <= 0 {
+		c.IdleTimeout = _defaultIdleTimeout
+	}
+
+	creds := insecure.NewCredentials()
+	if c.TLS != nil {
+		creds = credentials.NewTLS(c.TLS)
+	}
+
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if c.Keepalive.Time > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(c.Keepalive))
+	}
+
+	p := &ConnPool{
+		conf:    c,
+		opts:    opts,
+		conns:   make(map[string]*pooledConn),
+		members: members,
+		done:    make(chan struct{}),
+		now:     time.Now,
+	}
+
+	go p.run()
+
+	return p
+}
+
+// AcquirePool returns the pool of the name of the config, the connections are kept while it is acquired,
+// members replaces the one of the pool. Release must be called once the pool is unused.
+func AcquirePool(c PoolConfig, members func() []string) *ConnPool {
+	if c.Name == "" {
+		return NewConnPool(c, members)
+	}
+
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+
+	p, ok := pools[c.Name]
+	if ok {
+		p.mu.Lock()
+		p.members = members
+		p.mu.Unlock()
+	} else {
+		p = NewConnPool(c, members)
+		pools[c.Name] = p
+	}
+	p.refs++
+
+	return p
+}
+
+// Release closes the pool once released by all the users it was returned to by AcquirePool.
+func (p *ConnPool) Release() {
+	if p.conf.Name == "" {
+		p.Close()
+		return
+	}
+
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+
+	p.refs--
+	if p.refs <= 0 {
+		if pools[p.conf.Name] == p {
+			delete(pools, p.conf.Name)
+		}
+		p.Close()
+	}
+}
+
+// Get returns the connection of the addr, dialing it in the background if there is none.
+// release must be called once the request is done, a failed dial is an UnavailableErr.
+func (p *ConnPool) Get(addr string) (conn *grpc.ClientConn, release func(), err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, nil, PoolClosedErr
+	}
+
+	c, ok := p.conns[addr]
+	if ok {
+		p.stats.Hits++
+	} else {
+		conn, err := grpc.Dial(addr, p.opts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: dial %s: %v", UnavailableErr, addr, err)
+		}
+
+		c = &pooledConn{conn: conn, created: p.now()}
+		p.conns[addr] = c
+		p.stats.Dials++
+	}
+
+	c.inflight++
+	c.requests++
+	c.lastUsed = p.now()
+
+	var once sync.Once
+	return c.conn, func() {
+		once.Do(func() { p.release(c) })
+	}, nil
+}
+
+func (p *ConnPool) release(c *pooledConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c.inflight--
+	c.lastUsed = p.now()
+
+	if c.evicted && c.inflight == 0 {
+		c.conn.Close()
+	}
+}
+
+// evict removes the connection of addr from the pool, the lock must be held.
+func (p *ConnPool) evict(addr string, c *pooledConn) {
+	delete(p.conns, addr)
+	p.stats.Evictions++
+
+	c.evicted = true
+	if c.inflight == 0 {
+		c.conn.Close()
+	}
+}
+
+func (p *ConnPool) run() {
+	ticker := time.NewTicker(_defaultSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.sweep()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// sweep evicts the connections of the servers gone from the discovery and the idle ones.
+func (p *ConnPool) sweep() {
+	p.mu.Lock()
+	list := p.members
+	p.mu.Unlock()
+
+	var members map[string]struct{}
+	if list != nil {
+		addrs := list()
+		members = make(map[string]struct{}, len(addrs))
+		for _, v := range addrs {
+			members[v] = struct{}{}
+		}
+	}
+
+	now := p.now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for addr, c := range p.conns {
+		if _, ok := members[addr]; members != nil && !ok {
+			mainLog.Infof("Evict connection of %s, gone from the discovery.", addr)
+			p.evict(addr, c)
+			continue
+		}
+
+		if c.inflight == 0 && now.Sub(c.lastUsed) > p.conf.IdleTimeout {
+			p.evict(addr, c)
+		}
+	}
+}
+
+// Stats returns the counters and the connections sorted by addr.
+func (p *ConnPool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := p.stats
+	stats.Conns = make([]ConnStats, 0, len(p.conns))
+	for addr, c := range p.conns {
+		stats.Conns = append(stats.Conns, ConnStats{
+			Addr:     addr,
+			State:    c.conn.GetState().String(),
+			Inflight: c.inflight,
+			Requests: c.requests,
+			Created:  c.created,
+			LastUsed: c.lastUsed,
+		})
+	}
+
+	sort.Slice(stats.Conns, func(i, j int) bool {
+		return stats.Conns[i].Addr < stats.Conns[j].Addr
+	})
+
+	return stats
+}
+
+// Close evicts all the connections, the connections in use are closed once released.
+func (p *ConnPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+	p.closed = true
+	close(p.done)
+
+	for addr, c := range p.conns {
+		p.evict(addr, c)
+	}
+}
@@ -0,0 +1,128 @@
+package request
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+)
+
+func TestConnPoolReuse(t *testing.T) {
+	assert := assert.New(t)
+
+	p := NewConnPool(PoolConfig{}, nil)
+	defer p.Close()
+
+	a, release, err := p.Get("127.0.0.1:1")
+	assert.Nil(err)
+	release()
+	release() // released once
+
+	b, release, err := p.Get("127.0.0.1:1")
+	assert.Nil(err)
+	defer release()
+
+	assert.Same(a, b)
+
+	stats := p.Stats()
+	assert.Equal(uint64(1), stats.Dials)
+	assert.Equal(uint64(1), stats.Hits)
+	assert.Len(stats.Conns, 1)
+	assert.Equal(1, stats.Conns[0].Inflight)
+	assert.Equal(uint64(2), stats.Conns[0].Requests)
+}
+
+func TestConnPoolSweep(t *testing.T) {
+	assert := assert.New(t)
+
+	members := []string{"127.0.0.1:1", "127.0.0.1:2"}
+	p := NewConnPool(PoolConfig{IdleTimeout: time.Minute}, func() []string { return members })
+	defer p.Close()
+
+	now := time.Unix(1000, 0)
+	p.now = func() time.Time { return now }
+
+	gone, release, err := p.Get("127.0.0.1:1")
+	assert.Nil(err)
+
+	idle, done, err := p.Get("127.0.0.1:2")
+	assert.Nil(err)
+	done()
+
+	// the connection gone from the discovery is closed once released
+	members = members[1:]
+	p.sweep()
+	assert.Equal(uint64(1), p.Stats().Evictions)
+	assert.NotEqual(connectivity.Shutdown, gone.GetState())
+
+	release()
+	assert.Equal(connectivity.Shutdown, gone.GetState())
+
+	now = now.Add(2 * time.Minute)
+	p.sweep()
+	assert.Equal(connectivity.Shutdown, idle.GetState())
+	assert.Empty(p.Stats().Conns)
+	assert.Equal(uint64(2), p.Stats().Evictions)
+}
+
+func TestConnPoolDialError(t *testing.T) {
+	assert := assert.New(t)
+
+	p := NewConnPool(PoolConfig{}, nil)
+	defer p.Close()
+
+	_, _, err := p.Get("%zz")
+	assert.ErrorIs(err, UnavailableErr)
+	assert.Equal(codes.Unavailable, Status(err).Code())
+}
+
+func TestConnPoolClose(t *testing.T) {
+	assert := assert.New(t)
+
+	p := NewConnPool(PoolConfig{}, nil)
+
+	conn, release, err := p.Get("127.0.0.1:1")
+	assert.Nil(err)
+
+	p.Close()
+	assert.NotEqual(connectivity.Shutdown, conn.GetState())
+
+	_, _, err = p.Get("127.0.0.1:1")
+	assert.Equal(PoolClosedErr, err)
+
+	release()
+	assert.Equal(connectivity.Shutdown, conn.GetState())
+}
+
+func TestAcquirePool(t *testing.T) {
+	assert := assert.New(t)
+
+	a := AcquirePool(PoolConfig{Name: "routes/a"}, nil)
+	conn, release, err := a.Get("127.0.0.1:1")
+	assert.Nil(err)
+	release()
+
+	// shared until released by all
+	b := AcquirePool(PoolConfig{Name: "routes/a"}, func() []string { return []string{"127.0.0.1:1"} })
+	assert.Same(a, b)
+	assert.NotSame(a, AcquirePool(PoolConfig{Name: "routes/b"}, nil))
+
+	a.Release()
+	reused, release, err := b.Get("127.0.0.1:1")
+	assert.Nil(err)
+	release()
+	assert.Same(conn, reused)
+
+	b.Release()
+	assert.Equal(connectivity.Shutdown, conn.GetState())
+	assert.NotSame(b, AcquirePool(PoolConfig{Name: "routes/a"}, nil))
+
+	// unnamed pools are not shared
+	c := AcquirePool(PoolConfig{}, nil)
+	assert.NotSame(c, AcquirePool(PoolConfig{}, nil))
+	c.Release()
+	_, _, err = c.Get("127.0.0.1:1")
+	assert.ErrorIs(err, PoolClosedErr)
+}
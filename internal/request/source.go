@@ -26,3 +26,8 @@ func (s *Source) ListServices() ([]string, error) {
 func (s *Source) ResolveService(name string) (*desc.ServiceDescriptor, error) {
 	return s.client.ResolveService(name)
 }
+
+// Reset releases the reflection stream.
+func (s *Source) Reset() {
+	s.client.Reset()
+}
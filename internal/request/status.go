@@ -0,0 +1,118 @@
+package request
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/golang/protobuf/jsonpb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/KKKKjl/tinykit/internal/transcode"
+)
+
+// statusBody is the json body of a failed rpc, compatible with grpc-gateway.
+type statusBody struct {
+	Code    int               `json:"code"` // grpc code
+	Message string            `json:"message"`
+	Details []json.RawMessage `json:"details"`
+}
+
+// HTTPStatusFromCode maps the grpc code to the http status.
+// reference: https://github.com/googleapis/googleapis/blob/master/google/rpc/code.proto
+func HTTPStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499 // client closed request
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		// Unknown, Internal and DataLoss
+		return http.StatusInternalServerError
+	}
+}
+
+// Status returns the grpc status of err, the errors of the gateway are converted to their codes.
+func Status(err error) *status.Status {
+	var se interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &se) {
+		return se.GRPCStatus()
+	}
+
+	code := codes.Unknown
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, BindingNotFoundErr):
+		code = codes.NotFound
+	case errors.Is(err, ServiceNotFoundErr), errors.Is(err, MethodNotImplErr):
+		code = codes.Unimplemented
+	case errors.Is(err, transcode.InvalidRequestErr), errors.Is(err, EmptyRpcParametersErr):
+		code = codes.InvalidArgument
+	case errors.Is(err, UnavailableErr), errors.Is(err, PoolClosedErr):
+		code = codes.Unavailable
+	}
+
+	return status.New(code, err.Error())
+}
+
+// MarshalStatus renders the status as json, the details are rendered by the types resolved by the
+// resolver, the ones it can not resolve are rendered with their type url and base64 value.
+func MarshalStatus(st *status.Status, resolver jsonpb.AnyResolver) ([]byte, error) {
+	body := statusBody{
+		Code:    int(st.Code()),
+		Message: st.Message(),
+		Details: make([]json.RawMessage, 0, len(st.Proto().GetDetails())),
+	}
+
+	marshaler := jsonpb.Marshaler{AnyResolver: resolver}
+	for _, v := range st.Proto().GetDetails() {
+		detail, err := marshaler.MarshalToString(v)
+		if err != nil {
+			buf, err := json.Marshal(map[string]interface{}{"@type": v.GetTypeUrl(), "value": v.GetValue()})
+			if err != nil {
+				return nil, err
+			}
+			detail = string(buf)
+		}
+
+		body.Details = append(body.Details, json.RawMessage(detail))
+	}
+
+	return json.Marshal(body)
+}
+
+// RetryAfter returns the retry delay of the google.rpc.RetryInfo detail of the status.
+func RetryAfter(st *status.Status) (time.Duration, bool) {
+	for _, v := range st.Details() {
+		if info, ok := v.(*errdetails.RetryInfo); ok && info.GetRetryDelay() != nil {
+			return info.GetRetryDelay().AsDuration(), true
+		}
+	}
+
+	return 0, false
+}
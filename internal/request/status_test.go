@@ -0,0 +1,97 @@
+package request
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/KKKKjl/tinykit/internal/transcode"
+)
+
+func TestHTTPStatusFromCode(t *testing.T) {
+	assert := assert.New(t)
+
+	for code, want := range map[codes.Code]int{
+		codes.OK:                 http.StatusOK,
+		codes.NotFound:           http.StatusNotFound,
+		codes.InvalidArgument:    http.StatusBadRequest,
+		codes.Unauthenticated:    http.StatusUnauthorized,
+		codes.PermissionDenied:   http.StatusForbidden,
+		codes.ResourceExhausted:  http.StatusTooManyRequests,
+		codes.FailedPrecondition: http.StatusBadRequest,
+		codes.AlreadyExists:      http.StatusConflict,
+		codes.Unimplemented:      http.StatusNotImplemented,
+		codes.Unavailable:        http.StatusServiceUnavailable,
+		codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+		codes.Canceled:           499,
+		codes.DataLoss:           http.StatusInternalServerError,
+	} {
+		assert.Equal(want, HTTPStatusFromCode(code), code.String())
+	}
+}
+
+func TestStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	for err, want := range map[error]codes.Code{
+		status.Error(codes.NotFound, "not found"):                      codes.NotFound,
+		fmt.Errorf("call: %w", status.Error(codes.Aborted, "aborted")): codes.Aborted,
+		fmt.Errorf("%w: /v1/books", BindingNotFoundErr):                codes.NotFound,
+		fmt.Errorf("%w: helloworld.Greeter", ServiceNotFoundErr):       codes.Unimplemented,
+		fmt.Errorf("%w: field name", transcode.InvalidRequestErr):      codes.InvalidArgument,
+		fmt.Errorf("wait descriptors: %w", context.DeadlineExceeded):   codes.DeadlineExceeded,
+		PoolClosedErr: codes.Unavailable,
+		fmt.Errorf("dial tcp: connection refused"): codes.Unknown,
+	} {
+		assert.Equal(want, Status(err).Code(), err.Error())
+	}
+}
+
+func TestMarshalStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	st, err := status.New(codes.ResourceExhausted, "slow down").WithDetails(
+		&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: "name", Description: "required"}}},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(1500 * time.Millisecond)},
+		&errdetails.ErrorInfo{Reason: "QUOTA", Domain: "tinykit", Metadata: map[string]string{"limit": "10"}},
+	)
+	assert.Nil(err)
+
+	// a detail of an unknown type
+	pb := st.Proto()
+	pb.Details = append(pb.Details, &anypb.Any{TypeUrl: "type.googleapis.com/acme.Unknown", Value: []byte{1}})
+	st = status.FromProto(pb)
+
+	buf, err := MarshalStatus(st, nil)
+	assert.Nil(err)
+	assert.JSONEq(`{
+		"code": 8,
+		"message": "slow down",
+		"details": [
+			{"@type": "type.googleapis.com/google.rpc.BadRequest", "fieldViolations": [{"field": "name", "description": "required"}]},
+			{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "1.500s"},
+			{"@type": "type.googleapis.com/google.rpc.ErrorInfo", "reason": "QUOTA", "domain": "tinykit", "metadata": {"limit": "10"}},
+			{"@type": "type.googleapis.com/acme.Unknown", "value": "AQ=="}
+		]
+	}`, string(buf))
+
+	delay, ok := RetryAfter(st)
+	assert.True(ok)
+	assert.Equal(1500*time.Millisecond, delay)
+
+	buf, err = MarshalStatus(status.New(codes.NotFound, "missing"), nil)
+	assert.Nil(err)
+	assert.JSONEq(`{"code": 5, "message": "missing", "details": []}`, string(buf))
+
+	_, ok = RetryAfter(status.New(codes.NotFound, "missing"))
+	assert.False(ok)
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/KKKKjl/tinykit/internal/proxy"
+	"github.com/KKKKjl/tinykit/internal/registry/health"
+	"github.com/KKKKjl/tinykit/internal/registry/outlier"
+	"github.com/KKKKjl/tinykit/internal/request"
+)
+
+const (
+	// defaultUpstream is the name of the upstream of requests matching no route.
+	defaultUpstream = "default"
+
+	// globalFilters is the scope of the filters of all the requests.
+	globalFilters = "filters"
+)
+
+type (
+	// HealthHandler serves the health states of the upstream services by route name,
+	// routes without health check are omitted.
+	HealthHandler struct {
+		gateway *GatewayServer
+	}
+
+	// OutlierHandler serves the outlier states and the ejection counters of the upstream services by route name,
+	// routes without outlier detection are omitted.
+	OutlierHandler struct {
+		gateway *GatewayServer
+	}
+
+	// PoolHandler serves the stats of the pooled grpc connections by route name.
+	PoolHandler struct {
+		gateway *GatewayServer
+	}
+)
+
+func NewHealthHandler(gateway *GatewayServer) *HealthHandler {
+	return &HealthHandler{gateway: gateway}
+}
+
+// Status returns the health states of the current snapshot.
+func (h *HealthHandler) Status() map[string][]health.Status {
+	upstreams := make(map[string][]health.Status)
+	h.gateway.current().eachProxy(func(name string, p *proxy.Proxy) {
+		if status := p.HealthStatus(); status != nil {
+			upstreams[name] = status
+		}
+	})
+
+	return upstreams
+}
+
+func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	serveStatus(w, req, h.Status())
+}
+
+func NewOutlierHandler(gateway *GatewayServer) *OutlierHandler {
+	return &OutlierHandler{gateway: gateway}
+}
+
+// Status returns the outlier stats of the current snapshot.
+func (h *OutlierHandler) Status() map[string]outlier.Stats {
+	upstreams := make(map[string]outlier.Stats)
+	h.gateway.current().eachProxy(func(name string, p *proxy.Proxy) {
+		if stats := p.OutlierStats(); stats != nil {
+			upstreams[name] = *stats
+		}
+	})
+
+	return upstreams
+}
+
+func (h *OutlierHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	serveStatus(w, req, h.Status())
+}
+
+func NewPoolHandler(gateway *GatewayServer) *PoolHandler {
+	return &PoolHandler{gateway: gateway}
+}
+
+// Status returns the connection pool stats of the current snapshot.
+func (h *PoolHandler) Status() map[string]request.PoolStats {
+	upstreams := make(map[string]request.PoolStats)
+	h.gateway.current().eachProxy(func(name string, p *proxy.Proxy) {
+		if stats := p.PoolStats(); stats != nil {
+			upstreams[name] = *stats
+		}
+	})
+
+	return upstreams
+}
+
+func (h *PoolHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	serveStatus(w, req, h.Status())
+}
+
+// eachProxy calls fn with the route proxies and the default one.
+func (s *snapshot) eachProxy(fn func(name string, p *proxy.Proxy)) {
+	for _, v := range s.router.Routes() {
+		fn(v.Name, v.proxy)
+	}
+
+	if s.proxy != nil {
+		fn(defaultUpstream, s.proxy)
+	}
+}
+
+func serveStatus(w http.ResponseWriter, req *http.Request, status interface{}) {
+	if req.Method != http.MethodGet {
+		defaultErrorHandler(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(status)
+}
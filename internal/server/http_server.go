@@ -3,58 +3,67 @@ package server
 import (
 	"context"
 	"errors"
-	"net"
 	"net/http"
 	"strconv"
+	"sync/atomic"
 	"time"
 
+	"github.com/KKKKjl/tinykit/config"
 	tx "github.com/KKKKjl/tinykit/internal/context"
 	"github.com/KKKKjl/tinykit/internal/filter"
 	"github.com/KKKKjl/tinykit/internal/proxy"
 	"github.com/KKKKjl/tinykit/internal/server/ws"
 	"github.com/KKKKjl/tinykit/logger"
 	"github.com/google/uuid"
-	"github.com/spf13/viper"
 )
 
 var (
 	log     = logger.GetLogger()
 	mainLog = log.WithField("prefix", "main")
 
-	_defaultAddr string
+	_defaultAddr = "0.0.0.0:8080"
 )
 
 var (
 	RpcRequestTimeoutErr = errors.New("rpc request timeout")
 )
 
-type GatewayServer struct {
-	Server     *http.Server
-	Timeout    time.Duration
-	ApiPath    string
-	TlsEnabled bool
-	proxy      *proxy.Proxy
-	chains     *filter.FilterChains
-	wsHandler  *ws.WsHanlder
-}
+type (
+	GatewayServer struct {
+		Servers   []*http.Server
+		Listeners []config.ListenerConfig
+		Timeout   time.Duration // deadline of a request, zero means none, upgraded connections have none
+		ApiPath   string
+		snapshot  atomic.Value  // *snapshot, swapped on config reload
+		wsHandler *ws.WsHanlder // nil disables the /ws endpoint
+	}
 
-func init() {
-	port := viper.GetString("HTTP_PORT")
-	if port != "" {
-		_defaultAddr = net.JoinHostPort("0.0.0.0", port)
-	} else {
-		_defaultAddr = "0.0.0.0:8080"
+	// snapshot holds everything built from one config version.
+	// In-flight requests keep the snapshot they started with, it is closed once the last one is done.
+	snapshot struct {
+		router *Router
+		chains *filter.FilterChains // chain of requests matching no route
+		proxy  *proxy.Proxy         // proxy of requests matching no route
+		refs   int64                // requests using the snapshot, plus one until it is swapped out
 	}
-}
+)
 
 func New(proxy *proxy.Proxy, opts ...Option) *GatewayServer {
 	gatewayServer := &GatewayServer{
+		Listeners: []config.ListenerConfig{{Addr: _defaultAddr}},
 		Timeout:   5 * time.Second,
 		ApiPath:   "/",
-		proxy:     proxy,
 		wsHandler: ws.NewWsHanlder(),
 	}
 
+	gatewayServer.snapshot.Store(&snapshot{
+		router: new(Router),
+		chains: filter.NewFilterChains(),
+		proxy:  proxy,
+		refs:   1,
+	})
+
+	// options are applied before serving, so they can modify the initial snapshot in place
 	for _, opt := range opts {
 		opt(gatewayServer)
 	}
@@ -63,18 +72,25 @@ func New(proxy *proxy.Proxy, opts ...Option) *GatewayServer {
 }
 
 func (g *GatewayServer) Start(ctx context.Context) {
-	mainLog.Infof("Start http server at addr: %s", _defaultAddr)
-
 	mux := http.NewServeMux()
 	mux.HandleFunc(g.ApiPath, g.dispatch)
-	mux.Handle("/ws", g.wsHandler)
+	if g.wsHandler != nil {
+		mux.Handle("/ws", g.wsHandler)
+	}
 	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("pong"))
 	})
 
-	g.Server = &http.Server{
-		Addr:    _defaultAddr,
-		Handler: mux,
+	for _, v := range g.Listeners {
+		mainLog.Infof("Start http server at addr: %s(tls: %t)", v.Addr, v.TLS.Enabled)
+
+		server := &http.Server{
+			Addr:    v.Addr,
+			Handler: mux,
+		}
+		g.Servers = append(g.Servers, server)
+
+		go g.runServer(server, v.TLS)
 	}
 
 	go func() {
@@ -83,11 +99,9 @@ func (g *GatewayServer) Start(ctx context.Context) {
 		<-ctx.Done()
 		mainLog.Infof("Shutting down the http server gracefully.")
 	}()
-
-	go g.runServer()
 }
 
-func (g *GatewayServer) runServer() {
+func (g *GatewayServer) runServer(server *http.Server, tls config.TLSConfig) {
 	defer func() {
 		if err := recover(); err != nil {
 			mainLog.Fatalf("Recover from error: %v", err)
@@ -95,10 +109,10 @@ func (g *GatewayServer) runServer() {
 	}()
 
 	var err error
-	if g.TlsEnabled {
-		err = g.Server.ListenAndServeTLS("server.crt", "server.key")
+	if tls.Enabled {
+		err = server.ListenAndServeTLS(tls.CertFile, tls.KeyFile)
 	} else {
-		err = g.Server.ListenAndServe()
+		err = server.ListenAndServe()
 	}
 
 	if err != nil && err != http.ErrServerClosed {
@@ -107,19 +121,95 @@ func (g *GatewayServer) runServer() {
 }
 
 func (g *GatewayServer) Stop() {
-	if err := g.Server.Shutdown(context.Background()); err != nil {
-		mainLog.Errorf("Failed to shutdown http server: %v", err)
+	for _, server := range g.Servers {
+		if err := server.Shutdown(context.Background()); err != nil {
+			mainLog.Errorf("Failed to shutdown http server %s: %v", server.Addr, err)
+		}
 	}
 	mainLog.Debug("Shutdown the http server gracefully.")
 }
 
-// TODO router suppport
+// Router returns the route table currently in use.
+func (g *GatewayServer) Router() *Router {
+	return g.current().router
+}
+
+func (g *GatewayServer) current() *snapshot {
+	return g.snapshot.Load().(*snapshot)
+}
+
+// acquire returns the current snapshot, it must be released once the request is done.
+func (g *GatewayServer) acquire() *snapshot {
+	for {
+		// the loaded snapshot may be swapped out and closed before it is acquired
+		if s := g.current(); s.acquire() {
+			return s
+		}
+	}
+}
+
+// swap atomically replaces the snapshot and returns the previous one, in-flight requests keep using it.
+func (g *GatewayServer) swap(s *snapshot) *snapshot {
+	return g.snapshot.Swap(s).(*snapshot)
+}
+
+// acquire adds a reference to the snapshot, it reports false if the snapshot is already closed.
+func (s *snapshot) acquire() bool {
+	for {
+		refs := atomic.LoadInt64(&s.refs)
+		if refs <= 0 {
+			return false
+		}
+
+		if atomic.CompareAndSwapInt64(&s.refs, refs, refs+1) {
+			return true
+		}
+	}
+}
+
+// release drops a reference to the snapshot, the last one closes it.
+func (s *snapshot) release() {
+	if atomic.AddInt64(&s.refs, -1) == 0 {
+		s.close()
+	}
+}
+
+// close stops the background tasks of the snapshot and closes its rpc connections.
+func (s *snapshot) close() {
+	s.router.Close()
+	s.chains.Close()
+
+	if s.proxy != nil {
+		s.proxy.Close()
+	}
+}
+
 func (g *GatewayServer) dispatch(w http.ResponseWriter, r *http.Request) {
+	// the upgraded connections, e.g. websockets, live longer than a request
+	if g.Timeout > 0 && r.Header.Get("Upgrade") == "" {
+		c, cancel := context.WithTimeout(r.Context(), g.Timeout)
+		defer cancel()
+
+		r = r.WithContext(c)
+	}
+
 	// create newable context
 	ctx := tx.New(w, r)
 
-	// execute filter chain and proxy request
-	g.chains.Compose()(ctx, g.proxy.ServeHTTP)
+	s := g.acquire()
+	defer s.release()
+
+	if route, params := s.router.Match(r); route != nil {
+		for k, v := range params {
+			ctx.Params[k] = v
+		}
+
+		route.Serve(ctx)
+		return
+	}
+
+	// no route matched, execute default filter chain and proxy request
+	s.chains.Compose()(ctx, s.proxy.ServeHTTP)
 }
 
 // default error handler
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/KKKKjl/tinykit/internal/proxy"
+	"github.com/KKKKjl/tinykit/internal/registry"
+	"github.com/KKKKjl/tinykit/internal/registry/static"
+)
+
+func TestDispatchTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstream.Close()
+
+	p := proxy.New(proxy.ProxyConfig{LoadBalancingEnabled: true},
+		proxy.WithBuilder(static.New(&registry.Service{Addr: upstream.URL, Weight: 1})),
+	)
+	defer p.Close()
+
+	gateway := New(p, WithTimeout(50*time.Millisecond))
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	gateway.dispatch(w, httptest.NewRequest(http.MethodGet, "/hello", nil))
+
+	assert.Less(time.Since(start), 500*time.Millisecond)
+	assert.Equal(http.StatusBadGateway, w.Code)
+}
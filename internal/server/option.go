@@ -1,50 +1,77 @@
 package server
 
 import (
-	"fmt"
-	"reflect"
 	"time"
 
+	"github.com/KKKKjl/tinykit/config"
 	"github.com/KKKKjl/tinykit/internal/filter"
-	"github.com/KKKKjl/tinykit/internal/filter/filter_impl"
+	_ "github.com/KKKKjl/tinykit/internal/filter/filter_impl"
+	"github.com/KKKKjl/tinykit/internal/server/ws"
 )
 
 type Option func(*GatewayServer)
 
-var h = make(map[string]interface{})
+func WithFilters(chains ...string) Option {
+	return func(gs *GatewayServer) {
+		filters := make([]config.FilterConfig, 0, len(chains))
+		for _, v := range chains {
+			filters = append(filters, config.FilterConfig{Name: v})
+		}
+
+		filterChains, err := newFilterChains("", filters...)
+		if err != nil {
+			panic(err.Error())
+		}
 
-func init() {
-	h["ratelimit"] = filter_impl.InitRateLimit
-	h["cors"] = filter_impl.InitCorsLimit
+		gs.current().chains = filterChains
+	}
 }
 
-func WithFilters(chains ...string) Option {
+// WithRouter sets the route table used to dispatch requests.
+func WithRouter(router *Router) Option {
 	return func(gs *GatewayServer) {
-		gs.chains = filter.NewFilterChains()
+		gs.current().router = router
+	}
+}
 
-		for _, v := range chains {
-			val, ok := h[v]
-			if !ok {
-				panic(fmt.Sprintf("%s not registered", v))
-			}
+func WithListeners(listeners ...config.ListenerConfig) Option {
+	return func(gs *GatewayServer) {
+		gs.Listeners = listeners
+	}
+}
 
-			var mul = reflect.ValueOf(val)
-			if mul.Kind() != reflect.Func {
-				panic(fmt.Sprintf("%s not a function", v))
-			}
+// WithWsHandler sets the handler of the /ws endpoint, nil disables it.
+func WithWsHandler(handler *ws.WsHanlder) Option {
+	return func(gs *GatewayServer) {
+		gs.wsHandler = handler
+	}
+}
 
-			var res = mul.Call(nil)
+// IsFilterRegistered reports whether a filter with the given name can be used in config.
+func IsFilterRegistered(name string) bool {
+	_, ok := filter.Lookup(name)
+	return ok
+}
 
-			filter, ok := res[0].Interface().(filter.Handler)
-			if !ok {
-				panic(fmt.Sprintf("not"))
-			}
+// newFilterChains creates a filter chain of the scope from the registered filter factories, the filters
+// of the same scope may keep their state across reloads, empty scope means they do not.
+func newFilterChains(scope string, filters ...config.FilterConfig) (*filter.FilterChains, error) {
+	filterChains := filter.NewFilterChains()
 
-			gs.chains.Use(filter)
+	for _, v := range filters {
+		handler, err := filter.NewScoped(scope, v.Name, v.Params)
+		if err != nil {
+			filterChains.Close()
+			return nil, err
 		}
+
+		filterChains.Use(handler)
 	}
+
+	return filterChains, nil
 }
 
+// WithTimeout sets the deadline of every request, zero disables it.
 func WithTimeout(timeout time.Duration) Option {
 	return func(gs *GatewayServer) {
 		gs.Timeout = timeout
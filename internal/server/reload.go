@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/KKKKjl/tinykit/config"
+	"github.com/KKKKjl/tinykit/internal/proxy"
+	"github.com/KKKKjl/tinykit/utils"
+)
+
+type (
+	// ReloadStatus is the result of the last reload.
+	ReloadStatus struct {
+		File    string    `json:"file"`
+		Version uint64    `json:"version"` // count of successful loads
+		Success bool      `json:"success"`
+		Error   string    `json:"error,omitempty"`
+		Time    time.Time `json:"time"`
+	}
+
+	// Reloader rebuilds the routes, filter chains and proxies when the config file changes or SIGHUP is received.
+	// An invalid config is rejected and the previous snapshot is kept.
+	// Listeners, admin and registry changes require a restart.
+	Reloader struct {
+		file    string
+		gateway *GatewayServer
+		opts    []proxy.ProxyOption // applied to every proxy, e.g. the discovery builder
+		mu      sync.RWMutex
+		status  ReloadStatus
+	}
+)
+
+func NewReloader(file string, gateway *GatewayServer, opts ...proxy.ProxyOption) *Reloader {
+	return &Reloader{
+		file:    file,
+		gateway: gateway,
+		opts:    opts,
+		status: ReloadStatus{
+			File: file,
+		},
+	}
+}
+
+// Reload reads the config file and applies it.
+func (r *Reloader) Reload() error {
+	c, err := config.Load(r.file)
+	if err != nil {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.status.Time = time.Now()
+		return r.fail(err)
+	}
+
+	return r.Apply(c)
+}
+
+// Apply validates the config and swaps the gateway snapshot if the config is valid.
+func (r *Reloader) Apply(c *config.Config) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.status.Time = time.Now()
+
+	if err := Validate(c); err != nil {
+		return r.fail(err)
+	}
+
+	s, err := newSnapshot(c, r.opts...)
+	if err != nil {
+		return r.fail(err)
+	}
+
+	// the previous snapshot is closed once its in-flight requests are done
+	r.gateway.swap(s).release()
+
+	r.status.Version++
+	r.status.Success = true
+	r.status.Error = ""
+	mainLog.Infof("Load config %s successfully, version %d with %d routes.", r.file, r.status.Version, len(s.router.Routes()))
+
+	return nil
+}
+
+// newSnapshot builds the routes, the default filter chain and the default proxy from config.
+func newSnapshot(c *config.Config, opts ...proxy.ProxyOption) (*snapshot, error) {
+	router, err := NewRouter(c.Routes, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	chains, err := newFilterChains(globalFilters, c.Filters...)
+	if err != nil {
+		router.Close()
+		return nil, fmt.Errorf("filters: %w", err)
+	}
+
+	proxy, err := newProxy(defaultUpstream, c.Proxy, opts...)
+	if err != nil {
+		router.Close()
+		chains.Close()
+		return nil, fmt.Errorf("proxy: %w", err)
+	}
+
+	return &snapshot{
+		router: router,
+		chains: chains,
+		proxy:  proxy,
+		refs:   1,
+	}, nil
+}
+
+func (r *Reloader) fail(err error) error {
+	r.status.Success = false
+	r.status.Error = err.Error()
+	mainLog.Errorf("Load config %s failed, keep version %d: %v", r.file, r.status.Version, err)
+
+	return err
+}
+
+func (r *Reloader) Status() ReloadStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.status
+}
+
+// Watch reloads the config on file changes and SIGHUP until ctx is done.
+func (r *Reloader) Watch(ctx context.Context) error {
+	err := utils.WatchFile(ctx, r.file, func() {
+		r.Reload()
+	}, func(err error) {
+		mainLog.Errorf("Watch config %s error: %v", r.file, err)
+	})
+	if err != nil {
+		return err
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				mainLog.Info("Received SIGHUP, reloading config.")
+				r.Reload()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// ServeHTTP returns the last reload status, POST triggers a reload.
+func (r *Reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		r.Reload()
+	default:
+		defaultErrorHandler(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := r.Status()
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if !status.Success {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/KKKKjl/tinykit/internal/proxy"
+	"github.com/KKKKjl/tinykit/internal/registry/static"
+)
+
+const (
+	validRoutes = `
+routes:
+  - name: users
+    prefix: /users/
+    upstream:
+      targets:
+        - addr: http://localhost:8090
+`
+	invalidRoutes = `
+routes:
+  - name: users
+    regex: (/users
+    upstream:
+      targets:
+        - addr: http://localhost:8090
+`
+)
+
+func TestReload(t *testing.T) {
+	assert := assert.New(t)
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	assert.Nil(ioutil.WriteFile(file, []byte(validRoutes), 0644))
+
+	gateway := New(nil)
+	reloader := NewReloader(file, gateway, proxy.WithBuilder(static.New()))
+
+	assert.Nil(reloader.Reload())
+	assert.Len(gateway.Router().Routes(), 1)
+	assert.Equal(uint64(1), reloader.Status().Version)
+
+	// invalid config should be rejected and the previous routes kept
+	router := gateway.Router()
+	assert.Nil(ioutil.WriteFile(file, []byte(invalidRoutes), 0644))
+	assert.NotNil(reloader.Reload())
+	assert.Same(router, gateway.Router())
+
+	status := reloader.Status()
+	assert.False(status.Success)
+	assert.NotEmpty(status.Error)
+	assert.Equal(uint64(1), status.Version)
+}
+
+func TestReloadWatch(t *testing.T) {
+	assert := assert.New(t)
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	assert.Nil(ioutil.WriteFile(file, []byte("routes: []"), 0644))
+
+	gateway := New(nil)
+	reloader := NewReloader(file, gateway, proxy.WithBuilder(static.New()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	assert.Nil(reloader.Watch(ctx))
+	assert.Nil(ioutil.WriteFile(file, []byte(validRoutes), 0644))
+
+	assert.Eventually(func() bool {
+		return len(gateway.Router().Routes()) == 1
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+func TestReloadInflight(t *testing.T) {
+	assert := assert.New(t)
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	assert.Nil(ioutil.WriteFile(file, []byte(validRoutes), 0644))
+
+	gateway := New(nil)
+	reloader := NewReloader(file, gateway, proxy.WithBuilder(static.New()))
+	assert.Nil(reloader.Reload())
+
+	// the request in flight keeps the swapped out snapshot open
+	s := gateway.acquire()
+	assert.Nil(reloader.Reload())
+	assert.NotSame(s, gateway.current())
+	assert.Equal(int64(1), atomic.LoadInt64(&s.refs))
+
+	s.release()
+	assert.Equal(int64(0), atomic.LoadInt64(&s.refs))
+	assert.False(s.acquire())
+}
+
+func TestReloadOutlier(t *testing.T) {
+	assert := assert.New(t)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	assert.Nil(ioutil.WriteFile(file, []byte(fmt.Sprintf(`
+routes:
+  - name: outliers
+    prefix: /outliers/
+    upstream:
+      targets:
+        - addr: %s
+    proxy:
+      outlierDetection:
+        enabled: true
+        consecutiveErrors: 1
+        maxEjectionPercent: 100
+`, upstream.URL)), 0644))
+
+	gateway := New(nil)
+	reloader := NewReloader(file, gateway, proxy.WithBuilder(static.New()))
+	assert.Nil(reloader.Reload())
+	defer gateway.current().close()
+
+	gateway.dispatch(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/outliers/1", nil))
+
+	// the ejection survives the reload
+	assert.Nil(reloader.Reload())
+	stats := NewOutlierHandler(gateway).Status()["outliers"]
+	assert.Equal(uint64(1), stats.Ejections)
+	assert.Equal(1, stats.Ejected)
+}
+
+func TestReloadPool(t *testing.T) {
+	assert := assert.New(t)
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	assert.Nil(ioutil.WriteFile(file, []byte(`
+routes:
+  - name: rpc
+    prefix: /rpc/
+    upstream:
+      targets:
+        - addr: http://127.0.0.1:1
+    proxy:
+      grpc:
+        transcoding: true
+  - name: http
+    prefix: /http/
+    upstream:
+      targets:
+        - addr: http://127.0.0.1:1
+`), 0644))
+
+	gateway := New(nil)
+	reloader := NewReloader(file, gateway, proxy.WithBuilder(static.New()))
+	assert.Nil(reloader.Reload())
+
+	gateway.dispatch(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/rpc/hello", nil))
+
+	// the connections of the rpc route survive the reload, the http route has none
+	assert.Nil(reloader.Reload())
+	defer gateway.current().close()
+
+	status := NewPoolHandler(gateway).Status()
+	assert.NotContains(status, "http")
+	if assert.Contains(status, "rpc") {
+		assert.Equal(uint64(1), status["rpc"].Dials)
+		assert.Len(status["rpc"].Conns, 1)
+	}
+}
@@ -0,0 +1,428 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"google.golang.org/grpc/keepalive"
+
+	"github.com/KKKKjl/tinykit/config"
+	tx "github.com/KKKKjl/tinykit/internal/context"
+	"github.com/KKKKjl/tinykit/internal/extractor"
+	"github.com/KKKKjl/tinykit/internal/filter"
+	"github.com/KKKKjl/tinykit/internal/proxy"
+	"github.com/KKKKjl/tinykit/internal/registry"
+	"github.com/KKKKjl/tinykit/internal/registry/balance"
+	"github.com/KKKKjl/tinykit/internal/registry/health"
+	"github.com/KKKKjl/tinykit/internal/registry/outlier"
+	"github.com/KKKKjl/tinykit/internal/registry/static"
+	"github.com/KKKKjl/tinykit/internal/request"
+	"github.com/KKKKjl/tinykit/internal/rewrite"
+	"github.com/KKKKjl/tinykit/utils"
+)
+
+var (
+	MultiplePathMatcherErr = errors.New("only one of path, prefix and regex can be set")
+
+	// matches path params like {id}
+	paramRegexp = regexp.MustCompile(`\{([a-zA-Z_][a-zA-Z0-9_]*)\}`)
+)
+
+type (
+	// Route binds a request matcher to its own upstream, filter chain and proxy.
+	Route struct {
+		Name    string
+		host    string
+		methods map[string]struct{}
+		path    string         // exact path without params
+		prefix  string         // path prefix without params
+		regex   *regexp.Regexp // user regex or compiled path template
+		chains  *filter.FilterChains
+		proxy   *proxy.Proxy
+	}
+
+	// Router matches requests against routes in the declared order, first match wins.
+	Router struct {
+		routes []*Route
+	}
+)
+
+// NewRouter creates the routes, opts are applied to every route proxy, e.g. the discovery builder.
+func NewRouter(routes []config.RouteConfig, opts ...proxy.ProxyOption) (*Router, error) {
+	router := &Router{
+		routes: make([]*Route, 0, len(routes)),
+	}
+
+	for i, v := range routes {
+		// the name also keeps the state of the route, e.g. the rate limits, across reloads
+		if v.Name == "" {
+			v.Name = fmt.Sprintf("route-%d", i)
+		}
+
+		route, err := NewRoute(v, opts...)
+		if err != nil {
+			router.Close()
+			return nil, fmt.Errorf("routes[%d](%s): %w", i, v.Name, err)
+		}
+
+		router.routes = append(router.routes, route)
+	}
+
+	return router, nil
+}
+
+// NewRoute compiles the route matcher and creates its filter chain and proxy.
+func NewRoute(c config.RouteConfig, opts ...proxy.ProxyOption) (*Route, error) {
+	route := &Route{
+		Name:    c.Name,
+		host:    strings.ToLower(c.Host),
+		methods: make(map[string]struct{}, len(c.Methods)),
+	}
+
+	for _, v := range c.Methods {
+		route.methods[strings.ToUpper(v)] = struct{}{}
+	}
+
+	if err := route.compilePath(c); err != nil {
+		return nil, err
+	}
+
+	chains, err := newFilterChains(routeUpstream(c.Name), c.Filters...)
+	if err != nil {
+		return nil, err
+	}
+	route.chains = chains
+
+	proxy, err := newRouteProxy(c, opts...)
+	if err != nil {
+		chains.Close()
+		return nil, err
+	}
+	route.proxy = proxy
+
+	return route, nil
+}
+
+func (r *Route) compilePath(c config.RouteConfig) error {
+	var set int
+	for _, v := range []string{c.Path, c.Prefix, c.Regex} {
+		if v != "" {
+			set++
+		}
+	}
+
+	if set > 1 {
+		return MultiplePathMatcherErr
+	}
+
+	var err error
+	switch {
+	case c.Regex != "":
+		r.regex, err = regexp.Compile(c.Regex)
+	case paramRegexp.MatchString(c.Path):
+		r.regex, err = compileTemplate(c.Path, true)
+	case paramRegexp.MatchString(c.Prefix):
+		r.regex, err = compileTemplate(c.Prefix, false)
+	default:
+		r.path = c.Path
+		r.prefix = c.Prefix
+	}
+
+	return err
+}
+
+func newRouteProxy(c config.RouteConfig, opts ...proxy.ProxyOption) (*proxy.Proxy, error) {
+	if len(c.Upstream.Subsets) > 0 {
+		subsets := make([]proxy.Subset, 0, len(c.Upstream.Subsets))
+		for _, v := range c.Upstream.Subsets {
+			subsets = append(subsets, proxy.Subset{
+				Name:    v.Name,
+				Version: v.Version,
+				Labels:  v.Labels,
+				Weight:  v.Weight,
+				Headers: v.Headers,
+			})
+		}
+
+		opts = append(opts[:len(opts):len(opts)], proxy.WithSubsets(subsets...))
+	}
+
+	if len(c.Upstream.Targets) == 0 {
+		if c.Upstream.Service != "" {
+			opts = append(opts[:len(opts):len(opts)], proxy.WithService(c.Upstream.Service, c.Upstream.Version))
+		}
+
+		return newProxy(routeUpstream(c.Name), c.Proxy, opts...)
+	}
+
+	services := make([]*registry.Service, 0, len(c.Upstream.Targets))
+	for _, v := range c.Upstream.Targets {
+		if v.Addr == "" {
+			return nil, errors.New("upstream target addr is required")
+		}
+
+		weight := v.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+
+		metadata := v.Metadata
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+
+		services = append(services, &registry.Service{
+			Name:     c.Name,
+			Version:  v.Version,
+			Addr:     v.Addr,
+			Weight:   weight,
+			Metadata: metadata,
+		})
+	}
+
+	// static targets are always load balanced
+	c.Proxy.LoadBalancingEnabled = true
+
+	return newProxy(routeUpstream(c.Name), c.Proxy, append(opts[:len(opts):len(opts)], proxy.WithBuilder(static.New(services...)))...)
+}
+
+// routeUpstream returns the upstream name of the route, empty for the unnamed routes created by NewRoute.
+func routeUpstream(name string) string {
+	if name == "" {
+		return ""
+	}
+
+	return "routes/" + name
+}
+
+// newProxy creates a proxy from config, opts are applied before the rewrite rules.
+// The upstream name keeps the health and outlier states across reloads, empty name does not.
+func newProxy(name string, c config.ProxyConfig, opts ...proxy.ProxyOption) (*proxy.Proxy, error) {
+	rules := make([]*rewrite.Rule, 0, len(c.Rewrite))
+	for _, v := range c.Rewrite {
+		rule, err := rewrite.NewRule(v.Pattern, v.To)
+		if err != nil {
+			return nil, fmt.Errorf("rewrite rule %s: %w", v.Pattern, err)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	// opts are shared by the routes, never append in place
+	opts = opts[:len(opts):len(opts)]
+
+	if len(rules) > 0 {
+		opts = append(opts, proxy.WithRewriteRules(rules...))
+	}
+
+	if hc := c.HealthCheck; hc.Enabled {
+		opts = append(opts, proxy.WithHealthCheck(health.Config{
+			Name:               name,
+			Type:               hc.Type,
+			Path:               hc.Path,
+			Service:            hc.Service,
+			Interval:           hc.Interval,
+			Timeout:            hc.Timeout,
+			HealthyThreshold:   hc.HealthyThreshold,
+			UnhealthyThreshold: hc.UnhealthyThreshold,
+		}))
+	}
+
+	if c.Balancer.HashKey != "" {
+		key, err := extractor.Parse(c.Balancer.HashKey)
+		if err != nil {
+			return nil, fmt.Errorf("balancer hash key: %w", err)
+		}
+
+		opts = append(opts, proxy.WithHashKey(key))
+	}
+
+	if sc := c.StickySession; sc.Enabled {
+		opts = append(opts, proxy.WithStickySession(proxy.StickyConfig{
+			Cookie:   sc.Cookie,
+			Secret:   sc.Secret,
+			Path:     sc.Path,
+			MaxAge:   sc.MaxAge,
+			Secure:   sc.Secure,
+			HttpOnly: sc.HttpOnly,
+			SameSite: sameSite(sc.SameSite),
+		}))
+	}
+
+	if od := c.OutlierDetection; od.Enabled {
+		opts = append(opts, proxy.WithOutlierDetection(outlier.Config{
+			Name:               name,
+			ConsecutiveErrors:  od.ConsecutiveErrors,
+			BaseEjectionTime:   od.BaseEjectionTime,
+			MaxEjectionTime:    od.MaxEjectionTime,
+			MaxEjectionPercent: od.MaxEjectionPercent,
+		}))
+	}
+
+	// the connections are kept across reloads unless the pool config changes
+	pool := request.PoolConfig{
+		Name:        fmt.Sprintf("%s|%v|%+v|%+v", name, c.GRPC.IdleTimeout, c.GRPC.Keepalive, c.GRPC.TLS),
+		IdleTimeout: c.GRPC.IdleTimeout,
+		Keepalive: keepalive.ClientParameters{
+			Time:                c.GRPC.Keepalive.Time,
+			Timeout:             c.GRPC.Keepalive.Timeout,
+			PermitWithoutStream: c.GRPC.Keepalive.PermitWithoutStream,
+		},
+	}
+
+	if tc := c.GRPC.TLS; tc.Enabled {
+		tls, err := utils.NewTLSConfig(tc.CertFile, tc.KeyFile, tc.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("grpc tls: %w", err)
+		}
+
+		tls.ServerName = tc.ServerName
+		tls.InsecureSkipVerify = tc.InsecureSkipVerify
+		pool.TLS = tls
+	}
+	opts = append(opts, proxy.WithGRPC(pool))
+	descriptors := request.DescriptorConfig{
+		TTL:    c.GRPC.Descriptors.TTL,
+		Warmup: c.GRPC.Descriptors.Warmup,
+	}
+
+	// loaded again on every config reload
+	if len(c.GRPC.Descriptors.Files) > 0 {
+		services, err := request.LoadServices(c.GRPC.Descriptors.Files, c.GRPC.Descriptors.ImportPaths)
+		if err != nil {
+			return nil, fmt.Errorf("grpc descriptors: %w", err)
+		}
+
+		descriptors.Services = services
+	}
+	opts = append(opts, proxy.WithDescriptors(descriptors))
+
+	if c.GRPC.Transcoding {
+		opts = append(opts, proxy.WithTranscoding())
+	}
+
+	return proxy.New(proxy.ProxyConfig{
+		URLRewriteEnabled:    c.URLRewriteEnabled,
+		LoadBalancingEnabled: c.LoadBalancingEnabled,
+		ForceTlsEnabled:      c.ForceTlsEnabled,
+		BalancingType:        balance.BalanceType(c.Balancer.Type),
+	}, opts...), nil
+}
+
+// sameSite converts the config value, empty means the browser default.
+func sameSite(value string) http.SameSite {
+	switch strings.ToLower(value) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteDefaultMode
+	}
+}
+
+// Match checks the request against the route and returns the captured path params.
+func (r *Route) Match(req *http.Request) (map[string]string, bool) {
+	if r.host != "" && !matchHost(r.host, req.Host) {
+		return nil, false
+	}
+
+	if len(r.methods) > 0 {
+		if _, ok := r.methods[req.Method]; !ok {
+			return nil, false
+		}
+	}
+
+	path := req.URL.Path
+	switch {
+	case r.regex != nil:
+		matches := r.regex.FindStringSubmatch(path)
+		if matches == nil {
+			return nil, false
+		}
+
+		params := make(map[string]string)
+		for i, name := range r.regex.SubexpNames() {
+			if i > 0 && name != "" {
+				params[name] = matches[i]
+			}
+		}
+		return params, true
+	case r.path != "":
+		return nil, path == r.path
+	case r.prefix != "":
+		return nil, strings.HasPrefix(path, r.prefix)
+	}
+
+	return nil, true
+}
+
+// Serve executes the route filter chain and proxies the request to the route upstream.
+func (r *Route) Serve(ctx tx.HttpContext) {
+	r.chains.Compose()(ctx, r.proxy.ServeHTTP)
+}
+
+// Match returns the first route matching the request, or nil if none matched.
+func (r *Router) Match(req *http.Request) (*Route, map[string]string) {
+	for _, route := range r.routes {
+		if params, ok := route.Match(req); ok {
+			return route, params
+		}
+	}
+
+	return nil, nil
+}
+
+func (r *Router) Routes() []*Route {
+	return r.routes
+}
+
+// Close stops the background tasks of the route proxies, e.g. health checks.
+func (r *Router) Close() {
+	for _, v := range r.routes {
+		v.chains.Close()
+		v.proxy.Close()
+	}
+}
+
+// matchHost checks the request host(port stripped) against an exact or wildcard(*.example.com) host.
+func matchHost(pattern string, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+
+	return pattern == host
+}
+
+// compileTemplate converts a path template like /users/{id} to a regex with named groups.
+func compileTemplate(tpl string, exact bool) (*regexp.Regexp, error) {
+	var (
+		b    strings.Builder
+		last int
+	)
+
+	b.WriteString("^")
+	for _, loc := range paramRegexp.FindAllStringSubmatchIndex(tpl, -1) {
+		b.WriteString(regexp.QuoteMeta(tpl[last:loc[0]]))
+		b.WriteString("(?P<" + tpl[loc[2]:loc[3]] + ">[^/]+)")
+		last = loc[1]
+	}
+	b.WriteString(regexp.QuoteMeta(tpl[last:]))
+
+	if exact {
+		b.WriteString("$")
+	}
+
+	return regexp.Compile(b.String())
+}
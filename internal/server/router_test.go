@@ -0,0 +1,79 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/KKKKjl/tinykit/config"
+)
+
+func newTestRoute(c config.RouteConfig) config.RouteConfig {
+	c.Upstream = config.UpstreamConfig{
+		Targets: []config.TargetConfig{{Addr: "http://localhost:8090"}},
+	}
+	return c
+}
+
+func TestRouterMatch(t *testing.T) {
+	assert := assert.New(t)
+
+	router, err := NewRouter([]config.RouteConfig{
+		newTestRoute(config.RouteConfig{Name: "user", Methods: []string{"get"}, Path: "/users/{id}"}),
+		newTestRoute(config.RouteConfig{Name: "order", Host: "*.example.com", Prefix: "/orders/"}),
+		newTestRoute(config.RouteConfig{Name: "version", Regex: `^/v(?P<version>\d+)/`}),
+		newTestRoute(config.RouteConfig{Name: "exact", Path: "/ping"}),
+		newTestRoute(config.RouteConfig{Name: "files", Prefix: "/files/{bucket}/"}),
+	})
+	assert.Nil(err)
+
+	cases := []struct {
+		Method   string
+		URL      string
+		Expected string
+		Params   map[string]string
+	}{
+		{Method: "GET", URL: "http://localhost/users/1", Expected: "user", Params: map[string]string{"id": "1"}},
+		{Method: "POST", URL: "http://localhost/users/1", Expected: ""},
+		{Method: "GET", URL: "http://localhost/users/1/orders", Expected: ""},
+		{Method: "GET", URL: "http://api.example.com:8080/orders/1", Expected: "order"},
+		{Method: "GET", URL: "http://localhost/orders/1", Expected: ""},
+		{Method: "GET", URL: "http://localhost/v2/books", Expected: "version", Params: map[string]string{"version": "2"}},
+		{Method: "GET", URL: "http://localhost/ping", Expected: "exact"},
+		{Method: "GET", URL: "http://localhost/ping/", Expected: ""},
+		{Method: "GET", URL: "http://localhost/files/img/a.png", Expected: "files", Params: map[string]string{"bucket": "img"}},
+	}
+
+	for _, v := range cases {
+		req, err := http.NewRequest(v.Method, v.URL, nil)
+		assert.Nil(err)
+
+		route, params := router.Match(req)
+		if v.Expected == "" {
+			assert.Nilf(route, "%s %s expected no route", v.Method, v.URL)
+			continue
+		}
+
+		if assert.NotNilf(route, "%s %s expected route %s", v.Method, v.URL, v.Expected) {
+			assert.Equal(v.Expected, route.Name)
+			assert.Equal(v.Params, params)
+		}
+	}
+}
+
+func TestRouterInvalidConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := []config.RouteConfig{
+		newTestRoute(config.RouteConfig{Path: "/a", Prefix: "/b"}),
+		newTestRoute(config.RouteConfig{Regex: "(/a"}),
+		newTestRoute(config.RouteConfig{Path: "/a", Filters: []config.FilterConfig{{Name: "unknown"}}}),
+		{Path: "/a", Upstream: config.UpstreamConfig{Targets: []config.TargetConfig{{Weight: 1}}}},
+	}
+
+	for _, v := range cases {
+		_, err := NewRouter([]config.RouteConfig{v})
+		assert.NotNil(err)
+	}
+}
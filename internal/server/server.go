@@ -6,11 +6,22 @@ import (
 	"net/http/pprof"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 
 	"github.com/KKKKjl/tinykit/config"
+	"github.com/KKKKjl/tinykit/internal/broker/pubsub"
 	"github.com/KKKKjl/tinykit/internal/proxy"
-	"github.com/KKKKjl/tinykit/internal/registry/balance"
+	"github.com/KKKKjl/tinykit/internal/ratelimit"
+	"github.com/KKKKjl/tinykit/internal/ratelimit/redis"
+	"github.com/KKKKjl/tinykit/internal/registry"
+	"github.com/KKKKjl/tinykit/internal/registry/consul"
+	"github.com/KKKKjl/tinykit/internal/registry/dns"
+	"github.com/KKKKjl/tinykit/internal/registry/etcd"
+	"github.com/KKKKjl/tinykit/internal/registry/file"
+	"github.com/KKKKjl/tinykit/internal/registry/memory"
+	"github.com/KKKKjl/tinykit/internal/server/ws"
+	"github.com/KKKKjl/tinykit/utils"
 )
 
 type Server interface {
@@ -18,15 +29,20 @@ type Server interface {
 	Stop()
 }
 
-func prof(stop <-chan struct{}) {
+func prof(addr string, stop <-chan struct{}, handlers map[string]http.Handler) {
 	pprofServeMux := http.NewServeMux()
 	pprofServeMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 	pprofServeMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
 	pprofServeMux.HandleFunc("/debug/pprof/", pprof.Index)
 	pprofServeMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
 
+	// admin handlers
+	for pattern, handler := range handlers {
+		pprofServeMux.Handle(pattern, handler)
+	}
+
 	server := &http.Server{
-		Addr:    ":9090",
+		Addr:    addr,
 		Handler: pprofServeMux,
 	}
 
@@ -39,17 +55,27 @@ func prof(stop <-chan struct{}) {
 	server.ListenAndServe()
 }
 
-func Start() {
+// Start runs the gateway with the given config file, empty file means the default search paths.
+func Start(file string) {
 	mainLog.Info("Starting TinyKit.")
 
-	config.InitConfig()
+	cfg, err := config.Load(file)
+	if err != nil {
+		mainLog.Fatalf("Failed to load config: %v", err)
+	}
 
-	proxy := proxy.New(proxy.ProxyConfig{
-		URLRewriteEnabled:    false,
-		LoadBalancingEnabled: true,
-		ForceTlsEnabled:      false,
-		BalancingType:        balance.ROUND_ROBIN,
-	})
+	if err := Validate(cfg); err != nil {
+		mainLog.Fatalf("Invalid config %s:\n%v", cfg.File(), err)
+	}
+
+	var wsHandler *ws.WsHanlder
+	if cfg.Pubsub.Enabled {
+		wsHandler = ws.NewWsHanlder(
+			ws.WithPubsub(pubsub.New(pubsub.WithShards(cfg.Pubsub.Shards))),
+			ws.WithKeepAlive(cfg.Pubsub.KeepAlive),
+			ws.WithBufferSize(cfg.Pubsub.ReadBufferSize, cfg.Pubsub.WriteBufferSize),
+		)
+	}
 
 	done := make(chan struct{})
 
@@ -60,11 +86,41 @@ func Start() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	gateway := New(proxy, WithFilters("ratelimit"))
+	gateway := New(nil,
+		WithListeners(cfg.Server.Listeners...),
+		WithTimeout(cfg.Server.Timeout),
+		WithWsHandler(wsHandler),
+	)
+
+	// the shared store must be set before the filters are created
+	if store := newLimiterStore(cfg.RateLimit.Store); store != nil {
+		ratelimit.SetSharedStore(store)
+	}
+
+	builder, err := newBuilder(ctx, cfg)
+	if err != nil {
+		mainLog.Fatalf("Failed to create %s registry: %v", cfg.Registry.Backend, err)
+	}
+
+	reloader := NewReloader(cfg.File(), gateway, proxy.WithBuilder(builder))
+	if err := reloader.Apply(cfg); err != nil {
+		mainLog.Fatalf("Failed to apply config: %v", err)
+	}
+
 	gateway.Start(ctx)
 
-	// for debug
-	go prof(done)
+	if err := reloader.Watch(ctx); err != nil {
+		mainLog.Errorf("Failed to watch config, hot reload disabled: %v", err)
+	}
+
+	if cfg.Admin.Enabled {
+		go prof(cfg.Admin.Addr, done, map[string]http.Handler{
+			"/admin/reload":   reloader,
+			"/admin/health":   NewHealthHandler(gateway),
+			"/admin/outliers": NewOutlierHandler(gateway),
+			"/admin/grpc":     NewPoolHandler(gateway),
+		})
+	}
 
 	select {
 	case sig := <-waiter:
@@ -73,3 +129,111 @@ func Start() {
 		return
 	}
 }
+
+// newBuilder creates the service discovery of the configured registry backend,
+// the static and memory registries are also set as the default registry.
+func newBuilder(ctx context.Context, cfg *config.Config) (registry.Builder, error) {
+	c := cfg.Registry
+
+	switch c.Backend {
+	case "static":
+		// the services are listed in the config file unless a services file is given
+		path, key := cfg.File(), "registry.static.services"
+		if c.Static.File != "" {
+			path, key = c.Static.File, file.DefaultKey
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(filepath.Dir(cfg.File()), path)
+			}
+		}
+
+		r, err := file.New(path, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := r.Watch(ctx); err != nil {
+			mainLog.Errorf("Failed to watch services %s, reload disabled: %v", path, err)
+		}
+
+		registry.DefaultRegistry = r
+		return r.Builder(), nil
+	case "dns":
+		discovery, err := dns.New(dns.Config{
+			Server:   c.DNS.Server,
+			Search:   c.DNS.Search,
+			Ndots:    c.DNS.Ndots,
+			Scheme:   c.DNS.Scheme,
+			Port:     c.DNS.Port,
+			Interval: c.DNS.Interval,
+			Timeout:  c.DNS.Timeout,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return discovery, nil
+	case "consul":
+		return consul.New(consul.Config{
+			Address:    c.Consul.Address,
+			Token:      c.Consul.Token,
+			Datacenter: c.Consul.Datacenter,
+			Scheme:     c.Consul.Scheme,
+			Wait:       c.Consul.Wait,
+		}), nil
+	case "memory":
+		r := memory.New()
+
+		registry.DefaultRegistry = r
+		return r.Builder(), nil
+	default:
+		conf, err := EtcdConfig(c.Etcd)
+		if err != nil {
+			return nil, err
+		}
+
+		discovery, err := etcd.New(conf)
+		if err != nil {
+			return nil, err
+		}
+
+		return discovery, nil
+	}
+}
+
+// EtcdConfig converts the etcd registry config to the client config, loading the tls files.
+func EtcdConfig(c config.EtcdConfig) (*etcd.Config, error) {
+	conf := &etcd.Config{
+		Nodes:       c.Endpoints,
+		DialTimeout: c.DialTimeout,
+		Prefix:      c.Prefix,
+		Username:    c.Username,
+		Password:    c.Password,
+	}
+
+	if c.TLS.Enabled {
+		tls, err := utils.NewTLSConfig(c.TLS.CertFile, c.TLS.KeyFile, c.TLS.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.TLS = tls
+	}
+
+	return conf, nil
+}
+
+// newLimiterStore creates the store shared by the ratelimit filters, nil means the default in-memory store.
+func newLimiterStore(c config.LimiterStoreConfig) ratelimit.Store {
+	switch c.Backend {
+	case "redis":
+		return redis.New(redis.Config{
+			Addr:        c.Redis.Addr,
+			Password:    c.Redis.Password,
+			DB:          c.Redis.DB,
+			PoolSize:    c.Redis.PoolSize,
+			DialTimeout: c.Redis.DialTimeout,
+			Prefix:      c.Redis.Prefix,
+		})
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/KKKKjl/tinykit/config"
+	"github.com/KKKKjl/tinykit/internal/extractor"
+	"github.com/KKKKjl/tinykit/internal/filter"
+	"github.com/KKKKjl/tinykit/internal/registry/balance"
+)
+
+// Validate checks the config structure and the names resolved at runtime,
+// i.e. filters, balancer types and hash keys and route path templates. Every error is reported with its path.
+func Validate(c *config.Config) error {
+	var errs config.ValidationErrors
+
+	if err := c.Validate(); err != nil {
+		errs = append(errs, err.(config.ValidationErrors)...)
+	}
+
+	validateProxy(&errs, "proxy", c.Proxy)
+	validateFilters(&errs, "filters", c.Filters)
+
+	for i, v := range c.Routes {
+		path := fmt.Sprintf("routes[%d]", i)
+
+		if paramRegexp.MatchString(v.Path) {
+			if _, err := compileTemplate(v.Path, true); err != nil {
+				errs.Add(path+".path", "invalid path template: %v", err)
+			}
+		}
+
+		if paramRegexp.MatchString(v.Prefix) {
+			if _, err := compileTemplate(v.Prefix, false); err != nil {
+				errs.Add(path+".prefix", "invalid path template: %v", err)
+			}
+		}
+
+		validateProxy(&errs, path+".proxy", v.Proxy)
+		validateFilters(&errs, path+".filters", v.Filters)
+	}
+
+	return errs.Err()
+}
+
+func validateProxy(errs *config.ValidationErrors, path string, c config.ProxyConfig) {
+	if !balance.IsSupported(balance.BalanceType(c.Balancer.Type)) {
+		errs.Add(path+".balancer.type", "unknown balancer %q", c.Balancer.Type)
+	}
+
+	if c.Balancer.HashKey != "" {
+		if _, err := extractor.Parse(c.Balancer.HashKey); err != nil {
+			errs.Add(path+".balancer.hashKey", "%v", err)
+		}
+	}
+}
+
+func validateFilters(errs *config.ValidationErrors, path string, filters []config.FilterConfig) {
+	for i, v := range filters {
+		if v.Name == "" {
+			continue
+		}
+
+		if _, ok := filter.Lookup(v.Name); !ok {
+			errs.Add(fmt.Sprintf("%s[%d].name", path, i), "filter %q not registered", v.Name)
+			continue
+		}
+
+		// the filter is not created, e.g. no shared rate limiter is started by a rejected config
+		if err := filter.Validate(v.Name, v.Params); err != nil {
+			errs.Add(fmt.Sprintf("%s[%d].params", path, i), "%v", err)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/KKKKjl/tinykit/config"
+)
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &config.Config{
+		Server: config.ServerConfig{
+			Listeners: []config.ListenerConfig{{Addr: ":8080"}},
+		},
+		Proxy: config.ProxyConfig{
+			Balancer: config.BalancerConfig{Type: "random", HashKey: "body:json"},
+		},
+		Filters: []config.FilterConfig{{Name: "ratelimit"}},
+		Routes: []config.RouteConfig{
+			{Path: "/a", Filters: []config.FilterConfig{{Name: "cors"}, {Name: "unknown"}}},
+		},
+		Registry: config.RegistryConfig{
+			Backend: "etcd",
+			Etcd:    config.EtcdConfig{Endpoints: []string{"localhost:2379"}},
+		},
+	}
+
+	err := Validate(c)
+	if assert.NotNil(err) {
+		assert.Equal(`proxy.balancer.type: unknown balancer "random"`+"\n"+
+			`proxy.balancer.hashKey: unknown key "body:json"`+"\n"+
+			`routes[0].filters[1].name: filter "unknown" not registered`, err.Error())
+	}
+}
@@ -34,25 +34,63 @@ type PushMsg struct {
 	Op             string `json:"operation"`
 }
 
+type Option func(*WsHanlder)
+
 var _defaultNode *broker.Node
 
 func init() {
 	_defaultNode = &broker.Node{}
 }
 
-func NewWsHanlder() *WsHanlder {
+// WithPubsub sets the pubsub used to dispatch topic messages.
+func WithPubsub(ps *pubsub.Pubsub) Option {
+	return func(ws *WsHanlder) {
+		ws.pubsub = ps
+	}
+}
+
+// WithKeepAlive sets how long a connection stays alive without ping.
+func WithKeepAlive(interval time.Duration) Option {
+	return func(ws *WsHanlder) {
+		if interval > 0 {
+			ws.updateInterval = interval
+		}
+	}
+}
+
+func WithBufferSize(read int, write int) Option {
+	return func(ws *WsHanlder) {
+		if read > 0 {
+			ws.upgrader.ReadBufferSize = read
+		}
+		if write > 0 {
+			ws.upgrader.WriteBufferSize = write
+		}
+	}
+}
+
+func NewWsHanlder(opts ...Option) *WsHanlder {
 	upgrader := websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
 		//CheckOrigin:     CheckSameOrigin(),
 	}
 
-	return &WsHanlder{
+	ws := &WsHanlder{
 		upgrader:       upgrader,
 		logger:         logger.GetLogger(),
 		updateInterval: time.Second * 10,
-		pubsub:         pubsub.New(),
 	}
+
+	for _, opt := range opts {
+		opt(ws)
+	}
+
+	if ws.pubsub == nil {
+		ws.pubsub = pubsub.New()
+	}
+
+	return ws
 }
 
 func (ws *WsHanlder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
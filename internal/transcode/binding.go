@@ -0,0 +1,158 @@
+package transcode
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/jhump/protoreflect/desc"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/KKKKjl/tinykit/logger"
+)
+
+var (
+	log     = logger.GetLogger()
+	mainLog = log.WithField("prefix", "transcode")
+)
+
+type (
+	// Binding maps the requests matching the http method and the path template to the rpc method.
+	// Body is the field of the request message set by the request body, * for the whole message,
+	// ResponseBody is the field of the response message written as the response body.
+	Binding struct {
+		Method       *desc.MethodDescriptor
+		HTTPMethod   string
+		Template     *Template
+		Body         string
+		ResponseBody string
+	}
+
+	// Table is the bindings of the google.api.http options of the rpc methods.
+	Table struct {
+		bindings []*Binding // the more specific first
+	}
+)
+
+// NewTable creates the bindings of the methods of the services, the invalid options are skipped.
+func NewTable(services map[string]*desc.ServiceDescriptor) *Table {
+	names := make([]string, 0, len(services))
+	for k := range services {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	t := &Table{}
+	for _, name := range names {
+		for _, m := range services[name].GetMethods() {
+			rule := httpRule(m)
+			if rule == nil {
+				continue
+			}
+
+			for _, v := range append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...) {
+				b, err := newBinding(m, v)
+				if err != nil {
+					mainLog.Warnf("Skip http binding of %s: %v", m.GetFullyQualifiedName(), err)
+					continue
+				}
+
+				t.bindings = append(t.bindings, b)
+			}
+		}
+	}
+
+	sort.SliceStable(t.bindings, func(i, j int) bool {
+		return t.bindings[i].Template.literals() > t.bindings[j].Template.literals()
+	})
+
+	return t
+}
+
+// Match returns the binding of the request and the values of the path variables.
+func (t *Table) Match(method, path string) (*Binding, map[string]string, bool) {
+	if t == nil {
+		return nil, nil, false
+	}
+
+	for _, v := range t.bindings {
+		if v.HTTPMethod != method {
+			continue
+		}
+
+		if vars, ok := v.Template.Match(path); ok {
+			return v, vars, true
+		}
+	}
+
+	return nil, nil, false
+}
+
+// Len returns the number of the bindings.
+func (t *Table) Len() int {
+	if t == nil {
+		return 0
+	}
+
+	return len(t.bindings)
+}
+
+func newBinding(m *desc.MethodDescriptor, rule *annotations.HttpRule) (*Binding, error) {
+	var method, path string
+	switch v := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		method, path = http.MethodGet, v.Get
+	case *annotations.HttpRule_Put:
+		method, path = http.MethodPut, v.Put
+	case *annotations.HttpRule_Post:
+		method, path = http.MethodPost, v.Post
+	case *annotations.HttpRule_Delete:
+		method, path = http.MethodDelete, v.Delete
+	case *annotations.HttpRule_Patch:
+		method, path = http.MethodPatch, v.Patch
+	case *annotations.HttpRule_Custom:
+		method, path = strings.ToUpper(v.Custom.GetKind()), v.Custom.GetPath()
+	default:
+		return nil, fmt.Errorf("no http pattern")
+	}
+
+	template, err := ParseTemplate(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range template.variables {
+		if _, err := fieldPath(m.GetInputType(), v.field); err != nil {
+			return nil, err
+		}
+	}
+
+	if body := rule.GetBody(); body != "" && body != "*" && m.GetInputType().FindFieldByName(body) == nil {
+		return nil, fmt.Errorf("body field %s not found", body)
+	}
+
+	if body := rule.GetResponseBody(); body != "" && m.GetOutputType().FindFieldByName(body) == nil {
+		return nil, fmt.Errorf("response body field %s not found", body)
+	}
+
+	return &Binding{
+		Method:       m,
+		HTTPMethod:   method,
+		Template:     template,
+		Body:         rule.GetBody(),
+		ResponseBody: rule.GetResponseBody(),
+	}, nil
+}
+
+// httpRule returns the google.api.http option of the method, nil if none.
+func httpRule(m *desc.MethodDescriptor) *annotations.HttpRule {
+	opts := m.GetMethodOptions()
+	if opts == nil || !proto.HasExtension(opts, annotations.E_Http) {
+		return nil
+	}
+
+	rule, _ := proto.GetExtension(opts, annotations.E_Http).(*annotations.HttpRule)
+	return rule
+}
@@ -0,0 +1,269 @@
+package transcode
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/jsonpb"
+	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+)
+
+var InvalidRequestErr = errors.New("Invalid request.")
+
+// NewRequest creates the request message from the body, the path variables and the query parameters,
+// the path variables override the body, the query parameters set the fields bound by neither.
+func (b *Binding) NewRequest(vars map[string]string, query url.Values, body []byte) (*dynamic.Message, error) {
+	input := b.Method.GetInputType()
+	msg := dynamic.NewMessage(input)
+
+	bound := make(map[string]struct{}, len(vars)+1)
+
+	if len(body) > 0 && b.Body != "" {
+		if b.Body != "*" {
+			body = []byte(fmt.Sprintf(`{%q:%s}`, b.Body, body))
+			bound[b.Body] = struct{}{}
+		}
+
+		if err := msg.UnmarshalMergeJSON(body); err != nil {
+			return nil, fmt.Errorf("%w: %v", InvalidRequestErr, err)
+		}
+	}
+
+	for k, v := range vars {
+		fields, err := fieldPath(input, k)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", InvalidRequestErr, err)
+		}
+
+		if err := setField(msg, fields, []string{v}); err != nil {
+			return nil, err
+		}
+		bound[names(fields)] = struct{}{}
+	}
+
+	// the whole message is set by the body
+	if b.Body == "*" {
+		return msg, nil
+	}
+
+	for k, v := range query {
+		fields, err := fieldPath(input, k)
+		if err != nil {
+			// unknown parameters are ignored
+			continue
+		}
+
+		if isBound(bound, names(fields)) {
+			continue
+		}
+
+		if err := setField(msg, fields, v); err != nil {
+			return nil, err
+		}
+	}
+
+	return msg, nil
+}
+
+// MarshalResponse marshals the response body field of the message, the whole message if there is none.
+func MarshalResponse(msg *dynamic.Message, responseBody string) ([]byte, error) {
+	if responseBody == "" {
+		return msg.MarshalJSON()
+	}
+
+	fd := msg.GetMessageDescriptor().FindFieldByName(responseBody)
+	if fd == nil {
+		return nil, fmt.Errorf("response body field %s not found", responseBody)
+	}
+
+	// marshal a message of the field only to get the json of the field
+	field := dynamic.NewMessage(msg.GetMessageDescriptor())
+	if msg.HasField(fd) {
+		if err := field.TrySetField(fd, msg.GetField(fd)); err != nil {
+			return nil, err
+		}
+	}
+
+	// the defaults are emitted only for an unset field, not within the set ones
+	buf, err := field.MarshalJSONPB(&jsonpb.Marshaler{EmitDefaults: !msg.HasField(fd)})
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(buf, &fields); err != nil {
+		return nil, err
+	}
+
+	if v, ok := fields[fd.GetJSONName()]; ok {
+		return v, nil
+	}
+
+	return []byte("null"), nil
+}
+
+// fieldPath returns the fields of the dotted path, the names are the proto or the json names.
+func fieldPath(md *desc.MessageDescriptor, path string) ([]*desc.FieldDescriptor, error) {
+	parts := strings.Split(path, ".")
+
+	fields := make([]*desc.FieldDescriptor, 0, len(parts))
+	for i, v := range parts {
+		if md == nil {
+			return nil, fmt.Errorf("field %s is not a message", strings.Join(parts[:i], "."))
+		}
+
+		fd := findField(md, v)
+		if fd == nil {
+			return nil, fmt.Errorf("field %s not found in %s", v, md.GetFullyQualifiedName())
+		}
+
+		if i < len(parts)-1 && (fd.IsRepeated() || fd.GetMessageType() == nil) {
+			return nil, fmt.Errorf("field %s is not a message", v)
+		}
+
+		fields = append(fields, fd)
+		md = fd.GetMessageType()
+	}
+
+	return fields, nil
+}
+
+func findField(md *desc.MessageDescriptor, name string) *desc.FieldDescriptor {
+	if fd := md.FindFieldByName(name); fd != nil {
+		return fd
+	}
+
+	for _, v := range md.GetFields() {
+		if v.GetJSONName() == name {
+			return v
+		}
+	}
+
+	return nil
+}
+
+// setField sets the values of the last field, the messages along the path are created if unset.
+func setField(msg *dynamic.Message, fields []*desc.FieldDescriptor, values []string) error {
+	for _, fd := range fields[:len(fields)-1] {
+		v, err := msg.TryGetField(fd)
+		if err != nil {
+			return err
+		}
+
+		sub, ok := v.(*dynamic.Message)
+		if !ok || sub == nil {
+			sub = dynamic.NewMessage(fd.GetMessageType())
+			if err := msg.TrySetField(fd, sub); err != nil {
+				return err
+			}
+		}
+		msg = sub
+	}
+
+	fd := fields[len(fields)-1]
+	if fd.IsMap() {
+		return fmt.Errorf("%w: map field %s is not supported", InvalidRequestErr, fd.GetName())
+	}
+
+	if !fd.IsRepeated() {
+		values = values[:1]
+	}
+
+	for _, v := range values {
+		value, err := parseValue(fd, v)
+		if err != nil {
+			return fmt.Errorf("%w: field %s: %v", InvalidRequestErr, fd.GetName(), err)
+		}
+
+		if fd.IsRepeated() {
+			err = msg.TryAddRepeatedField(fd, value)
+		} else {
+			err = msg.TrySetField(fd, value)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%w: field %s: %v", InvalidRequestErr, fd.GetName(), err)
+		}
+	}
+
+	return nil
+}
+
+// parseValue parses the string as the type of the field, messages are parsed from their json string
+// forms, e.g. google.protobuf.Timestamp.
+func parseValue(fd *desc.FieldDescriptor, s string) (interface{}, error) {
+	switch fd.GetType() {
+	case dpb.FieldDescriptorProto_TYPE_STRING:
+		return s, nil
+	case dpb.FieldDescriptorProto_TYPE_BYTES:
+		if v, err := base64.StdEncoding.DecodeString(s); err == nil {
+			return v, nil
+		}
+		return base64.URLEncoding.DecodeString(s)
+	case dpb.FieldDescriptorProto_TYPE_BOOL:
+		return strconv.ParseBool(s)
+	case dpb.FieldDescriptorProto_TYPE_INT32, dpb.FieldDescriptorProto_TYPE_SINT32, dpb.FieldDescriptorProto_TYPE_SFIXED32:
+		v, err := strconv.ParseInt(s, 10, 32)
+		return int32(v), err
+	case dpb.FieldDescriptorProto_TYPE_INT64, dpb.FieldDescriptorProto_TYPE_SINT64, dpb.FieldDescriptorProto_TYPE_SFIXED64:
+		return strconv.ParseInt(s, 10, 64)
+	case dpb.FieldDescriptorProto_TYPE_UINT32, dpb.FieldDescriptorProto_TYPE_FIXED32:
+		v, err := strconv.ParseUint(s, 10, 32)
+		return uint32(v), err
+	case dpb.FieldDescriptorProto_TYPE_UINT64, dpb.FieldDescriptorProto_TYPE_FIXED64:
+		return strconv.ParseUint(s, 10, 64)
+	case dpb.FieldDescriptorProto_TYPE_FLOAT:
+		v, err := strconv.ParseFloat(s, 32)
+		return float32(v), err
+	case dpb.FieldDescriptorProto_TYPE_DOUBLE:
+		return strconv.ParseFloat(s, 64)
+	case dpb.FieldDescriptorProto_TYPE_ENUM:
+		if v := fd.GetEnumType().FindValueByName(s); v != nil {
+			return v.GetNumber(), nil
+		}
+
+		v, err := strconv.ParseInt(s, 10, 32)
+		return int32(v), err
+	case dpb.FieldDescriptorProto_TYPE_MESSAGE:
+		js, err := json.Marshal(s)
+		if err != nil {
+			return nil, err
+		}
+
+		msg := dynamic.NewMessage(fd.GetMessageType())
+		return msg, msg.UnmarshalJSON(js)
+	default:
+		return nil, fmt.Errorf("unsupported type %s", fd.GetType())
+	}
+}
+
+func names(fields []*desc.FieldDescriptor) string {
+	parts := make([]string, len(fields))
+	for i, v := range fields {
+		parts[i] = v.GetName()
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// isBound reports whether the field path or one of its parents is bound.
+func isBound(bound map[string]struct{}, path string) bool {
+	for {
+		if _, ok := bound[path]; ok {
+			return true
+		}
+
+		i := strings.LastIndexByte(path, '.')
+		if i < 0 {
+			return false
+		}
+		path = path[:i]
+	}
+}
@@ -0,0 +1,219 @@
+package transcode
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var InvalidTemplateErr = errors.New("Invalid path template.")
+
+type (
+	segmentKind int
+
+	segment struct {
+		kind    segmentKind
+		literal string
+	}
+
+	// variable captures the segments [start, end) of the path into the field.
+	variable struct {
+		field      string // field path, e.g. book.name
+		start, end int
+	}
+
+	// Template is a google.api.http path template, e.g. /v1/{name=shelves/*/books/*}:publish.
+	// The deep wildcard ** matches zero or more segments and must be the last segment.
+	Template struct {
+		raw       string
+		segments  []segment
+		variables []variable
+		verb      string
+	}
+)
+
+const (
+	literalSegment segmentKind = iota
+	wildcardSegment
+	deepWildcardSegment
+)
+
+// ParseTemplate parses the path template of an http rule.
+func ParseTemplate(s string) (*Template, error) {
+	if !strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("%w: %s must start with /", InvalidTemplateErr, s)
+	}
+
+	t := &Template{raw: s}
+
+	path, depth := s[1:], 0
+	for i, v := range path {
+		switch v {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ':':
+			if depth == 0 {
+				path, t.verb = path[:i], path[i+1:]
+			}
+		}
+
+		if t.verb != "" {
+			break
+		}
+	}
+
+	if strings.HasSuffix(s, ":") || strings.Contains(t.verb, "/") || depth != 0 {
+		return nil, fmt.Errorf("%w: %s", InvalidTemplateErr, s)
+	}
+
+	for _, v := range split(path) {
+		if !strings.HasPrefix(v, "{") {
+			if err := t.add(v); err != nil {
+				return nil, fmt.Errorf("%w: %s", err, s)
+			}
+			continue
+		}
+
+		if !strings.HasSuffix(v, "}") {
+			return nil, fmt.Errorf("%w: %s", InvalidTemplateErr, s)
+		}
+
+		field, pattern := v[1:len(v)-1], "*"
+		if i := strings.IndexByte(field, '='); i >= 0 {
+			field, pattern = field[:i], field[i+1:]
+		}
+
+		if field == "" || pattern == "" || strings.ContainsAny(pattern, "{}") {
+			return nil, fmt.Errorf("%w: %s", InvalidTemplateErr, s)
+		}
+
+		start := len(t.segments)
+		for _, p := range strings.Split(pattern, "/") {
+			if err := t.add(p); err != nil {
+				return nil, fmt.Errorf("%w: %s", err, s)
+			}
+		}
+
+		t.variables = append(t.variables, variable{field: field, start: start, end: len(t.segments)})
+	}
+
+	return t, nil
+}
+
+func (t *Template) add(s string) error {
+	if len(t.segments) > 0 && t.segments[len(t.segments)-1].kind == deepWildcardSegment {
+		return InvalidTemplateErr
+	}
+
+	switch s {
+	case "":
+		return InvalidTemplateErr
+	case "*":
+		t.segments = append(t.segments, segment{kind: wildcardSegment})
+	case "**":
+		t.segments = append(t.segments, segment{kind: deepWildcardSegment})
+	default:
+		t.segments = append(t.segments, segment{kind: literalSegment, literal: s})
+	}
+
+	return nil
+}
+
+// Match matches the escaped path, it returns the values of the variables by field path.
+func (t *Template) Match(path string) (map[string]string, bool) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, false
+	}
+	path = path[1:]
+
+	if t.verb != "" {
+		i := strings.LastIndexByte(path, ':')
+		if i < 0 || i < strings.LastIndexByte(path, '/') || path[i+1:] != t.verb {
+			return nil, false
+		}
+		path = path[:i]
+	}
+
+	parts := strings.Split(path, "/")
+	for i, v := range parts {
+		unescaped, err := url.PathUnescape(v)
+		if err != nil {
+			return nil, false
+		}
+		parts[i] = unescaped
+	}
+
+	deep := len(t.segments) > 0 && t.segments[len(t.segments)-1].kind == deepWildcardSegment
+	if deep && len(parts) < len(t.segments)-1 || !deep && len(parts) != len(t.segments) {
+		return nil, false
+	}
+
+	for i, v := range t.segments {
+		switch v.kind {
+		case literalSegment:
+			if parts[i] != v.literal {
+				return nil, false
+			}
+		case wildcardSegment:
+			if parts[i] == "" {
+				return nil, false
+			}
+		}
+	}
+
+	vars := make(map[string]string, len(t.variables))
+	for _, v := range t.variables {
+		end := v.end
+		if deep && end == len(t.segments) {
+			end = len(parts)
+		}
+
+		vars[v.field] = strings.Join(parts[v.start:end], "/")
+	}
+
+	return vars, true
+}
+
+// literals returns the number of literal segments, the more literals the more specific the template.
+func (t *Template) literals() int {
+	var n int
+	for _, v := range t.segments {
+		if v.kind == literalSegment {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (t *Template) String() string {
+	return t.raw
+}
+
+// split splits the path by the slashes out of the variables.
+func split(path string) []string {
+	var (
+		parts []string
+		depth int
+		start int
+	)
+
+	for i, v := range path {
+		switch v {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '/':
+			if depth == 0 {
+				parts = append(parts, path[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, path[start:])
+}
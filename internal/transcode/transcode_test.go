@@ -0,0 +1,264 @@
+package transcode
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/stretchr/testify/assert"
+)
+
+var protos = map[string]string{
+	"google/api/http.proto": `syntax = "proto3";
+package google.api;
+
+message HttpRule {
+  string selector = 1;
+  oneof pattern {
+    string get = 2;
+    string put = 3;
+    string post = 4;
+    string delete = 5;
+    string patch = 6;
+    CustomHttpPattern custom = 8;
+  }
+  string body = 7;
+  string response_body = 12;
+  repeated HttpRule additional_bindings = 11;
+}
+
+message CustomHttpPattern {
+  string kind = 1;
+  string path = 2;
+}
+`,
+	"google/api/annotations.proto": `syntax = "proto3";
+package google.api;
+
+import "google/api/http.proto";
+import "google/protobuf/descriptor.proto";
+
+extend google.protobuf.MethodOptions {
+  HttpRule http = 72295728;
+}
+`,
+	"library.proto": `syntax = "proto3";
+package library;
+
+import "google/api/annotations.proto";
+import "google/protobuf/timestamp.proto";
+
+service Library {
+  rpc GetBook (GetBookRequest) returns (Book) {
+    option (google.api.http) = {
+      get: "/v1/{name=shelves/*/books/*}"
+      additional_bindings { get: "/v1/books/{name}" }
+    };
+  }
+  rpc GetLatest (GetBookRequest) returns (Book) {
+    option (google.api.http) = { get: "/v1/books/latest" };
+  }
+  rpc ListBooks (ListBooksRequest) returns (ListBooksResponse) {
+    option (google.api.http) = { get: "/v1/shelves/{shelf.id}/books" response_body: "books" };
+  }
+  rpc UpdateBook (UpdateBookRequest) returns (Book) {
+    option (google.api.http) = { patch: "/v1/books/{book.name=**}" body: "book" };
+  }
+  rpc CreateBook (Book) returns (Book) {
+    option (google.api.http) = { post: "/v1/books:create" body: "*" };
+  }
+  rpc Internal (Book) returns (Book);
+}
+
+enum Genre {
+  UNKNOWN = 0;
+  FICTION = 1;
+}
+
+message Book {
+  string name = 1;
+  string title = 2;
+  Genre genre = 3;
+  google.protobuf.Timestamp published = 4;
+}
+
+message Shelf {
+  int64 id = 1;
+}
+
+message GetBookRequest {
+  string name = 1;
+}
+
+message ListBooksRequest {
+  Shelf shelf = 1;
+  int32 page_size = 2;
+  repeated Genre genres = 3;
+  google.protobuf.Timestamp since = 4;
+}
+
+message ListBooksResponse {
+  repeated Book books = 1;
+  string next_page_token = 2;
+}
+
+message UpdateBookRequest {
+  Book book = 1;
+  bool validate_only = 2;
+}
+`,
+}
+
+func newTable(t *testing.T) *Table {
+	parser := protoparse.Parser{Accessor: protoparse.FileContentsFromMap(protos)}
+
+	fds, err := parser.ParseFiles("library.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewTable(map[string]*desc.ServiceDescriptor{
+		"library.Library": fds[0].FindService("library.Library"),
+	})
+}
+
+func newMessage(t *testing.T, md *desc.MessageDescriptor, js string) *dynamic.Message {
+	msg := dynamic.NewMessage(md)
+	if err := msg.UnmarshalJSON([]byte(js)); err != nil {
+		t.Fatal(err)
+	}
+
+	return msg
+}
+
+func TestParseTemplate(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, v := range []string{"v1/books", "/v1/{name", "/v1/**/books", "/v1//books", "/v1/books:", "/v1/{=*}"} {
+		_, err := ParseTemplate(v)
+		assert.ErrorIs(err, InvalidTemplateErr, v)
+	}
+
+	tpl, err := ParseTemplate("/v1/{name=shelves/*/books/*}:publish")
+	assert.Nil(err)
+
+	vars, ok := tpl.Match("/v1/shelves/1/books/a%2Fb:publish")
+	assert.True(ok)
+	assert.Equal(map[string]string{"name": "shelves/1/books/a/b"}, vars)
+
+	for _, v := range []string{"/v1/shelves/1/books/2", "/v1/shelves/1/books:publish", "/v1/shelves//books/2:publish"} {
+		_, ok = tpl.Match(v)
+		assert.False(ok, v)
+	}
+
+	tpl, err = ParseTemplate("/v1/files/{path=**}")
+	assert.Nil(err)
+
+	vars, ok = tpl.Match("/v1/files/a/b/c")
+	assert.True(ok)
+	assert.Equal("a/b/c", vars["path"])
+
+	vars, ok = tpl.Match("/v1/files")
+	assert.True(ok)
+	assert.Equal("", vars["path"])
+}
+
+func TestTable(t *testing.T) {
+	assert := assert.New(t)
+
+	table := newTable(t)
+	assert.Equal(6, table.Len())
+
+	b, vars, ok := table.Match(http.MethodGet, "/v1/shelves/1/books/2")
+	assert.True(ok)
+	assert.Equal("GetBook", b.Method.GetName())
+	assert.Equal("shelves/1/books/2", vars["name"])
+
+	// additional bindings
+	b, vars, ok = table.Match(http.MethodGet, "/v1/books/2")
+	assert.True(ok)
+	assert.Equal("GetBook", b.Method.GetName())
+	assert.Equal("2", vars["name"])
+
+	// the literals win over the variables
+	b, _, ok = table.Match(http.MethodGet, "/v1/books/latest")
+	assert.True(ok)
+	assert.Equal("GetLatest", b.Method.GetName())
+
+	b, _, ok = table.Match(http.MethodPost, "/v1/books:create")
+	assert.True(ok)
+	assert.Equal("CreateBook", b.Method.GetName())
+
+	_, _, ok = table.Match(http.MethodPost, "/v1/books/2")
+	assert.False(ok)
+}
+
+func TestNewRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	table := newTable(t)
+
+	// path variables and query parameters by proto or json names
+	b, vars, _ := table.Match(http.MethodGet, "/v1/shelves/7/books")
+	query := url.Values{
+		"pageSize":   {"10"},
+		"genres":     {"FICTION", "0"},
+		"since":      {"2022-06-01T00:00:00Z"},
+		"shelf.id":   {"8"},
+		"unknown":    {"x"},
+		"page_token": {"y"},
+	}
+
+	msg, err := b.NewRequest(vars, query, nil)
+	assert.Nil(err)
+
+	js, err := msg.MarshalJSON()
+	assert.Nil(err)
+	assert.JSONEq(`{"shelf":{"id":"7"},"pageSize":10,"genres":["FICTION","UNKNOWN"],"since":"2022-06-01T00:00:00Z"}`, string(js))
+
+	_, err = b.NewRequest(vars, url.Values{"page_size": {"ten"}}, nil)
+	assert.ErrorIs(err, InvalidRequestErr)
+
+	// the body field and the deep path variable
+	b, vars, _ = table.Match(http.MethodPatch, "/v1/books/shelves/1/books/2")
+	msg, err = b.NewRequest(vars, url.Values{"validateOnly": {"true"}, "book.title": {"ignored"}}, []byte(`{"title":"Go","name":"other"}`))
+	assert.Nil(err)
+
+	js, err = msg.MarshalJSON()
+	assert.Nil(err)
+	assert.JSONEq(`{"book":{"name":"shelves/1/books/2","title":"Go"},"validateOnly":true}`, string(js))
+
+	// the whole body and no query parameters
+	b, vars, _ = table.Match(http.MethodPost, "/v1/books:create")
+	msg, err = b.NewRequest(vars, url.Values{"title": {"ignored"}}, []byte(`{"title":"Go","genre":"FICTION"}`))
+	assert.Nil(err)
+
+	js, err = msg.MarshalJSON()
+	assert.Nil(err)
+	assert.JSONEq(`{"title":"Go","genre":"FICTION"}`, string(js))
+
+	_, err = b.NewRequest(vars, nil, []byte(`{"title":`))
+	assert.ErrorIs(err, InvalidRequestErr)
+}
+
+func TestMarshalResponse(t *testing.T) {
+	assert := assert.New(t)
+
+	b, _, _ := newTable(t).Match(http.MethodGet, "/v1/shelves/7/books")
+
+	output := b.Method.GetOutputType()
+	js, err := MarshalResponse(newMessage(t, output, `{"books":[{"title":"Go"}],"nextPageToken":"n"}`), b.ResponseBody)
+	assert.Nil(err)
+	assert.JSONEq(`[{"title":"Go"}]`, string(js))
+
+	js, err = MarshalResponse(newMessage(t, output, `{}`), b.ResponseBody)
+	assert.Nil(err)
+	assert.JSONEq(`[]`, string(js))
+
+	js, err = MarshalResponse(newMessage(t, output, `{"nextPageToken":"n"}`), "")
+	assert.Nil(err)
+	assert.JSONEq(`{"nextPageToken":"n"}`, string(js))
+}
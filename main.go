@@ -1,7 +1,7 @@
 package main
 
-import "github.com/KKKKjl/tinykit/internal/server"
+import "github.com/KKKKjl/tinykit/cmd"
 
 func main() {
-	server.Start()
+	cmd.Execute()
 }
@@ -1,7 +1,11 @@
 package utils
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
@@ -72,3 +76,31 @@ func ValidateConfig(config interface{}) bool {
 
 	return true
 }
+
+// NewTLSConfig loads a client tls config from the client certificate and the ca of the server, all files are optional.
+func NewTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	c := &tls.Config{}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate in %s", caFile)
+		}
+		c.RootCAs = pool
+	}
+
+	return c, nil
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// wait for the editor to finish writing before reloading
+const _watchDebounce = 100 * time.Millisecond
+
+// WatchFile calls reload once the file settles after a change, until ctx is done. The errors of the
+// watcher are passed to onError, nil onError ignores them.
+func WatchFile(ctx context.Context, file string, reload func(), onError func(error)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	// watch the whole directory to pick up renames and atomic saves
+	file = filepath.Clean(file)
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	// resolved before returning, the changes made once watching are not missed
+	realFile, _ := filepath.EvalSymlinks(file)
+
+	go func() {
+		defer watcher.Close()
+
+		debounce := time.NewTimer(_watchDebounce)
+		debounce.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				// the real path changes when a k8s ConfigMap is replaced
+				currentFile, _ := filepath.EvalSymlinks(file)
+				if filepath.Clean(event.Name) == file && event.Op&(fsnotify.Write|fsnotify.Create) != 0 ||
+					currentFile != "" && currentFile != realFile {
+					realFile = currentFile
+					debounce.Reset(_watchDebounce)
+				}
+			case <-debounce.C:
+				reload()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+
+				if onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
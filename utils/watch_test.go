@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchFile(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+
+	// laid out like a k8s ConfigMap volume, the file links to the current data dir
+	for _, v := range []string{"..v1", "..v2"} {
+		assert.Nil(os.Mkdir(filepath.Join(dir, v), 0755))
+		assert.Nil(ioutil.WriteFile(filepath.Join(dir, v, "config.yaml"), []byte(v), 0644))
+	}
+	assert.Nil(os.Symlink("..v1", filepath.Join(dir, "..data")))
+	assert.Nil(os.Symlink(filepath.Join("..data", "config.yaml"), file))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var reloads int32
+	assert.Nil(WatchFile(ctx, file, func() { atomic.AddInt32(&reloads, 1) }, nil))
+
+	// the data dir is replaced
+	assert.Nil(os.Symlink("..v2", filepath.Join(dir, "..data_tmp")))
+	assert.Nil(os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+
+	assert.Eventually(func() bool { return atomic.LoadInt32(&reloads) == 1 }, time.Second, 10*time.Millisecond)
+
+	// the changes of the other files are ignored
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, "other.yaml"), nil, 0644))
+	time.Sleep(3 * _watchDebounce)
+	assert.Equal(int32(1), atomic.LoadInt32(&reloads))
+}